@@ -1,70 +1,308 @@
+// Command benchmark_client measures the latency and throughput of the
+// pw.rpc.Benchmark echo service.
+//
+//	benchmark_client [-mode unary|bidi|all] [-size n,...] [-concurrency n] [-duration d] [-json file] [endpoint]
+//
+// The endpoint is a benchmark_server address (localhost:8111 by default), a
+// serial device, a URI such as tcp://host:port, unix:///run/bench.sock,
+// serial:///dev/ttyUSB0?baud=115200, udp://host:port or ws://host:port/path,
+// or "mem", which echoes in the same process over an in-memory pipe to
+// measure pw_rpc and pw_hdlc without a transport.
+//
+// With -json the results are also written to a file, as a JSON array, for
+// tracking them across changes.
 package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/robertfarnum/go-pw-rpc/cmd/internal/cli"
 	"github.com/robertfarnum/go-pw-rpc/cmd/pb"
 	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpctest"
+	"google.golang.org/grpc"
 )
 
-func RunUnary(ctx context.Context, bc pb.BenchmarkClient, count int) {
-	for i := 0; i < count; i++ {
-		str := fmt.Sprintf("Hello #%d", i)
-		in := &pb.Payload{
-			Payload: []byte(str),
-		}
+const kMemEndpoint = "mem"
+
+type BenchmarkServer struct {
+	pb.UnsafeBenchmarkServer
+}
 
-		fmt.Printf("Sending UnaryEcho = %s\n", str)
+func (bs BenchmarkServer) UnaryEcho(ctx context.Context, payload *pb.Payload) (*pb.Payload, error) {
+	return &pb.Payload{
+		Payload: payload.GetPayload(),
+	}, nil
+}
 
-		out, err := bc.UnaryEcho(ctx, in)
-		if err != nil {
-			fmt.Println("UnaryEcho error:", err)
-			os.Exit(1)
+func (bs BenchmarkServer) BidirectionalEcho(s grpc.BidiStreamingServer[pb.Payload, pb.Payload]) error {
+	for {
+		payload, err := s.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
 		}
 
-		fmt.Printf("Received UnaryEcho = %s\n", string(out.Payload))
+		if err := s.Send(payload); err != nil {
+			return err
+		}
+	}
+}
+
+// Result is the outcome of one benchmark run.
+type Result struct {
+	Mode           string        `json:"mode"`
+	Endpoint       string        `json:"endpoint"`
+	PayloadSize    int           `json:"payload_size"`
+	Concurrency    int           `json:"concurrency"`
+	Duration       time.Duration `json:"duration_ns"`
+	Calls          int           `json:"calls"`
+	Errors         int           `json:"errors"`
+	P50            time.Duration `json:"p50_ns"`
+	P95            time.Duration `json:"p95_ns"`
+	P99            time.Duration `json:"p99_ns"`
+	BytesPerSecond float64       `json:"bytes_per_second"`
+}
+
+func (r *Result) String() string {
+	return fmt.Sprintf("%-5s size=%-6d concurrency=%-3d calls=%-7d errors=%-4d p50=%-10s p95=%-10s p99=%-10s %.1f KiB/s",
+		r.Mode, r.PayloadSize, r.Concurrency, r.Calls, r.Errors,
+		r.P50.Round(time.Microsecond), r.P95.Round(time.Microsecond), r.P99.Round(time.Microsecond),
+		r.BytesPerSecond/1024)
+}
+
+// percentile returns the p'th percentile of sorted latencies, by the nearest
+// rank.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+
+	return sorted[max(rank, 0)]
+}
+
+// worker makes calls until the benchmark ends, recording the latency of
+// each echo.
+type worker func(ctx context.Context, bc pb.BenchmarkClient, payload []byte, until time.Time) (latencies []time.Duration, errs int)
+
+func unaryWorker(ctx context.Context, bc pb.BenchmarkClient, payload []byte, until time.Time) ([]time.Duration, int) {
+	var latencies []time.Duration
+	errs := 0
+
+	for time.Now().Before(until) {
+		start := time.Now()
+
+		out, err := bc.UnaryEcho(ctx, &pb.Payload{Payload: payload})
+		if err != nil || len(out.GetPayload()) != len(payload) {
+			errs++
+			continue
+		}
 
-		time.Sleep(time.Second)
+		latencies = append(latencies, time.Since(start))
 	}
+
+	return latencies, errs
 }
 
-func RunBiDirectional(ctx context.Context, bc pb.BenchmarkClient, count int) error {
-	bid, err := bc.BidirectionalEcho(ctx)
+func bidiWorker(ctx context.Context, bc pb.BenchmarkClient, payload []byte, until time.Time) ([]time.Duration, int) {
+	var latencies []time.Duration
+
+	stream, err := bc.BidirectionalEcho(ctx)
 	if err != nil {
-		return err
+		return nil, 1
 	}
 
-	go func() {
-		for i := 0; i < count; i++ {
-			str := fmt.Sprintf("Message %d", i)
-			fmt.Printf("Sending BiDirectional = %s\n", str)
-			bid.Send(&pb.Payload{
-				Payload: []byte(str),
-			})
+	for time.Now().Before(until) {
+		start := time.Now()
+
+		if err := stream.Send(&pb.Payload{Payload: payload}); err != nil {
+			return latencies, 1
 		}
-	}()
 
-	for {
-		out, err := bid.Recv()
-		if err != nil {
-			break
+		out, err := stream.Recv()
+		if err != nil || len(out.GetPayload()) != len(payload) {
+			return latencies, 1
 		}
 
-		fmt.Printf("Received BiDirectional = %s\n", string(out.Payload))
+		latencies = append(latencies, time.Since(start))
 	}
 
-	return nil
+	if err := stream.CloseSend(); err != nil {
+		return latencies, 1
+	}
+
+	if _, err := stream.Recv(); err != io.EOF {
+		return latencies, 1
+	}
+
+	return latencies, 0
+}
+
+var kWorkers = map[string]worker{
+	"unary": unaryWorker,
+	"bidi":  bidiWorker,
+}
+
+func benchmark(ctx context.Context, bc pb.BenchmarkClient, mode string, size int, concurrency int, duration time.Duration) *Result {
+	payload := make([]byte, size)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var latencies []time.Duration
+	errs := 0
+
+	start := time.Now()
+	until := start.Add(duration)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			l, e := kWorkers[mode](ctx, bc, payload, until)
+
+			mu.Lock()
+			latencies = append(latencies, l...)
+			errs += e
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	slices.Sort(latencies)
+
+	return &Result{
+		Mode:        mode,
+		PayloadSize: size,
+		Concurrency: concurrency,
+		Duration:    elapsed,
+		Calls:       len(latencies),
+		Errors:      errs,
+		P50:         percentile(latencies, 50),
+		P95:         percentile(latencies, 95),
+		P99:         percentile(latencies, 99),
+		// Each echo carries the payload there and back.
+		BytesPerSecond: float64(2*size*len(latencies)) / elapsed.Seconds(),
+	}
+}
+
+func parseSizes(s string) ([]int, error) {
+	var sizes []int
+
+	for _, field := range strings.Split(s, ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("bad payload size: %q", field)
+		}
+		sizes = append(sizes, size)
+	}
+
+	return sizes, nil
+}
+
+func dial(ctx context.Context, endpoint string) (pw_rpc.Client, error) {
+	if endpoint == kMemEndpoint {
+		s := pw_rpc.NewServer("")
+		pb.RegisterBenchmarkServer(s, &BenchmarkServer{})
+
+		return pw_rpctest.NewServerClient(ctx, s)
+	}
+
+	c := pw_rpc.NewClientWithDialer(cli.Dialer(endpoint))
+	if err := c.Connect(ctx); err != nil {
+		return nil, err
+	}
+
+	return c, nil
 }
 
 func main() {
-	ctx := context.Background()
-	cc := pw_rpc.NewClient("localhost:8111")
-	bc := pb.NewBenchmarkClient(cc)
+	fs := flag.NewFlagSet("benchmark_client", flag.ExitOnError)
+	mode := fs.String("mode", "all", "calls to measure: unary, bidi or all")
+	sizeList := fs.String("size", "64", "comma separated payload sizes in bytes")
+	concurrency := fs.Int("concurrency", 1, "number of concurrent callers")
+	duration := fs.Duration("duration", 5*time.Second, "how long to measure each payload size")
+	jsonPath := fs.String("json", "", "write the results as JSON to this file")
+	fs.Parse(os.Args[1:])
+
+	endpoint := "localhost:8111"
+	if fs.NArg() > 0 {
+		endpoint = fs.Arg(0)
+	}
 
-	RunUnary(ctx, bc, 10)
+	if err := run(endpoint, *mode, *sizeList, *concurrency, *duration, *jsonPath); err != nil {
+		fmt.Fprintf(os.Stderr, "benchmark_client: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(endpoint string, mode string, sizeList string, concurrency int, duration time.Duration, jsonPath string) error {
+	sizes, err := parseSizes(sizeList)
+	if err != nil {
+		return err
+	}
+
+	modes := []string{mode}
+	if mode == "all" {
+		modes = []string{"unary", "bidi"}
+	} else if _, ok := kWorkers[mode]; !ok {
+		return fmt.Errorf("unknown mode: %q", mode)
+	}
+
+	if concurrency < 1 {
+		return errors.New("concurrency must be at least 1")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := dial(ctx, endpoint)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	bc := pb.NewBenchmarkClient(c)
+
+	var results []*Result
+	for _, mode := range modes {
+		for _, size := range sizes {
+			result := benchmark(ctx, bc, mode, size, concurrency, duration)
+			result.Endpoint = endpoint
+			results = append(results, result)
+
+			fmt.Println(result)
+		}
+	}
+
+	if jsonPath == "" {
+		return nil
+	}
+
+	buf, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
 
-	//RunBiDirectional(ctx, bc, 10)
+	return os.WriteFile(jsonPath, append(buf, '\n'), 0644)
 }
@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/robertfarnum/go-pw-rpc/cmd/pb"
 	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
@@ -11,10 +14,13 @@ import (
 
 type BenchmarkServer struct {
 	pb.UnsafeBenchmarkServer
+	verbose bool
 }
 
 func (bs BenchmarkServer) UnaryEcho(ctx context.Context, payload *pb.Payload) (*pb.Payload, error) {
-	fmt.Printf("Received UnaryEcho = %s\n", string(payload.Payload))
+	if bs.verbose {
+		fmt.Printf("Received UnaryEcho = %s\n", string(payload.Payload))
+	}
 
 	return &pb.Payload{
 		Payload: payload.GetPayload(),
@@ -22,17 +28,35 @@ func (bs BenchmarkServer) UnaryEcho(ctx context.Context, payload *pb.Payload) (*
 }
 
 func (bs BenchmarkServer) BidirectionalEcho(s grpc.BidiStreamingServer[pb.Payload, pb.Payload]) error {
-	fmt.Printf("Received BidirectionalEcho\n")
+	if bs.verbose {
+		fmt.Printf("Received BidirectionalEcho\n")
+	}
 
-	return nil
+	for {
+		payload, err := s.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := s.Send(payload); err != nil {
+			return err
+		}
+	}
 }
 
 func main() {
+	fs := flag.NewFlagSet("benchmark_server", flag.ExitOnError)
+	listen := fs.String("listen", "localhost:8111", "address to listen on")
+	verbose := fs.Bool("v", false, "print every call, which slows the benchmark")
+	fs.Parse(os.Args[1:])
+
 	ctx := context.Background()
 
-	s := pw_rpc.NewServer("localhost:8111")
+	s := pw_rpc.NewServer(*listen)
 
-	bs := &BenchmarkServer{}
+	bs := &BenchmarkServer{verbose: *verbose}
 
 	pb.RegisterBenchmarkServer(s, bs)
 
@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+)
+
+// IsStdio reports whether endpoint selects the process' stdin and stdout.
+func IsStdio(endpoint string) bool {
+	return endpoint == "-" || endpoint == "stdio"
+}
+
+// Dialer returns a pw_rpc.Dialer for endpoint, which is either "-" (or
+// "stdio"), a serial device path such as /dev/ttyUSB0, or an endpoint URI
+// of pw_rpc.ParseEndpoint such as unix:///run/dev.sock, ws://host:8080/rpc
+// or a TCP host:port.
+//
+// Serial device paths are used with their current line settings; configure
+// the baud rate with stty, or use serial:///dev/ttyUSB0?baud=115200.
+func Dialer(endpoint string) pw_rpc.Dialer {
+	return func(ctx context.Context) (io.ReadWriteCloser, error) {
+		switch {
+		case IsStdio(endpoint):
+			return &stdio{}, nil
+		case strings.HasPrefix(endpoint, "/"):
+			return os.OpenFile(endpoint, os.O_RDWR|syscall.O_NOCTTY, 0)
+		default:
+			return pw_rpc.Dial(ctx, endpoint)
+		}
+	}
+}
+
+type stdio struct{}
+
+func (s *stdio) Read(p []byte) (int, error) {
+	return os.Stdin.Read(p)
+}
+
+func (s *stdio) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+func (s *stdio) Close() error {
+	return os.Stdin.Close()
+}
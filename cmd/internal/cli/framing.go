@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_cobs"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_hdlc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+)
+
+// kFramings are the framings of a -framing flag.
+var kFramings = map[string]pw_rpc.FrameTransport{
+	"hdlc":          pw_rpc.HdlcFraming,
+	"hdlc-reliable": pw_rpc.NewReliableHdlcFraming(pw_hdlc.LinkConfig{}),
+	"cobs":          pw_rpc.NewCobsFraming(pw_cobs.ChecksumNone),
+	"cobs-crc16":    pw_rpc.NewCobsFraming(pw_cobs.Crc16),
+	"cobs-crc32":    pw_rpc.NewCobsFraming(pw_cobs.Crc32),
+	"length":        pw_rpc.LengthPrefixedFraming,
+	"datagram":      pw_rpc.DatagramFraming,
+}
+
+// FramingUsage is the usage of a -framing flag.
+const FramingUsage = "packet framing: hdlc, hdlc-reliable, cobs, cobs-crc16, cobs-crc32, length or datagram (default: datagram over UDP, hdlc otherwise)"
+
+// Framing returns the framing named by a -framing flag, or nil for the
+// default framing of the endpoint when name is empty.
+func Framing(name string) (pw_rpc.FrameTransport, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	framing, ok := kFramings[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown framing %q", name)
+	}
+
+	return framing, nil
+}
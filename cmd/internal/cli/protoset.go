@@ -0,0 +1,146 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// ProtoFlags is the value of a repeatable -proto flag, which also takes a
+// comma separated list of files.
+type ProtoFlags []string
+
+func (p *ProtoFlags) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *ProtoFlags) Set(v string) error {
+	*p = append(*p, strings.Split(v, ",")...)
+	return nil
+}
+
+// LoadProtos builds a registry from .proto sources and compiled descriptor
+// sets. Sources are compiled with protoc, which must be on the PATH; any
+// other file is read as a FileDescriptorSet, as written by
+// `protoc --include_imports --descriptor_set_out`.
+//
+// The services are also added to pw_rpc.DefaultRegistry, so that diagnostics
+// name them.
+func LoadProtos(paths []string) (*protoregistry.Files, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	seen := map[string]bool{}
+
+	for _, path := range paths {
+		fds, err := readDescriptorSet(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		for _, fd := range fds.File {
+			if seen[fd.GetName()] {
+				continue
+			}
+			seen[fd.GetName()] = true
+			set.File = append(set.File, fd)
+		}
+	}
+
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, err
+	}
+
+	var rerr error
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		rerr = pw_rpc.DefaultRegistry.RegisterFile(fd)
+		return rerr == nil
+	})
+
+	return files, rerr
+}
+
+func readDescriptorSet(path string) (*descriptorpb.FileDescriptorSet, error) {
+	var buf []byte
+	var err error
+
+	if filepath.Ext(path) == ".proto" {
+		buf, err = exec.Command("protoc",
+			"--include_imports",
+			"--descriptor_set_out=/dev/stdout",
+			"-I", filepath.Dir(path),
+			path).Output()
+	} else {
+		buf, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fds := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(buf, fds); err != nil {
+		return nil, err
+	}
+
+	return fds, nil
+}
+
+// Services returns every service in files, sorted by full name.
+func Services(files *protoregistry.Files) []protoreflect.ServiceDescriptor {
+	var services []protoreflect.ServiceDescriptor
+
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		for i := 0; i < fd.Services().Len(); i++ {
+			services = append(services, fd.Services().Get(i))
+		}
+		return true
+	})
+
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].FullName() < services[j].FullName()
+	})
+
+	return services
+}
+
+// FindMethod resolves "pkg.Service/Method" (or "pkg.Service.Method") to its
+// descriptor.
+func FindMethod(files *protoregistry.Files, name string) (protoreflect.MethodDescriptor, error) {
+	name = strings.TrimPrefix(name, "/")
+
+	i := strings.LastIndexAny(name, "/.")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid method name: %q", name)
+	}
+
+	d, err := files.FindDescriptorByName(protoreflect.FullName(name[:i]))
+	if err != nil {
+		return nil, fmt.Errorf("service not found: %q", name[:i])
+	}
+
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("not a service: %q", name[:i])
+	}
+
+	md := sd.Methods().ByName(protoreflect.Name(name[i+1:]))
+	if md == nil {
+		return nil, fmt.Errorf("method not found: %q", name)
+	}
+
+	return md, nil
+}
+
+// FullMethod returns the gRPC style "/pkg.Service/Method" name of md.
+func FullMethod(md protoreflect.MethodDescriptor) string {
+	return fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name())
+}
@@ -0,0 +1,67 @@
+// Code generated by protoc-gen-go-pwrpc. DO NOT EDIT.
+// source: benchmark.proto
+
+package pb
+
+import (
+	context "context"
+	pw_rpc "github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	grpc "google.golang.org/grpc"
+)
+
+// pw_rpc ids of the pw.rpc.Benchmark service and its methods.
+const (
+	Benchmark_ServiceId                  pw_rpc.Key = 0xd7d70c1d
+	Benchmark_UnaryEcho_MethodId         pw_rpc.Key = 0x024e8b55
+	Benchmark_BidirectionalEcho_MethodId pw_rpc.Key = 0x651fd4a9
+)
+
+// Colliding method ids are duplicate keys, which fail to compile.
+var _ = map[pw_rpc.Key]bool{
+	Benchmark_UnaryEcho_MethodId:         true,
+	Benchmark_BidirectionalEcho_MethodId: true,
+}
+
+// BenchmarkPwrpcClient is the pw_rpc client of the pw.rpc.Benchmark service.
+type BenchmarkPwrpcClient interface {
+	UnaryEcho(ctx context.Context, in *Payload, opts ...grpc.CallOption) (*pw_rpc.UnaryCall[Payload], error)
+	BidirectionalEcho(ctx context.Context, opts ...grpc.CallOption) (*pw_rpc.BidiStreamingCall[Payload, Payload], error)
+}
+
+type benchmarkPwrpcClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewBenchmarkPwrpcClient(cc grpc.ClientConnInterface) BenchmarkPwrpcClient {
+	return &benchmarkPwrpcClient{cc}
+}
+
+func (c *benchmarkPwrpcClient) UnaryEcho(ctx context.Context, in *Payload, opts ...grpc.CallOption) (*pw_rpc.UnaryCall[Payload], error) {
+	call := &pw_rpc.Call{}
+	opts = append([]grpc.CallOption{
+		pw_rpc.WithMethodIds(Benchmark_ServiceId, Benchmark_UnaryEcho_MethodId),
+		pw_rpc.WithCall(call),
+	}, opts...)
+	out := new(Payload)
+	err := c.cc.Invoke(ctx, "/pw.rpc.Benchmark/UnaryEcho", in, out, opts...)
+	return &pw_rpc.UnaryCall[Payload]{Call: call, Response: out}, err
+}
+
+func (c *benchmarkPwrpcClient) BidirectionalEcho(ctx context.Context, opts ...grpc.CallOption) (*pw_rpc.BidiStreamingCall[Payload, Payload], error) {
+	call := &pw_rpc.Call{}
+	opts = append([]grpc.CallOption{
+		pw_rpc.WithMethodIds(Benchmark_ServiceId, Benchmark_BidirectionalEcho_MethodId),
+		pw_rpc.WithCall(call),
+	}, opts...)
+	desc := &grpc.StreamDesc{
+		StreamName:    "BidirectionalEcho",
+		ServerStreams: true,
+		ClientStreams: true,
+	}
+	stream, err := c.cc.NewStream(ctx, desc, "/pw.rpc.Benchmark/BidirectionalEcho", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Payload, Payload]{ClientStream: stream}
+	return &pw_rpc.BidiStreamingCall[Payload, Payload]{Call: call, BidiStreamingClient: x}, nil
+}
@@ -0,0 +1,41 @@
+// Command protoc-gen-go-pwrpc generates Pigweed flavored Go stubs for the
+// services in .proto files.
+//
+// Unlike protoc-gen-go-grpc output, the stubs carry the pw_rpc service and
+// method ids as constants, fail to compile if two ids collide, and return
+// typed call handles that expose the call id, channel and pw_rpc status.
+//
+//	protoc --go_out=. --go-pwrpc_out=. service.proto
+package main
+
+import (
+	"flag"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func main() {
+	var flags flag.FlagSet
+
+	protogen.Options{
+		ParamFunc: flags.Set,
+	}.Run(generate)
+}
+
+// generate writes the stubs of the files to generate that have services.
+func generate(gen *protogen.Plugin) error {
+	gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+
+	for _, f := range gen.Files {
+		if !f.Generate || len(f.Services) == 0 {
+			continue
+		}
+
+		if err := generateFile(gen, f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+const (
+	contextPackage = protogen.GoImportPath("context")
+	grpcPackage    = protogen.GoImportPath("google.golang.org/grpc")
+	pwrpcPackage   = protogen.GoImportPath("github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc")
+)
+
+func serviceIdName(service *protogen.Service) string {
+	return service.GoName + "_ServiceId"
+}
+
+func methodIdName(method *protogen.Method) string {
+	return method.Parent.GoName + "_" + method.GoName + "_MethodId"
+}
+
+// checkCollisions rejects services or methods whose ids collide, so the
+// problem is reported by protoc rather than by the Go compiler.
+func checkCollisions(f *protogen.File) error {
+	services := map[pw_rpc.Key]string{}
+
+	for _, service := range f.Services {
+		name := string(service.Desc.FullName())
+		id := pw_rpc.NewKey(name)
+		if other, ok := services[id]; ok {
+			return fmt.Errorf("%s: service ids of %s and %s collide: 0x%08x", f.Desc.Path(), other, name, uint32(id))
+		}
+		services[id] = name
+
+		methods := map[pw_rpc.Key]string{}
+		for _, method := range service.Methods {
+			name := string(method.Desc.Name())
+			id := pw_rpc.NewKey(name)
+			if other, ok := methods[id]; ok {
+				return fmt.Errorf("%s: method ids of %s.%s and %s collide: 0x%08x", f.Desc.Path(), service.Desc.FullName(), other, name, uint32(id))
+			}
+			methods[id] = name
+		}
+	}
+
+	return nil
+}
+
+func generateFile(gen *protogen.Plugin, f *protogen.File) error {
+	if err := checkCollisions(f); err != nil {
+		return err
+	}
+
+	g := gen.NewGeneratedFile(f.GeneratedFilenamePrefix+"_pwrpc.pb.go", f.GoImportPath)
+
+	g.P("// Code generated by protoc-gen-go-pwrpc. DO NOT EDIT.")
+	g.P("// source: ", f.Desc.Path())
+	g.P()
+	g.P("package ", f.GoPackageName)
+	g.P()
+
+	for _, service := range f.Services {
+		generateIds(g, service)
+	}
+
+	if len(f.Services) > 1 {
+		g.P("// Colliding service ids are duplicate keys, which fail to compile.")
+		g.P("var _ = map[", pwrpcPackage.Ident("Key"), "]bool{")
+		for _, service := range f.Services {
+			g.P(serviceIdName(service), ": true,")
+		}
+		g.P("}")
+		g.P()
+	}
+
+	for _, service := range f.Services {
+		generateClient(g, service)
+	}
+
+	return nil
+}
+
+func generateIds(g *protogen.GeneratedFile, service *protogen.Service) {
+	key := pwrpcPackage.Ident("Key")
+
+	g.P("// pw_rpc ids of the ", service.Desc.FullName(), " service and its methods.")
+	g.P("const (")
+	g.P(serviceIdName(service), " ", key, " = ", fmt.Sprintf("0x%08x", uint32(pw_rpc.NewKey(string(service.Desc.FullName())))))
+	for _, method := range service.Methods {
+		g.P(methodIdName(method), " ", key, " = ", fmt.Sprintf("0x%08x", uint32(pw_rpc.NewKey(string(method.Desc.Name())))))
+	}
+	g.P(")")
+	g.P()
+
+	if len(service.Methods) > 1 {
+		g.P("// Colliding method ids are duplicate keys, which fail to compile.")
+		g.P("var _ = map[", key, "]bool{")
+		for _, method := range service.Methods {
+			g.P(methodIdName(method), ": true,")
+		}
+		g.P("}")
+		g.P()
+	}
+}
+
+// callType returns the handle type a client method returns.
+func callType(g *protogen.GeneratedFile, method *protogen.Method) string {
+	in := g.QualifiedGoIdent(method.Input.GoIdent)
+	out := g.QualifiedGoIdent(method.Output.GoIdent)
+
+	switch {
+	case method.Desc.IsStreamingClient() && method.Desc.IsStreamingServer():
+		return "*" + g.QualifiedGoIdent(pwrpcPackage.Ident("BidiStreamingCall")) + "[" + in + ", " + out + "]"
+	case method.Desc.IsStreamingClient():
+		return "*" + g.QualifiedGoIdent(pwrpcPackage.Ident("ClientStreamingCall")) + "[" + in + ", " + out + "]"
+	case method.Desc.IsStreamingServer():
+		return "*" + g.QualifiedGoIdent(pwrpcPackage.Ident("ServerStreamingCall")) + "[" + out + "]"
+	default:
+		return "*" + g.QualifiedGoIdent(pwrpcPackage.Ident("UnaryCall")) + "[" + out + "]"
+	}
+}
+
+func methodSignature(g *protogen.GeneratedFile, method *protogen.Method) string {
+	s := method.GoName + "(ctx " + g.QualifiedGoIdent(contextPackage.Ident("Context"))
+	if !method.Desc.IsStreamingClient() {
+		s += ", in *" + g.QualifiedGoIdent(method.Input.GoIdent)
+	}
+	s += ", opts ..." + g.QualifiedGoIdent(grpcPackage.Ident("CallOption")) + ") "
+
+	return s + "(" + callType(g, method) + ", error)"
+}
+
+func generateClient(g *protogen.GeneratedFile, service *protogen.Service) {
+	clientName := service.GoName + "PwrpcClient"
+	structName := unexport(clientName)
+
+	g.P("// ", clientName, " is the pw_rpc client of the ", service.Desc.FullName(), " service.")
+	g.P("type ", clientName, " interface {")
+	for _, method := range service.Methods {
+		g.P(methodSignature(g, method))
+	}
+	g.P("}")
+	g.P()
+
+	g.P("type ", structName, " struct {")
+	g.P("cc ", grpcPackage.Ident("ClientConnInterface"))
+	g.P("}")
+	g.P()
+
+	g.P("func New", clientName, "(cc ", grpcPackage.Ident("ClientConnInterface"), ") ", clientName, " {")
+	g.P("return &", structName, "{cc}")
+	g.P("}")
+	g.P()
+
+	for _, method := range service.Methods {
+		generateMethod(g, structName, method)
+	}
+}
+
+func generateMethod(g *protogen.GeneratedFile, structName string, method *protogen.Method) {
+	service := method.Parent
+	fullMethod := fmt.Sprintf("/%s/%s", service.Desc.FullName(), method.Desc.Name())
+	in := g.QualifiedGoIdent(method.Input.GoIdent)
+	out := g.QualifiedGoIdent(method.Output.GoIdent)
+
+	g.P("func (c *", structName, ") ", methodSignature(g, method), " {")
+	g.P("call := &", pwrpcPackage.Ident("Call"), "{}")
+	g.P("opts = append([]", grpcPackage.Ident("CallOption"), "{")
+	g.P(pwrpcPackage.Ident("WithMethodIds"), "(", serviceIdName(service), ", ", methodIdName(method), "),")
+	g.P(pwrpcPackage.Ident("WithCall"), "(call),")
+	g.P("}, opts...)")
+
+	if !method.Desc.IsStreamingClient() && !method.Desc.IsStreamingServer() {
+		g.P("out := new(", out, ")")
+		g.P("err := c.cc.Invoke(ctx, ", fmt.Sprintf("%q", fullMethod), ", in, out, opts...)")
+		g.P("return &", pwrpcPackage.Ident("UnaryCall"), "[", out, "]{Call: call, Response: out}, err")
+		g.P("}")
+		g.P()
+		return
+	}
+
+	g.P("desc := &", grpcPackage.Ident("StreamDesc"), "{")
+	g.P("StreamName: ", fmt.Sprintf("%q", method.Desc.Name()), ",")
+	if method.Desc.IsStreamingServer() {
+		g.P("ServerStreams: true,")
+	}
+	if method.Desc.IsStreamingClient() {
+		g.P("ClientStreams: true,")
+	}
+	g.P("}")
+	g.P("stream, err := c.cc.NewStream(ctx, desc, ", fmt.Sprintf("%q", fullMethod), ", opts...)")
+	g.P("if err != nil {")
+	g.P("return nil, err")
+	g.P("}")
+	g.P("x := &", grpcPackage.Ident("GenericClientStream"), "[", in, ", ", out, "]{ClientStream: stream}")
+
+	switch {
+	case method.Desc.IsStreamingClient() && method.Desc.IsStreamingServer():
+		g.P("return &", pwrpcPackage.Ident("BidiStreamingCall"), "[", in, ", ", out, "]{Call: call, BidiStreamingClient: x}, nil")
+	case method.Desc.IsStreamingClient():
+		g.P("return &", pwrpcPackage.Ident("ClientStreamingCall"), "[", in, ", ", out, "]{Call: call, ClientStreamingClient: x}, nil")
+	default:
+		g.P("if err := x.ClientStream.SendMsg(in); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("if err := x.ClientStream.CloseSend(); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("return &", pwrpcPackage.Ident("ServerStreamingCall"), "[", out, "]{Call: call, ServerStreamingClient: x}, nil")
+	}
+
+	g.P("}")
+	g.P()
+}
+
+func unexport(s string) string {
+	return string(s[0]|0x20) + s[1:]
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/robertfarnum/go-pw-rpc/cmd/pb"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+const (
+	// kCollidingName1 and kCollidingName2 have the same pw_rpc id.
+	kCollidingName1 = "byakmKet"
+	kCollidingName2 = "HNHReqVH"
+)
+
+// run runs the plugin on files as protoc would, with the flags of gen.sh.
+func run(t *testing.T, files ...*descriptorpb.FileDescriptorProto) *pluginpb.CodeGeneratorResponse {
+	t.Helper()
+
+	req := &pluginpb.CodeGeneratorRequest{
+		Parameter: proto.String("Mbenchmark.proto=../pb"),
+		ProtoFile: files,
+	}
+	for _, f := range files {
+		req.FileToGenerate = append(req.FileToGenerate, f.GetName())
+	}
+
+	gen, err := protogen.Options{}.New(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := generate(gen); err != nil {
+		gen.Error(err)
+	}
+
+	return gen.Response()
+}
+
+func TestGenerateGolden(t *testing.T) {
+	res := run(t, protodesc.ToFileDescriptorProto(pb.File_benchmark_proto))
+	if res.Error != nil {
+		t.Fatal(res.GetError())
+	}
+	if len(res.File) != 1 {
+		t.Fatalf("generated %d files, want 1", len(res.File))
+	}
+
+	want, err := os.ReadFile("../pb/benchmark_pwrpc.pb.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := res.File[0].GetContent()
+	if got != string(want) {
+		gotLines, wantLines := strings.Split(got, "\n"), strings.Split(string(want), "\n")
+		for i := 0; i < len(gotLines) && i < len(wantLines); i++ {
+			if gotLines[i] != wantLines[i] {
+				t.Fatalf("%s differs from benchmark_pwrpc.pb.go at line %d:\n got: %s\nwant: %s", res.File[0].GetName(), i+1, gotLines[i], wantLines[i])
+			}
+		}
+		t.Fatalf("%s has %d lines, benchmark_pwrpc.pb.go %d", res.File[0].GetName(), len(gotLines), len(wantLines))
+	}
+}
+
+func TestGenerateCollisions(t *testing.T) {
+	message := &descriptorpb.DescriptorProto{Name: proto.String("Payload")}
+	method := func(name string) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{
+			Name:       proto.String(name),
+			InputType:  proto.String(".pw.test.Payload"),
+			OutputType: proto.String(".pw.test.Payload"),
+		}
+	}
+	file := func(services ...*descriptorpb.ServiceDescriptorProto) *descriptorpb.FileDescriptorProto {
+		return &descriptorpb.FileDescriptorProto{
+			Name:        proto.String("collide.proto"),
+			Package:     proto.String("pw.test"),
+			Syntax:      proto.String("proto3"),
+			Options:     &descriptorpb.FileOptions{GoPackage: proto.String("example.com/collide")},
+			MessageType: []*descriptorpb.DescriptorProto{message},
+			Service:     services,
+		}
+	}
+
+	tests := []struct {
+		name string
+		file *descriptorpb.FileDescriptorProto
+		want string
+	}{
+		{
+			"methods",
+			file(&descriptorpb.ServiceDescriptorProto{
+				Name:   proto.String("Methods"),
+				Method: []*descriptorpb.MethodDescriptorProto{method(kCollidingName1), method(kCollidingName2)},
+			}),
+			"method ids of pw.test.Methods." + kCollidingName1 + " and " + kCollidingName2 + " collide",
+		},
+		{
+			"services",
+			// The package prefix keeps the ids of the full names colliding.
+			file(
+				&descriptorpb.ServiceDescriptorProto{Name: proto.String(kCollidingName1), Method: []*descriptorpb.MethodDescriptorProto{method("Echo")}},
+				&descriptorpb.ServiceDescriptorProto{Name: proto.String(kCollidingName2), Method: []*descriptorpb.MethodDescriptorProto{method("Echo")}},
+			),
+			"service ids of pw.test." + kCollidingName1 + " and pw.test." + kCollidingName2 + " collide",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res := run(t, test.file)
+			if !strings.Contains(res.GetError(), test.want) {
+				t.Errorf("error = %q, want %q", res.GetError(), test.want)
+			}
+			if len(res.File) != 0 {
+				t.Errorf("generated %d files despite the collision", len(res.File))
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// complete is the terminal's AutoCompleteCallback. Tab completes the command
+// or method name under the cursor; when the name is ambiguous the longest
+// common prefix is inserted and the candidates are listed.
+func (con *console) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' || strings.Contains(line[:pos], " ") {
+		return "", 0, false
+	}
+
+	prefix := line[:pos]
+
+	var candidates []string
+	for name := range commands {
+		if strings.HasPrefix(name, prefix) {
+			candidates = append(candidates, name)
+		}
+	}
+	for _, method := range con.methods {
+		if strings.HasPrefix(method, prefix) {
+			candidates = append(candidates, method)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return "", 0, false
+	case 1:
+		completed := candidates[0] + " "
+		return completed + line[pos:], len(completed), true
+	}
+
+	sort.Strings(candidates)
+
+	common := commonPrefix(candidates)
+	if len(common) > len(prefix) {
+		return common + line[pos:], len(common), true
+	}
+
+	// The terminal is locked while completing, so list the candidates once
+	// the callback has returned.
+	go con.t.Write([]byte(strings.Join(candidates, "  ") + "\n"))
+
+	return "", 0, false
+}
+
+func commonPrefix(names []string) string {
+	prefix := names[0]
+	for _, name := range names[1:] {
+		for !strings.HasPrefix(name, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+
+	return prefix
+}
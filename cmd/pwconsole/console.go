@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/robertfarnum/go-pw-rpc/cmd/internal/cli"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"golang.org/x/term"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const kMaxHistory = 1000
+
+type command struct {
+	help string
+	run  func(con *console, ctx context.Context, args string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"help":    {"show this help", (*console).help},
+		"list":    {"list services and methods", (*console).list},
+		"cancel":  {"cancel running server streams", (*console).cancelStreams},
+		"history": {"show the command history", (*console).showHistory},
+		"exit":    {"leave the console", nil},
+	}
+}
+
+type console struct {
+	t           *term.Terminal
+	c           pw_rpc.Client
+	files       *protoregistry.Files
+	methods     []string
+	timeout     time.Duration
+	historyFile string
+	history     []string
+	streams     map[int]context.CancelFunc
+	nextStream  int
+	mu          sync.Mutex
+}
+
+func newConsole(t *term.Terminal, c pw_rpc.Client, files *protoregistry.Files, timeout time.Duration, historyFile string) *console {
+	con := &console{
+		t:           t,
+		c:           c,
+		files:       files,
+		timeout:     timeout,
+		historyFile: historyFile,
+		streams:     make(map[int]context.CancelFunc),
+	}
+
+	for _, sd := range cli.Services(files) {
+		for i := 0; i < sd.Methods().Len(); i++ {
+			con.methods = append(con.methods, cli.FullMethod(sd.Methods().Get(i))[1:])
+		}
+	}
+
+	con.loadHistory()
+	t.History = con
+	t.AutoCompleteCallback = con.complete
+
+	return con
+}
+
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".pwconsole_history")
+}
+
+// Run reads and executes commands until exit or end of input.
+func (con *console) Run(ctx context.Context) {
+	defer con.cancelStreams(ctx, "")
+
+	for {
+		line, err := con.t.ReadLine()
+		if err != nil {
+			return
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		name, args, _ := strings.Cut(line, " ")
+		if name == "exit" || name == "quit" {
+			return
+		}
+
+		if err := con.execute(ctx, name, strings.TrimSpace(args)); err != nil {
+			fmt.Fprintf(con.t, "error: %s\n", err)
+		}
+	}
+}
+
+func (con *console) execute(ctx context.Context, name string, args string) error {
+	if cmd, ok := commands[name]; ok {
+		return cmd.run(con, ctx, args)
+	}
+
+	md, err := cli.FindMethod(con.files, name)
+	if err != nil {
+		return err
+	}
+
+	request := dynamicpb.NewMessage(md.Input())
+	if args != "" {
+		if err := protojson.Unmarshal([]byte(args), request); err != nil {
+			return fmt.Errorf("invalid request: %w", err)
+		}
+	}
+
+	switch {
+	case md.IsStreamingClient():
+		return fmt.Errorf("%s: client streaming is not supported in the console", md.FullName())
+	case md.IsStreamingServer():
+		return con.stream(ctx, md, request)
+	default:
+		return con.call(ctx, md, request)
+	}
+}
+
+func (con *console) call(ctx context.Context, md protoreflect.MethodDescriptor, request *dynamicpb.Message) error {
+	ctx, cancel := context.WithTimeout(ctx, con.timeout)
+	defer cancel()
+
+	response := dynamicpb.NewMessage(md.Output())
+	if err := con.c.Invoke(ctx, cli.FullMethod(md), request, response); err != nil {
+		return err
+	}
+
+	con.print("", response)
+
+	return nil
+}
+
+// stream starts a server streaming call that prints its responses in the
+// background until it completes or is cancelled.
+func (con *console) stream(ctx context.Context, md protoreflect.MethodDescriptor, request *dynamicpb.Message) error {
+	ctx, cancel := context.WithCancel(ctx)
+
+	desc := &grpc.StreamDesc{
+		StreamName:    string(md.Name()),
+		ServerStreams: true,
+	}
+
+	stream, err := con.c.NewStream(ctx, desc, cli.FullMethod(md))
+	if err == nil {
+		err = stream.SendMsg(request)
+	}
+	if err == nil {
+		err = stream.CloseSend()
+	}
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	con.mu.Lock()
+	id := con.nextStream
+	con.nextStream++
+	con.streams[id] = cancel
+	con.mu.Unlock()
+
+	prefix := fmt.Sprintf("[%s #%d] ", md.Name(), id)
+
+	go func() {
+		defer func() {
+			con.mu.Lock()
+			delete(con.streams, id)
+			con.mu.Unlock()
+			cancel()
+		}()
+
+		for {
+			response := dynamicpb.NewMessage(md.Output())
+
+			err := stream.RecvMsg(response)
+			if errors.Is(err, io.EOF) {
+				fmt.Fprintf(con.t, "%sdone\n", prefix)
+				return
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					fmt.Fprintf(con.t, "%serror: %s\n", prefix, err)
+				}
+				return
+			}
+
+			con.print(prefix, response)
+		}
+	}()
+
+	fmt.Fprintf(con.t, "%sstarted\n", prefix)
+
+	return nil
+}
+
+func (con *console) print(prefix string, m protoreflect.ProtoMessage) {
+	buf, err := protojson.Marshal(m)
+	if err != nil {
+		fmt.Fprintf(con.t, "%serror: %s\n", prefix, err)
+		return
+	}
+
+	fmt.Fprintf(con.t, "%s%s\n", prefix, buf)
+}
+
+// HandleLog implements pw_rpc.LogHandler.
+func (con *console) HandleLog(ctx context.Context, payload []byte) {
+	fmt.Fprintf(con.t, "%s\n", formatLog(payload))
+}
+
+// formatLog prints text log payloads as they are and anything else as hex.
+func formatLog(payload []byte) string {
+	text := strings.TrimRight(string(payload), "\r\n\x00")
+	for _, r := range text {
+		if !unicode.IsPrint(r) && r != '\t' {
+			return fmt.Sprintf("log: % x", payload)
+		}
+	}
+
+	return "log: " + text
+}
+
+func (con *console) help(ctx context.Context, args string) error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(con.t, "  %-8s %s\n", name, commands[name].help)
+	}
+	fmt.Fprintf(con.t, "  <pkg.Service/Method> [json]  invoke an RPC\n")
+
+	return nil
+}
+
+func (con *console) list(ctx context.Context, args string) error {
+	for _, method := range con.methods {
+		if strings.HasPrefix(method, args) {
+			fmt.Fprintf(con.t, "  %s\n", method)
+		}
+	}
+
+	return nil
+}
+
+func (con *console) cancelStreams(ctx context.Context, args string) error {
+	con.mu.Lock()
+	defer con.mu.Unlock()
+
+	for id, cancel := range con.streams {
+		cancel()
+		delete(con.streams, id)
+	}
+
+	return nil
+}
+
+func (con *console) loadHistory() {
+	if con.historyFile == "" {
+		return
+	}
+
+	f, err := os.Open(con.historyFile)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		con.history = append(con.history, scanner.Text())
+	}
+
+	if len(con.history) > kMaxHistory {
+		con.history = con.history[len(con.history)-kMaxHistory:]
+	}
+}
+
+// Add implements term.History, so that the arrow keys recall the lines of
+// earlier sessions too. Each line is also appended to the history file.
+func (con *console) Add(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+
+	con.history = append(con.history, line)
+	if len(con.history) > kMaxHistory {
+		con.history = con.history[1:]
+	}
+
+	if con.historyFile == "" {
+		return
+	}
+
+	f, err := os.OpenFile(con.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	fmt.Fprintln(f, line)
+}
+
+// Len implements term.History.
+func (con *console) Len() int {
+	return len(con.history)
+}
+
+// At implements term.History; 0 is the latest line.
+func (con *console) At(i int) string {
+	return con.history[len(con.history)-1-i]
+}
+
+func (con *console) showHistory(ctx context.Context, args string) error {
+	for i, line := range con.history {
+		fmt.Fprintf(con.t, "%5d  %s\n", i+1, line)
+	}
+
+	return nil
+}
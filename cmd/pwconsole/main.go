@@ -0,0 +1,127 @@
+// Command pwconsole is an interactive console for a pw_rpc device.
+//
+//	pwconsole [-proto file] [-timeout d] [-framing name] <endpoint>
+//
+// Methods from the -proto files are invoked by typing their name followed by
+// a JSON request, e.g. `pw.rpc.Benchmark/UnaryEcho {"payload": "aGk="}`.
+// Tab completes commands and method names. The device's log output is
+// printed as it arrives.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/robertfarnum/go-pw-rpc/cmd/internal/cli"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_capture"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"golang.org/x/term"
+)
+
+// stdio joins stdin and stdout for the terminal.
+type stdio struct {
+	io.Reader
+	io.Writer
+}
+
+// lineReader turns the newlines of piped input into the carriage returns a
+// raw terminal would send.
+type lineReader struct {
+	r io.Reader
+}
+
+func (lr *lineReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	for i := range p[:n] {
+		if p[i] == '\n' {
+			p[i] = '\r'
+		}
+	}
+
+	return n, err
+}
+
+func main() {
+	var protos cli.ProtoFlags
+
+	flag.Var(&protos, "proto", ".proto or descriptor set file (repeatable)")
+	timeout := flag.Duration("timeout", 10*time.Second, "unary call timeout")
+	historyFile := flag.String("history", defaultHistoryFile(), "command history file")
+	capture := flag.String("capture", "", "write the frames to a .pcapng or JSON lines file")
+	framingName := flag.String("framing", "", cli.FramingUsage)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: pwconsole [-proto file] [-timeout d] [-framing name] <endpoint>\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 || cli.IsStdio(flag.Arg(0)) {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	framing, err := cli.Framing(*framingName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pwconsole: %s\n", err)
+		os.Exit(2)
+	}
+
+	files, err := cli.LoadProtos(protos)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pwconsole: %s\n", err)
+		os.Exit(1)
+	}
+
+	var in io.Reader = &lineReader{os.Stdin}
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		in = os.Stdin
+
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "pwconsole: %s\n", err)
+			os.Exit(1)
+		}
+		defer term.Restore(fd, state)
+	}
+
+	t := term.NewTerminal(&stdio{in, os.Stdout}, "pw> ")
+	if width, height, err := term.GetSize(fd); err == nil {
+		t.SetSize(width, height)
+	}
+
+	c := pw_rpc.NewClientWithDialer(cli.Dialer(flag.Arg(0)))
+	c.SetFraming(framing)
+	defer c.Close()
+
+	if *capture != "" {
+		w, err := pw_capture.Create(*capture)
+		if err != nil {
+			fmt.Fprintf(t, "capture: %s\n", err)
+			return
+		}
+		defer w.Close()
+
+		c.SetTap(w)
+	}
+
+	con := newConsole(t, c, files, *timeout, *historyFile)
+	c.SetLogHandler(con)
+
+	fmt.Fprintf(t, "Connecting to %s...\n", flag.Arg(0))
+
+	ctx := context.Background()
+	if err := c.Connect(ctx); err != nil {
+		fmt.Fprintf(t, "connect: %s\n", err)
+		return
+	}
+
+	fmt.Fprintf(t, "Connected. Type \"help\" for commands.\n")
+
+	con.Run(ctx)
+}
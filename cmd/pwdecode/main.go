@@ -0,0 +1,279 @@
+// Command pwdecode decodes a dump of HDLC framed pw_rpc traffic.
+//
+//	pwdecode [-proto file] [-tokens db.csv] [-format auto|hex|binary] [file]
+//
+// Every frame is printed with its offset, address and whether its frame
+// check sequence is valid, along with any bytes that were lost between or
+// inside frames. RPC frames are decoded into packets, named from the -proto
+// files, and log frames are detokenized with the -tokens database.
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/robertfarnum/go-pw-rpc/cmd/internal/cli"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_hdlc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_tokenizer"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_varint"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	kRpcAddress  = 'R'
+	kLogAddress  = 1
+	kFlag        = 0x7e
+	kControlSize = 1
+	kFcsSize     = 4
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage:
+  pwdecode [-proto file] [-tokens db.csv] [-format auto|hex|binary] [file]
+
+Reads stdin when no file is given. Hex dumps may separate bytes with
+whitespace, commas or colons and prefix them with 0x.
+`)
+	os.Exit(2)
+}
+
+func main() {
+	var protos cli.ProtoFlags
+
+	fs := flag.NewFlagSet("pwdecode", flag.ExitOnError)
+	fs.Usage = usage
+	fs.Var(&protos, "proto", ".proto or descriptor set file (repeatable)")
+	tokens := fs.String("tokens", "", "CSV token database for detokenizing logs")
+	format := fs.String("format", "auto", "input format: auto, hex or binary")
+	fs.Parse(os.Args[1:])
+
+	if fs.NArg() > 1 {
+		usage()
+	}
+
+	if err := run(fs.Arg(0), protos, *tokens, *format); err != nil {
+		fmt.Fprintf(os.Stderr, "pwdecode: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(path string, protos []string, tokens string, format string) error {
+	if _, err := cli.LoadProtos(protos); err != nil {
+		return err
+	}
+
+	var detokenizer pw_tokenizer.Detokenizer
+	if tokens != "" {
+		db, err := pw_tokenizer.Load(tokens)
+		if err != nil {
+			return err
+		}
+		detokenizer = pw_tokenizer.NewDetokenizer(db)
+	}
+
+	input, err := readInput(path)
+	if err != nil {
+		return err
+	}
+
+	data, err := decodeInput(input, format)
+	if err != nil {
+		return err
+	}
+
+	d := &dump{
+		out:         os.Stdout,
+		detokenizer: detokenizer,
+	}
+
+	return d.decode(data)
+}
+
+func readInput(path string) ([]byte, error) {
+	if path == "" || path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+
+	return os.ReadFile(path)
+}
+
+// decodeInput converts a hex dump to bytes. In auto format, input that is
+// not entirely hex digits and separators is taken as binary.
+func decodeInput(input []byte, format string) ([]byte, error) {
+	switch format {
+	case "binary":
+		return input, nil
+	case "hex", "auto":
+		data, err := parseHex(input)
+		if err == nil || format == "hex" {
+			return data, err
+		}
+		return input, nil
+	}
+
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+func parseHex(input []byte) ([]byte, error) {
+	var digits strings.Builder
+
+	for _, field := range strings.FieldsFunc(string(input), func(r rune) bool {
+		return r == ' ' || r == '\t' || r == '\r' || r == '\n' || r == ',' || r == ':'
+	}) {
+		field = strings.TrimPrefix(strings.TrimPrefix(field, "0x"), "0X")
+		if len(field)%2 != 0 {
+			field = "0" + field
+		}
+		digits.WriteString(field)
+	}
+
+	if digits.Len() == 0 {
+		return nil, errors.New("no hex digits in input")
+	}
+
+	return hex.DecodeString(digits.String())
+}
+
+// flagReader remembers where the last two flag bytes were read. When the
+// decoder returns, they delimit what it has just decoded.
+type flagReader struct {
+	data     []byte
+	offset   int
+	lastFlag int
+	prevFlag int
+}
+
+func (r *flagReader) Read(p []byte) (int, error) {
+	if r.offset >= len(r.data) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, r.data[r.offset:])
+	for i := 0; i < n; i++ {
+		if p[i] == kFlag {
+			r.prevFlag = r.lastFlag
+			r.lastFlag = r.offset + i
+		}
+	}
+	r.offset += n
+
+	return n, nil
+}
+
+type dump struct {
+	out         io.Writer
+	detokenizer pw_tokenizer.Detokenizer
+	frames      int
+	badFrames   int
+	lostBytes   int
+}
+
+func (d *dump) decode(data []byte) error {
+	reader := &flagReader{
+		data:     data,
+		lastFlag: -1,
+		prevFlag: -1,
+	}
+	decoder := pw_hdlc.NewDecoder(reader, kRpcAddress)
+	ctx := context.Background()
+
+	for {
+		frame, err := decoder.Decode(ctx)
+		offset := reader.prevFlag + 1
+
+		var decodeErr *pw_hdlc.DecodeError
+		switch {
+		case err == io.EOF:
+			d.summary(reader)
+			return nil
+		case errors.As(err, &decodeErr):
+			d.dataLoss(offset, decodeErr)
+		case err != nil:
+			return err
+		default:
+			d.frame(offset, frame)
+		}
+	}
+}
+
+func (d *dump) frame(offset int, frame *pw_hdlc.Frame) {
+	d.frames++
+
+	fmt.Fprintf(d.out, "0x%06x  frame address=%d control=0x%02x (%s) size=%d fcs=ok\n",
+		offset, frame.Address(), frame.Control(), frame.ControlField(), len(frame.Payload()))
+
+	switch frame.Address() {
+	case kRpcAddress:
+		packet := &pb.RpcPacket{}
+		if err := proto.Unmarshal(frame.Payload(), packet); err != nil {
+			fmt.Fprintf(d.out, "          invalid packet: %s: %x\n", err, frame.Payload())
+			return
+		}
+		fmt.Fprintf(d.out, "          %s\n", pw_rpc.FormatPacket(packet))
+	case kLogAddress:
+		fmt.Fprintf(d.out, "          log: %s\n", d.log(frame.Payload()))
+	default:
+		fmt.Fprintf(d.out, "          %x\n", frame.Payload())
+	}
+}
+
+func (d *dump) log(data []byte) string {
+	if d.detokenizer != nil {
+		text, err := d.detokenizer.DetokenizeText(data)
+		if err == nil {
+			return text
+		}
+		if !isText(data) {
+			return fmt.Sprintf("%x (%s)", data, err)
+		}
+	}
+
+	if isText(data) {
+		return string(data)
+	}
+
+	return fmt.Sprintf("%x", data)
+}
+
+func isText(data []byte) bool {
+	for _, b := range data {
+		if (b < 0x20 || b > 0x7e) && b != '\t' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (d *dump) dataLoss(offset int, err *pw_hdlc.DecodeError) {
+	// A frame with a bad frame check sequence is still shown as a frame, as
+	// its address and control field are most likely intact.
+	address, addressSize := pw_varint.Decode(err.Data, pw_varint.OneTerminatedLeastSignificant)
+	if err.Reason == pw_hdlc.ReasonBadFcs && addressSize > 0 {
+		d.badFrames++
+		fmt.Fprintf(d.out, "0x%06x  frame address=%d control=0x%02x size=%d fcs=BAD\n",
+			offset, address, err.Data[addressSize], len(err.Data)-addressSize-kControlSize-kFcsSize)
+	} else {
+		d.lostBytes += len(err.Data)
+		fmt.Fprintf(d.out, "0x%06x  data loss: %d bytes (%s)\n", offset, len(err.Data), err.Reason)
+	}
+
+	fmt.Fprintf(d.out, "          %x\n", err.Data)
+}
+
+func (d *dump) summary(reader *flagReader) {
+	if trailing := len(reader.data) - reader.lastFlag - 1; trailing > 0 {
+		d.lostBytes += trailing
+		fmt.Fprintf(d.out, "0x%06x  data loss: %d bytes after the last frame\n", reader.lastFlag+1, trailing)
+	}
+
+	fmt.Fprintf(d.out, "%d frames, %d bad frames, %d bytes lost\n", d.frames, d.badFrames, d.lostBytes)
+}
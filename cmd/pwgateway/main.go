@@ -0,0 +1,103 @@
+// Command pwgateway serves gRPC over HTTP/2 and forwards every call to a
+// pw_rpc device, so that ordinary gRPC tools can talk to it.
+//
+//	pwgateway [-listen addr] -proto file <endpoint>
+//
+// The endpoint is a TCP host:port, a serial device path, or a URI:
+// tcp://host:port, unix:///path, serial:///dev/ttyUSB0?baud=115200,
+// udp://host:port or ws://host:port/path.
+//
+// With -backend it bridges the other way: it serves pw_rpc on the endpoint,
+// a host:port or one of the URIs, and forwards the device's calls to the
+// gRPC backend.
+//
+//	pwgateway -backend addr -proto file <endpoint>
+//
+// For example, with grpcurl:
+//
+//	grpcurl -plaintext -protoset benchmark.pb -d '{"payload":"aGk="}' \
+//	    localhost:50051 pw.rpc.Benchmark/UnaryEcho
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/robertfarnum/go-pw-rpc/cmd/internal/cli"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_grpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+func main() {
+	var protos cli.ProtoFlags
+
+	flag.Var(&protos, "proto", ".proto or descriptor set file (repeatable)")
+	listen := flag.String("listen", "localhost:50051", "gRPC listen address")
+	backend := flag.String("backend", "", "gRPC backend to serve the device's calls from")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: pwgateway [-listen addr] -proto file <endpoint>\n")
+		fmt.Fprintf(os.Stderr, "       pwgateway -backend addr -proto file <endpoint>\n\n")
+		fmt.Fprintf(os.Stderr, "endpoint is host:port, a serial device path, or a URI: tcp://host:port,\n")
+		fmt.Fprintf(os.Stderr, "unix:///path, serial:///dev/ttyUSB0?baud=115200, udp://host:port or ws://host:port/path.\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	files, err := cli.LoadProtos(protos)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pwgateway: %s\n", err)
+		os.Exit(1)
+	}
+
+	if *backend != "" {
+		if err := runBridge(*backend, flag.Arg(0), files); err != nil {
+			fmt.Fprintf(os.Stderr, "pwgateway: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	c := pw_rpc.NewClientWithDialer(cli.Dialer(flag.Arg(0)))
+	defer c.Close()
+
+	lis, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pwgateway: %s\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Gateway listening: %s -> %s\n", lis.Addr(), flag.Arg(0))
+
+	s := pw_grpc.NewGateway(c, files).NewServer()
+	if err := s.Serve(lis); err != nil {
+		fmt.Fprintf(os.Stderr, "pwgateway: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func runBridge(backend string, endpoint string, files *protoregistry.Files) error {
+	cc, err := grpc.NewClient(backend, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer cc.Close()
+
+	s := pw_rpc.NewServer(endpoint)
+
+	if err := pw_grpc.NewBridge(cc, files).Register(s); err != nil {
+		return err
+	}
+
+	return s.Listen(context.Background())
+}
@@ -0,0 +1,99 @@
+// Command pwreplay acts as a device by replaying a recorded session.
+//
+//	pwreplay [-speed n] <capture.jsonl> <listen address | ->
+//
+// Sessions are recorded with `pwrpc -capture file.jsonl` or any client or
+// server tapped by a pw_capture writer. Each host connection is answered
+// with the recorded responses; requests that were not recorded get a
+// NOT_FOUND error and are reported on exit.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/robertfarnum/go-pw-rpc/cmd/internal/cli"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_replay"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage:
+  pwreplay [-speed n] <capture.jsonl> <listen address | ->
+
+speed scales the recorded delays: 1 is real time, 0 replays without delay.
+`)
+	os.Exit(2)
+}
+
+func main() {
+	fs := flag.NewFlagSet("pwreplay", flag.ExitOnError)
+	fs.Usage = usage
+	speed := fs.Float64("speed", 1, "replay speed, 0 for no delays")
+	fs.Parse(os.Args[1:])
+
+	if fs.NArg() != 2 {
+		usage()
+	}
+
+	if err := run(fs.Arg(0), fs.Arg(1), *speed); err != nil {
+		fmt.Fprintf(os.Stderr, "pwreplay: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(path string, endpoint string, speed float64) error {
+	session, err := pw_replay.Load(path)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	if cli.IsStdio(endpoint) {
+		device := pw_replay.NewDevice(session, speed)
+		rwc, err := cli.Dialer(endpoint)(ctx)
+		if err != nil {
+			return err
+		}
+
+		device.Serve(ctx, rwc)
+
+		return report(device)
+	}
+
+	lis, err := net.Listen("tcp", endpoint)
+	if err != nil {
+		return err
+	}
+	defer lis.Close()
+
+	fmt.Printf("Replaying %d frames on %s\n", len(session.Records), lis.Addr())
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return err
+		}
+
+		// One host at a time, as a device has a single port. Every host
+		// gets the whole session.
+		device := pw_replay.NewDevice(session, speed)
+		err = device.Serve(ctx, conn)
+		conn.Close()
+		fmt.Printf("Host disconnected: %s\n", err)
+
+		report(device)
+	}
+}
+
+func report(device pw_replay.Device) error {
+	for _, packet := range device.Unmatched() {
+		fmt.Fprintf(os.Stderr, "unmatched: %s\n", pw_rpc.FormatPacket(packet))
+	}
+
+	return device.Err()
+}
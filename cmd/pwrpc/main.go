@@ -0,0 +1,316 @@
+// Command pwrpc calls pw_rpc methods on a device.
+//
+//	pwrpc call   [-proto file] [-framing name] <endpoint> <pkg.Service/Method> '<json>'
+//	pwrpc stream [-proto file] [-framing name] <endpoint> <pkg.Service/Method> '<json>'
+//	pwrpc list   -proto file
+//	pwrpc hash   <name>...
+//
+// The endpoint is a TCP host:port, a serial device path, "-" for stdio, or a
+// URI: tcp://host:port, unix:///path, serial:///dev/ttyUSB0?baud=115200,
+// udp://host:port or ws://host:port/path.
+// Request and response messages are described by the -proto files, which are
+// either .proto sources (compiled with protoc) or descriptor sets.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/robertfarnum/go-pw-rpc/cmd/internal/cli"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_capture"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var commands = map[string]func(args []string) error{
+	"call":   runCall,
+	"stream": runStream,
+	"list":   runList,
+	"hash":   runHash,
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage:
+  pwrpc call   [-proto file] [-timeout d] [-capture file] [-framing name] <endpoint> <pkg.Service/Method> ['<json>']
+  pwrpc stream [-proto file] [-timeout d] [-capture file] [-framing name] <endpoint> <pkg.Service/Method> ['<json>']
+  pwrpc list   -proto file
+  pwrpc hash   <name>...
+
+endpoint is host:port, a serial device path such as /dev/ttyUSB0, - for stdio,
+or a URI: tcp://host:port, unix:///path, serial:///dev/ttyUSB0?baud=115200,
+udp://host:port or ws://host:port/path.
+
+-framing selects the %s.
+`, cli.FramingUsage)
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+
+	if err := run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "pwrpc %s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// callArgs holds the flags and arguments shared by call and stream.
+type callArgs struct {
+	endpoint string
+	method   protoreflect.MethodDescriptor
+	request  *dynamicpb.Message
+	timeout  time.Duration
+	capture  string
+	framing  pw_rpc.FrameTransport
+}
+
+func parseCallArgs(name string, args []string) (*callArgs, error) {
+	var protos cli.ProtoFlags
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Var(&protos, "proto", ".proto or descriptor set file (repeatable)")
+	timeout := fs.Duration("timeout", 10*time.Second, "call timeout, 0 for none")
+	capture := fs.String("capture", "", "write the frames to a .pcapng or JSON lines file")
+	framingName := fs.String("framing", "", cli.FramingUsage)
+	fs.Parse(args)
+
+	if fs.NArg() < 2 || fs.NArg() > 3 {
+		usage()
+	}
+
+	framing, err := cli.Framing(*framingName)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := cli.LoadProtos(protos)
+	if err != nil {
+		return nil, err
+	}
+
+	md, err := cli.FindMethod(files, fs.Arg(1))
+	if err != nil {
+		return nil, err
+	}
+
+	request := dynamicpb.NewMessage(md.Input())
+	if fs.NArg() == 3 {
+		if err := protojson.Unmarshal([]byte(fs.Arg(2)), request); err != nil {
+			return nil, fmt.Errorf("invalid request: %w", err)
+		}
+	}
+
+	return &callArgs{
+		endpoint: fs.Arg(0),
+		method:   md,
+		request:  request,
+		timeout:  *timeout,
+		capture:  *capture,
+		framing:  framing,
+	}, nil
+}
+
+// newClient connects to the endpoint, capturing the frames if requested.
+// The returned function closes the client and the capture.
+func (a *callArgs) newClient() (pw_rpc.Client, func(), error) {
+	c := pw_rpc.NewClientWithDialer(cli.Dialer(a.endpoint))
+	c.SetFraming(a.framing)
+
+	if a.capture == "" {
+		return c, c.Close, nil
+	}
+
+	w, err := pw_capture.Create(a.capture)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.SetTap(w)
+
+	return c, func() {
+		c.Close()
+		w.Close()
+	}, nil
+}
+
+func (a *callArgs) context() (context.Context, context.CancelFunc) {
+	if a.timeout == 0 {
+		return context.WithCancel(context.Background())
+	}
+
+	return context.WithTimeout(context.Background(), a.timeout)
+}
+
+// output is where responses are printed. It is stderr when stdout carries
+// the RPC traffic.
+func (a *callArgs) output() io.Writer {
+	if cli.IsStdio(a.endpoint) {
+		return os.Stderr
+	}
+
+	return os.Stdout
+}
+
+func printMessage(w io.Writer, m protoreflect.ProtoMessage) error {
+	buf, err := protojson.MarshalOptions{Multiline: true}.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, string(buf))
+
+	return err
+}
+
+func runCall(args []string) error {
+	a, err := parseCallArgs("call", args)
+	if err != nil {
+		return err
+	}
+
+	if a.method.IsStreamingClient() || a.method.IsStreamingServer() {
+		return fmt.Errorf("%s is a streaming method", a.method.FullName())
+	}
+
+	ctx, cancel := a.context()
+	defer cancel()
+
+	c, closeClient, err := a.newClient()
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+
+	response := dynamicpb.NewMessage(a.method.Output())
+	if err := c.Invoke(ctx, cli.FullMethod(a.method), a.request, response); err != nil {
+		return err
+	}
+
+	return printMessage(a.output(), response)
+}
+
+func runStream(args []string) error {
+	a, err := parseCallArgs("stream", args)
+	if err != nil {
+		return err
+	}
+
+	if a.method.IsStreamingClient() || !a.method.IsStreamingServer() {
+		return fmt.Errorf("%s is not a server streaming method", a.method.FullName())
+	}
+
+	ctx, cancel := a.context()
+	defer cancel()
+
+	c, closeClient, err := a.newClient()
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+
+	desc := &grpc.StreamDesc{
+		StreamName:    string(a.method.Name()),
+		ServerStreams: true,
+	}
+
+	stream, err := c.NewStream(ctx, desc, cli.FullMethod(a.method))
+	if err != nil {
+		return err
+	}
+
+	if err := stream.SendMsg(a.request); err != nil {
+		return err
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	for {
+		response := dynamicpb.NewMessage(a.method.Output())
+
+		err := stream.RecvMsg(response)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := printMessage(a.output(), response); err != nil {
+			return err
+		}
+	}
+}
+
+func methodKind(md protoreflect.MethodDescriptor) string {
+	switch {
+	case md.IsStreamingClient() && md.IsStreamingServer():
+		return "bidirectional streaming"
+	case md.IsStreamingClient():
+		return "client streaming"
+	case md.IsStreamingServer():
+		return "server streaming"
+	default:
+		return "unary"
+	}
+}
+
+func runList(args []string) error {
+	var protos cli.ProtoFlags
+
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	fs.Var(&protos, "proto", ".proto or descriptor set file (repeatable)")
+	fs.Parse(args)
+
+	if len(protos) == 0 {
+		protos = fs.Args()
+	}
+
+	files, err := cli.LoadProtos(protos)
+	if err != nil {
+		return err
+	}
+
+	for _, sd := range cli.Services(files) {
+		fmt.Printf("0x%08x %s\n", uint32(pw_rpc.NewKey(string(sd.FullName()))), sd.FullName())
+
+		for i := 0; i < sd.Methods().Len(); i++ {
+			md := sd.Methods().Get(i)
+			fmt.Printf("  0x%08x %s(%s) returns (%s) [%s]\n",
+				uint32(pw_rpc.NewKey(string(md.Name()))),
+				md.Name(),
+				md.Input().FullName(),
+				md.Output().FullName(),
+				methodKind(md))
+		}
+	}
+
+	return nil
+}
+
+func runHash(args []string) error {
+	if len(args) == 0 {
+		usage()
+	}
+
+	for _, name := range args {
+		fmt.Printf("0x%08x %d %s\n", uint32(pw_rpc.NewKey(name)), uint32(pw_rpc.NewKey(name)), name)
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+// Command pwsim is a stand-in device serving the pw.rpc.Benchmark echo
+// service over a pseudo terminal or a Unix socket.
+//
+//	pwsim [-unix path] [-baud n] [-drop p] [-flip p] [-truncate p] [-reorder p] [-latency d] [-seed n]
+//
+// Without -unix it creates a pseudo terminal and prints its path, which host
+// tools open like a serial port:
+//
+//	pwrpc call -proto benchmark.proto /dev/pts/3 pw.rpc.Benchmark/UnaryEcho '{}'
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpctest"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pwsim"
+)
+
+func main() {
+	var imp pwsim.Impairments
+
+	fs := flag.NewFlagSet("pwsim", flag.ExitOnError)
+	unixPath := fs.String("unix", "", "serve on a Unix socket at this path instead of a pty")
+	fs.IntVar(&imp.BaudRate, "baud", 115200, "line speed, 0 for unlimited")
+	fs.Float64Var(&imp.DropRate, "drop", 0, "probability of dropping a byte")
+	fs.Float64Var(&imp.BitFlipRate, "flip", 0, "probability of flipping a bit in a byte")
+	fs.Float64Var(&imp.TruncateRate, "truncate", 0, "probability, per byte, of losing the rest of a frame")
+	fs.Float64Var(&imp.ReorderRate, "reorder", 0, "probability of sending a frame after the next one")
+	fs.DurationVar(&imp.Latency, "latency", 0, "delay added to every byte")
+	fs.Int64Var(&imp.Seed, "seed", time.Now().UnixNano(), "seed for the drops and bit flips")
+	fs.Parse(os.Args[1:])
+
+	if err := run(*unixPath, imp); err != nil {
+		fmt.Fprintf(os.Stderr, "pwsim: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(unixPath string, imp pwsim.Impairments) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	s, err := pw_rpctest.NewEchoServer("")
+	if err != nil {
+		return err
+	}
+
+	var sim pwsim.Simulator
+	if unixPath != "" {
+		sim, err = pwsim.NewUnixSocket(s, unixPath, imp)
+	} else {
+		sim, err = pwsim.NewPty(s, imp)
+	}
+	if err != nil {
+		return err
+	}
+	defer sim.Close()
+
+	fmt.Printf("Simulating device on %s (seed %d)\n", sim.Path(), imp.Seed)
+
+	err = sim.Serve(ctx)
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	return err
+}
@@ -1,49 +1,109 @@
+// Command unit_test_client runs the tests on a device through the
+// pw.unit_test.UnitTest service and reports the results.
+//
+//	unit_test_client [-suite name,...] [-timeout d] [-passed] [-junit file] [-json file] [endpoint]
+//
+// The endpoint is a TCP address (localhost:8112 by default), a serial device,
+// "-" for stdin and stdout, or a URI such as unix:///run/dev.sock,
+// serial:///dev/ttyUSB0?baud=115200, udp://host:port or ws://host:port/path.
+// It exits with status 1 when a test fails or the run does not finish before
+// the timeout.
 package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"time"
 
-	"github.com/robertfarnum/go-pw-rpc/cmd/pb"
+	"github.com/robertfarnum/go-pw-rpc/cmd/internal/cli"
 	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_unit_test"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_unit_test/pb"
 )
 
+var errFailed = errors.New("tests failed")
+
 func main() {
-	ctx := context.Background()
-	cc := pw_rpc.NewClient("localhost:8112")
-	defer cc.Close()
+	fs := flag.NewFlagSet("unit_test_client", flag.ExitOnError)
+	suites := fs.String("suite", "", "comma separated test suites to run, all by default")
+	timeout := fs.Duration("timeout", 10*time.Minute, "time limit for the whole run")
+	passed := fs.Bool("passed", false, "report passed expectations as well as failed ones")
+	junitPath := fs.String("junit", "", "write the results as JUnit XML to this file")
+	jsonPath := fs.String("json", "", "write the results as `go test -json` events to this file")
+	fs.Parse(os.Args[1:])
 
-	utc := pb.NewUnitTestClient(cc)
-	in := &pb.TestRunRequest{
-		ReportPassedExpectations: true,
-		TestSuite:                []string{"Passing", "Failing"},
+	endpoint := "localhost:8112"
+	if fs.NArg() > 0 {
+		endpoint = fs.Arg(0)
 	}
 
-	streamingClient, err := utc.Run(ctx, in)
-	if err != nil {
-		fmt.Println("Error running test:", err)
+	request := &pb.TestRunRequest{ReportPassedExpectations: *passed}
+	if *suites != "" {
+		request.TestSuite = strings.Split(*suites, ",")
+	}
+
+	if err := run(endpoint, request, *timeout, *junitPath, *jsonPath); err != nil {
+		fmt.Fprintf(os.Stderr, "unit_test_client: %s\n", err)
 		os.Exit(1)
 	}
+}
+
+func run(endpoint string, request *pb.TestRunRequest, timeout time.Duration, junitPath string, jsonPath string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	c := pw_rpc.NewClientWithDialer(cli.Dialer(endpoint))
+	defer c.Close()
+
+	results, runErr := pw_unit_test.Run(ctx, pb.NewUnitTestClient(c), request)
+	if ctx.Err() != nil {
+		runErr = fmt.Errorf("timed out after %s", timeout)
+	}
+
+	// Partial results are still reported, so that CI shows how far the run
+	// got.
+	if err := pw_unit_test.WriteSummary(os.Stdout, results); err != nil {
+		return err
+	}
 
-	for {
-		out, err := streamingClient.Recv()
-		if err == nil {
-			switch evt := out.GetType().(type) {
-			case *pb.Event_TestRunStart:
-				fmt.Println(evt.TestRunStart.String())
-			case *pb.Event_TestRunEnd:
-				fmt.Println(evt.TestRunEnd.String())
-			case *pb.Event_TestCaseStart:
-				fmt.Println(evt.TestCaseStart.String())
-			case *pb.Event_TestCaseEnd:
-				fmt.Println(evt.TestCaseEnd.String())
-			case *pb.Event_TestCaseDisabled:
-				fmt.Println(evt.TestCaseDisabled.String())
-			case *pb.Event_TestCaseExpectation:
-				fmt.Println(evt.TestCaseExpectation.String())
-				return
-			}
-		}
+	if err := writeFile(junitPath, results, pw_unit_test.WriteJUnit); err != nil {
+		return err
 	}
+
+	if err := writeFile(jsonPath, results, pw_unit_test.WriteTestJSON); err != nil {
+		return err
+	}
+
+	if runErr != nil {
+		return runErr
+	}
+
+	if !results.Passed() {
+		return errFailed
+	}
+
+	return nil
+}
+
+func writeFile(path string, results *pw_unit_test.Results, write func(io.Writer, *pw_unit_test.Results) error) error {
+	if path == "" {
+		return nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := write(f, results); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
 }
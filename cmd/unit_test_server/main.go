@@ -1,77 +1,58 @@
+// Command unit_test_server serves sample test suites over the
+// pw.unit_test.UnitTest service, for trying out unit_test_client.
 package main
 
 import (
 	"context"
+	"flag"
+	"os"
+	"strings"
+	"time"
 
-	"github.com/robertfarnum/go-pw-rpc/cmd/pb"
 	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
-	"google.golang.org/grpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_unit_test"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_unit_test/pb"
 )
 
-type UnitTestServer struct {
-	pb.UnsafeUnitTestServer
-}
-
-// Run implements pb.UnitTestServer.
-func (u UnitTestServer) Run(request *pb.TestRunRequest, server grpc.ServerStreamingServer[pb.Event]) error {
-	for _, suite := range request.TestSuite {
-		err := server.SendMsg(&pb.Event{
-			Type: &pb.Event_TestRunStart{
-				TestRunStart: &pb.TestRunStart{},
-			},
-		})
-		if err != nil {
-			return err
-		}
-
-		if suite == "Failing" {
-			err := server.Send(&pb.Event{
-				Type: &pb.Event_TestCaseExpectation{
-					TestCaseExpectation: &pb.TestCaseExpectation{
-						Expression: "1 == 2",
-						Success:    false,
-					},
-				},
-			})
-			if err != nil {
-				return err
-			}
-		} else {
-			for _, test := range []string{"Test 1", "Test 2"} {
-				err := server.Send(&pb.Event{
-					Type: &pb.Event_TestCaseStart{
-						TestCaseStart: &pb.TestCaseDescriptor{
-							SuiteName: suite,
-							TestName:  test,
-							FileName:  "File",
-						},
-					},
-				})
-				if err != nil {
-					return err
-				}
-				server.Send(&pb.Event{
-					Type: &pb.Event_TestCaseEnd{},
-				})
-			}
-			err := server.Send(&pb.Event{
-				Type: &pb.Event_TestRunEnd{},
-			})
-			if err != nil {
-				return err
-			}
-		}
-	}
-
-	return nil
-}
-
 func main() {
-	ctx := context.Background()
+	fs := flag.NewFlagSet("unit_test_server", flag.ExitOnError)
+	listen := fs.String("listen", "localhost:8112", "address to listen on")
+	fs.Parse(os.Args[1:])
 
-	s := pw_rpc.NewServer("localhost:8112")
+	ctx := context.Background()
 
-	uts := &UnitTestServer{}
+	s := pw_rpc.NewServer(*listen)
+
+	uts := pw_unit_test.NewServer()
+
+	uts.AddSuite("Passing",
+		pw_unit_test.Test{Name: "Adds", Func: func(t *pw_unit_test.T) {
+			t.ExpectEqual(1+1, 2)
+		}},
+		pw_unit_test.Test{Name: "Upper", Func: func(t *pw_unit_test.T) {
+			t.Expect(strings.ToUpper("pw") == "PW", `strings.ToUpper("pw") == "PW"`)
+		}},
+		pw_unit_test.Test{Name: "Sleeps", Func: func(t *pw_unit_test.T) {
+			time.Sleep(10 * time.Millisecond)
+		}},
+	)
+
+	uts.AddSuite("Failing",
+		pw_unit_test.Test{Name: "Equal", Func: func(t *pw_unit_test.T) {
+			t.ExpectEqual(1, 2)
+		}},
+		pw_unit_test.Test{Name: "Fatal", Func: func(t *pw_unit_test.T) {
+			t.Fatalf("gave up")
+			t.Errorf("not reached")
+		}},
+	)
+
+	uts.AddSuite("Other",
+		pw_unit_test.Test{Name: "DISABLED_Flaky", Func: func(t *pw_unit_test.T) {}},
+		pw_unit_test.Test{Name: "NeedsHardware", Func: func(t *pw_unit_test.T) {
+			t.Skip()
+		}},
+	)
 
 	pb.RegisterUnitTestServer(s, uts)
 
@@ -1,17 +1,16 @@
 module github.com/robertfarnum/go-pw-rpc
 
-go 1.22.7
-
-toolchain go1.23.0
+go 1.23.0
 
 require (
+	golang.org/x/net v0.29.0
+	golang.org/x/sys v0.33.0
+	golang.org/x/term v0.32.0
 	google.golang.org/grpc v1.68.0
 	google.golang.org/protobuf v1.35.1
 )
 
 require (
-	golang.org/x/net v0.29.0 // indirect
-	golang.org/x/sys v0.25.0 // indirect
 	golang.org/x/text v0.18.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20240903143218-8af14fe29dc1 // indirect
 )
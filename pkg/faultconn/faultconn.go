@@ -0,0 +1,316 @@
+// Package faultconn injects transport faults into an io.ReadWriteCloser, to
+// test how the HDLC and pw_rpc layers recover from them. The faults are
+// drawn from a seeded generator, so a failing run can be reproduced.
+package faultconn
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+)
+
+const (
+	// kFlag delimits HDLC frames; truncation drops bytes up to the next one,
+	// and reordering holds back the bytes up to it.
+	kFlag = 0x7e
+)
+
+var (
+	ErrDisconnected = errors.New("faultconn: disconnected")
+)
+
+// Faults are the probabilities of each fault. The zero value injects none.
+type Faults struct {
+	// Seed selects the sequence of faults.
+	Seed int64
+	// DropRate is the probability that a byte is lost.
+	DropRate float64
+	// BitFlipRate is the probability that a byte has one bit flipped.
+	BitFlipRate float64
+	// TruncateRate is the probability, per byte, that the rest of the frame
+	// is lost.
+	TruncateRate float64
+	// StallRate is the probability that a read or write stalls for
+	// StallDuration first.
+	StallRate     float64
+	StallDuration time.Duration
+	// ReorderRate is the probability that a written frame is held back and
+	// sent after the next one.
+	ReorderRate float64
+	// DisconnectRate is the probability that a read or write closes the
+	// connection instead.
+	DisconnectRate float64
+	// DisconnectAfter closes the connection once this many bytes have been
+	// read and written, 0 for never.
+	DisconnectAfter int
+}
+
+// Stats counts the faults a connection has injected.
+type Stats struct {
+	Bytes       int
+	Dropped     int
+	Flipped     int
+	Truncated   int
+	Stalls      int
+	Reordered   int
+	Disconnects int
+}
+
+// Conn is a connection with faults.
+type Conn interface {
+	io.ReadWriteCloser
+	// SetFaults changes the faults, e.g. to check that a connection recovers
+	// once they stop. The generators are reseeded from faults.Seed.
+	SetFaults(faults Faults)
+	Stats() Stats
+}
+
+// direction is the fault state of one direction of a connection.
+type direction struct {
+	rng        *rand.Rand
+	truncating bool
+	inFrame    bool
+	holding    bool
+	held       []byte
+}
+
+type conn struct {
+	rwc    io.ReadWriteCloser
+	faults Faults
+	read   direction
+	write  direction
+	stats  Stats
+	closed bool
+	mu     sync.Mutex
+}
+
+// New wraps rwc, injecting faults into the bytes read and written. Reads and
+// writes draw from separate generators, so each direction is reproducible.
+func New(rwc io.ReadWriteCloser, faults Faults) Conn {
+	c := &conn{
+		rwc: rwc,
+	}
+	c.SetFaults(faults)
+
+	return c
+}
+
+// Dialer wraps the connections of dialer. Each connection is seeded from
+// faults.Seed and the number of earlier dials.
+func Dialer(dialer pw_rpc.Dialer, faults Faults) pw_rpc.Dialer {
+	var mu sync.Mutex
+	dials := int64(0)
+
+	return func(ctx context.Context) (io.ReadWriteCloser, error) {
+		rwc, err := dialer(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		mu.Lock()
+		f := faults
+		f.Seed += dials * 2
+		dials++
+		mu.Unlock()
+
+		return New(rwc, f), nil
+	}
+}
+
+func (c *conn) SetFaults(faults Faults) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.faults = faults
+	c.read = direction{rng: rand.New(rand.NewSource(faults.Seed))}
+	c.write = direction{rng: rand.New(rand.NewSource(faults.Seed + 1))}
+}
+
+func (c *conn) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	if err := c.before(&c.read); err != nil {
+		return 0, err
+	}
+
+	for {
+		n, err := c.rwc.Read(p)
+		if n > 0 {
+			out, ferr := c.inject(&c.read, p[:n])
+			if ferr != nil {
+				return 0, ferr
+			}
+
+			// Keep reading if every byte was lost, as a reader cannot tell
+			// an empty read from the end of the stream.
+			if len(out) > 0 || err != nil {
+				return copy(p, out), err
+			}
+			continue
+		}
+
+		return n, err
+	}
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	if err := c.before(&c.write); err != nil {
+		return 0, err
+	}
+
+	out, err := c.inject(&c.write, p)
+	if err != nil {
+		return 0, err
+	}
+	out = c.reorder(&c.write, out)
+
+	// Lost and held bytes count as written; the writer cannot know.
+	if len(out) > 0 {
+		if _, err := c.rwc.Write(out); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+func (c *conn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+
+	return c.rwc.Close()
+}
+
+// before stalls or disconnects ahead of a read or write.
+func (c *conn) before(d *direction) error {
+	c.mu.Lock()
+
+	if c.closed {
+		c.mu.Unlock()
+		return ErrDisconnected
+	}
+
+	if c.faults.DisconnectRate > 0 && d.rng.Float64() < c.faults.DisconnectRate {
+		c.mu.Unlock()
+		return c.disconnect()
+	}
+
+	stall := time.Duration(0)
+	if c.faults.StallRate > 0 && d.rng.Float64() < c.faults.StallRate {
+		stall = c.faults.StallDuration
+		c.stats.Stalls++
+	}
+
+	c.mu.Unlock()
+
+	time.Sleep(stall)
+
+	return nil
+}
+
+// inject returns the bytes of data that survive the faults.
+func (c *conn) inject(d *direction, data []byte) ([]byte, error) {
+	c.mu.Lock()
+
+	f := c.faults
+	out := make([]byte, 0, len(data))
+
+	for _, b := range data {
+		c.stats.Bytes++
+
+		if f.DisconnectAfter > 0 && c.stats.Bytes > f.DisconnectAfter {
+			c.mu.Unlock()
+			return nil, c.disconnect()
+		}
+
+		if d.truncating {
+			if b != kFlag {
+				c.stats.Dropped++
+				continue
+			}
+			d.truncating = false
+		} else if b != kFlag && f.TruncateRate > 0 && d.rng.Float64() < f.TruncateRate {
+			d.truncating = true
+			c.stats.Truncated++
+			c.stats.Dropped++
+			continue
+		}
+
+		if f.DropRate > 0 && d.rng.Float64() < f.DropRate {
+			c.stats.Dropped++
+			continue
+		}
+
+		if f.BitFlipRate > 0 && d.rng.Float64() < f.BitFlipRate {
+			b ^= 1 << d.rng.Intn(8)
+			c.stats.Flipped++
+		}
+
+		out = append(out, b)
+	}
+
+	c.mu.Unlock()
+
+	return out, nil
+}
+
+// reorder returns the bytes of data to send now. A frame that is held back is
+// sent once the frame after it has been.
+func (c *conn) reorder(d *direction, data []byte) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := make([]byte, 0, len(data)+len(d.held))
+
+	for _, b := range data {
+		switch {
+		case b != kFlag && !d.inFrame:
+			d.inFrame = true
+			d.holding = d.held == nil && c.faults.ReorderRate > 0 && d.rng.Float64() < c.faults.ReorderRate
+			if d.holding {
+				c.stats.Reordered++
+			}
+		case b == kFlag && d.inFrame:
+			d.inFrame = false
+			if d.holding {
+				d.held = append(d.held, b)
+				d.holding = false
+				continue
+			}
+
+			out = append(out, b)
+			out = append(out, d.held...)
+			d.held = nil
+			continue
+		}
+
+		if d.holding {
+			d.held = append(d.held, b)
+		} else {
+			out = append(out, b)
+		}
+	}
+
+	return out
+}
+
+func (c *conn) disconnect() error {
+	c.mu.Lock()
+	c.stats.Disconnects++
+	c.mu.Unlock()
+
+	c.Close()
+
+	return ErrDisconnected
+}
@@ -0,0 +1,125 @@
+package faultconn
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_hdlc"
+)
+
+// buffer is an in-memory io.ReadWriteCloser.
+type buffer struct {
+	bytes.Buffer
+}
+
+func (b *buffer) Close() error {
+	return nil
+}
+
+func testData() []byte {
+	data := make([]byte, 0, 4096)
+	for i := 0; i < cap(data); i++ {
+		if i%64 == 0 {
+			data = append(data, kFlag)
+		} else {
+			data = append(data, byte(i))
+		}
+	}
+
+	return data
+}
+
+func TestDeterministic(t *testing.T) {
+	faults := Faults{
+		Seed:         7,
+		DropRate:     0.05,
+		BitFlipRate:  0.05,
+		TruncateRate: 0.01,
+	}
+
+	var outputs [][]byte
+	var stats []Stats
+	for i := 0; i < 2; i++ {
+		b := &buffer{}
+		c := New(b, faults)
+		if _, err := c.Write(testData()); err != nil {
+			t.Fatal(err)
+		}
+
+		outputs = append(outputs, b.Bytes())
+		stats = append(stats, c.Stats())
+	}
+
+	if !bytes.Equal(outputs[0], outputs[1]) || stats[0] != stats[1] {
+		t.Errorf("the same seed injected different faults: %+v, %+v", stats[0], stats[1])
+	}
+
+	s := stats[0]
+	if s.Dropped == 0 || s.Flipped == 0 || s.Truncated == 0 {
+		t.Errorf("faults not injected: %+v", s)
+	}
+	if s.Bytes != len(testData()) || len(outputs[0]) != s.Bytes-s.Dropped {
+		t.Errorf("wrote %d of %d bytes with %+v", len(outputs[0]), len(testData()), s)
+	}
+}
+
+func TestTruncateKeepsFlags(t *testing.T) {
+	b := &buffer{}
+	c := New(b, Faults{TruncateRate: 1})
+
+	if _, err := c.Write(testData()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, by := range b.Bytes() {
+		if by != kFlag {
+			t.Fatalf("truncation kept byte 0x%02x", by)
+		}
+	}
+}
+
+func TestDisconnectAfter(t *testing.T) {
+	b := &buffer{}
+	b.Write(testData())
+	c := New(b, Faults{DisconnectAfter: 100})
+
+	n, err := io.ReadFull(c, make([]byte, 200))
+	if err != ErrDisconnected || n > 100 {
+		t.Errorf("ReadFull() = %d, %v; want at most 100 bytes and ErrDisconnected", n, err)
+	}
+
+	if _, err := c.Write([]byte{1}); err != ErrDisconnected {
+		t.Errorf("Write() after disconnect = %v", err)
+	}
+}
+
+func TestReorderSwapsFrames(t *testing.T) {
+	b := &buffer{}
+	c := New(b, Faults{ReorderRate: 1})
+
+	encoder := pw_hdlc.NewEncoder(c, 'R')
+	for _, payload := range []string{"a", "b", "c", "d"} {
+		if err := encoder.Encode([]byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every other frame is held back, and sent after the next.
+	decoder := pw_hdlc.NewDecoder(b, 'R')
+	var got string
+	for {
+		frame, err := decoder.Decode(context.Background())
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got += string(frame.Payload())
+	}
+
+	if got != "badc" || c.Stats().Reordered != 2 {
+		t.Errorf("received %q with %+v, want \"badc\"", got, c.Stats())
+	}
+}
@@ -0,0 +1,366 @@
+package faultconn_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	cmdpb "github.com/robertfarnum/go-pw-rpc/cmd/pb"
+	"github.com/robertfarnum/go-pw-rpc/pkg/faultconn"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_hdlc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpctest"
+)
+
+const (
+	kCallTimeout = 100 * time.Millisecond
+	kTestTimeout = 30 * time.Second
+)
+
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func TestDecoderRecovers(t *testing.T) {
+	sent := map[string]bool{}
+
+	var wire bytes.Buffer
+	c := faultconn.New(nopCloser{&wire}, faultconn.Faults{
+		Seed:         1,
+		DropRate:     0.002,
+		BitFlipRate:  0.002,
+		TruncateRate: 0.002,
+	})
+	encoder := pw_hdlc.NewEncoder(c, 'R')
+
+	for i := 0; i < 500; i++ {
+		payload := fmt.Sprintf("frame %d %s", i, bytes.Repeat([]byte{0x7e, 0x7d}, i%8))
+		sent[payload] = true
+		if err := encoder.Encode([]byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	decoder := pw_hdlc.NewDecoder(&wire, 'R')
+	received, lost := 0, 0
+	for {
+		frame, err := decoder.Decode(context.Background())
+		if err == io.EOF {
+			break
+		}
+
+		var decodeErr *pw_hdlc.DecodeError
+		if errors.As(err, &decodeErr) {
+			lost++
+			continue
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		if !sent[string(frame.Payload())] {
+			t.Errorf("decoded a corrupted frame: %q", frame.Payload())
+		}
+		received++
+	}
+
+	if lost == 0 || received < 400 {
+		t.Errorf("received %d frames and lost %d with %+v", received, lost, c.Stats())
+	}
+}
+
+// faultyClient connects a client to an echo server through faults, in
+// framing or HDLC if it is nil. It returns the connections it dialed.
+func faultyClient(t *testing.T, ctx context.Context, framing pw_rpc.FrameTransport, faults faultconn.Faults) (pw_rpc.Client, func() []faultconn.Conn) {
+	server, err := pw_rpctest.NewEchoServer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetFraming(framing)
+
+	var mu sync.Mutex
+	var conns []faultconn.Conn
+	dialer := faultconn.Dialer(pw_rpctest.Dialer(ctx, server.Serve), faults)
+
+	c := pw_rpc.NewClientWithDialer(func(ctx context.Context) (io.ReadWriteCloser, error) {
+		rwc, err := dialer(ctx)
+		if err == nil {
+			mu.Lock()
+			conns = append(conns, rwc.(faultconn.Conn))
+			mu.Unlock()
+		}
+		return rwc, err
+	})
+	c.SetFraming(framing)
+	t.Cleanup(c.Close)
+
+	return c, func() []faultconn.Conn {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]faultconn.Conn(nil), conns...)
+	}
+}
+
+// echo makes a call with a short deadline, and returns whether it succeeded.
+// A call may fail, but must not hang or return a wrong response.
+func echo(t *testing.T, ctx context.Context, client pw_rpc.Client, i int) bool {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(ctx, kCallTimeout)
+	defer cancel()
+
+	err := pw_rpctest.Echo(ctx, client, []byte(fmt.Sprintf("call %d", i)))
+	if errors.Is(err, pw_rpctest.ErrEchoMismatch) {
+		t.Error(err)
+	}
+
+	return err == nil
+}
+
+// bidi checks that a bidirectional stream works.
+func bidi(t *testing.T, ctx context.Context, client pw_rpc.Client) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(ctx, kCallTimeout)
+	defer cancel()
+
+	stream, err := cmdpb.NewBenchmarkClient(client).BidirectionalEcho(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		payload := []byte(fmt.Sprintf("message %d", i))
+		if err := stream.Send(&cmdpb.Payload{Payload: payload}); err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(res.Payload, payload) {
+			t.Errorf("BidirectionalEcho(%q) = %q", payload, res.Payload)
+		}
+	}
+
+	stream.CloseSend()
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("BidirectionalEcho Recv() = %v, want io.EOF", err)
+	}
+}
+
+// withTimeout fails the test if run deadlocks.
+func withTimeout(t *testing.T, run func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(ctx)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(kTestTimeout):
+		t.Fatal("deadlock")
+	}
+}
+
+func TestClientRecoversFromCorruption(t *testing.T) {
+	withTimeout(t, func(ctx context.Context) {
+		client, conns := faultyClient(t, ctx, nil, faultconn.Faults{
+			Seed:         2,
+			DropRate:     0.002,
+			BitFlipRate:  0.002,
+			TruncateRate: 0.002,
+		})
+
+		failed := 0
+		for i := 0; i < 100; i++ {
+			if !echo(t, ctx, client, i) {
+				failed++
+			}
+		}
+
+		stats := conns()[0].Stats()
+		if failed == 0 || stats.Dropped == 0 || stats.Flipped == 0 {
+			t.Errorf("%d calls failed with %+v", failed, stats)
+		}
+
+		// Once the line is clean, every call succeeds.
+		for _, c := range conns() {
+			c.SetFaults(faultconn.Faults{})
+		}
+		for i := 0; i < 20; i++ {
+			if !echo(t, ctx, client, i) {
+				t.Errorf("call %d failed without faults", i)
+			}
+		}
+		bidi(t, ctx, client)
+	})
+}
+
+func TestReliableLinkRecoversFromCorruption(t *testing.T) {
+	withTimeout(t, func(ctx context.Context) {
+		framing := pw_rpc.NewReliableHdlcFraming(pw_hdlc.LinkConfig{
+			RetransmitTimeout: 5 * time.Millisecond,
+			MaxRetransmits:    100,
+		})
+		client, conns := faultyClient(t, ctx, framing, faultconn.Faults{
+			Seed:         2,
+			DropRate:     0.002,
+			BitFlipRate:  0.002,
+			TruncateRate: 0.002,
+		})
+
+		// The faults that fail calls over plain HDLC are recovered from.
+		for i := 0; i < 100; i++ {
+			if !echo(t, ctx, client, i) {
+				t.Errorf("call %d failed", i)
+			}
+		}
+		bidi(t, ctx, client)
+
+		stats := conns()[0].Stats()
+		if len(conns()) != 1 || stats.Dropped == 0 || stats.Flipped == 0 {
+			t.Errorf("%d connections with %+v", len(conns()), stats)
+		}
+	})
+}
+
+func TestClientRecoversFromReordering(t *testing.T) {
+	withTimeout(t, func(ctx context.Context) {
+		client, conns := faultyClient(t, ctx, nil, faultconn.Faults{
+			Seed:        5,
+			ReorderRate: 0.1,
+		})
+
+		// A held back request is answered after a later one, once its call
+		// has given up; the late response must not complete the later call.
+		failed := 0
+		for i := 0; i < 100; i++ {
+			if !echo(t, ctx, client, i) {
+				failed++
+			}
+		}
+
+		if stats := conns()[0].Stats(); failed == 0 || stats.Reordered == 0 {
+			t.Errorf("%d calls failed with %+v", failed, stats)
+		}
+
+		for _, c := range conns() {
+			c.SetFaults(faultconn.Faults{})
+		}
+		for i := 0; i < 20; i++ {
+			if !echo(t, ctx, client, i) {
+				t.Errorf("call %d failed without faults", i)
+			}
+		}
+		bidi(t, ctx, client)
+	})
+}
+
+func TestReliableLinkRecoversFromReordering(t *testing.T) {
+	withTimeout(t, func(ctx context.Context) {
+		framing := pw_rpc.NewReliableHdlcFraming(pw_hdlc.LinkConfig{
+			RetransmitTimeout: 5 * time.Millisecond,
+			MaxRetransmits:    100,
+		})
+		client, conns := faultyClient(t, ctx, framing, faultconn.Faults{
+			Seed:        5,
+			ReorderRate: 0.1,
+		})
+
+		// The link delivers the frames in sequence.
+		for i := 0; i < 100; i++ {
+			if !echo(t, ctx, client, i) {
+				t.Errorf("call %d failed", i)
+			}
+		}
+		bidi(t, ctx, client)
+
+		if stats := conns()[0].Stats(); len(conns()) != 1 || stats.Reordered == 0 {
+			t.Errorf("%d connections with %+v", len(conns()), stats)
+		}
+	})
+}
+
+func TestClientRecoversFromStalls(t *testing.T) {
+	withTimeout(t, func(ctx context.Context) {
+		client, conns := faultyClient(t, ctx, nil, faultconn.Faults{
+			Seed:          3,
+			StallRate:     0.002,
+			StallDuration: 2 * kCallTimeout,
+		})
+
+		failed := 0
+		for i := 0; i < 50; i++ {
+			if !echo(t, ctx, client, i) {
+				failed++
+			}
+		}
+
+		if stats := conns()[0].Stats(); failed == 0 || stats.Stalls == 0 {
+			t.Errorf("%d calls failed with %+v", failed, stats)
+		}
+
+		for _, c := range conns() {
+			c.SetFaults(faultconn.Faults{})
+		}
+		for i := 0; i < 10; i++ {
+			if !echo(t, ctx, client, i) {
+				t.Errorf("call %d failed without faults", i)
+			}
+		}
+	})
+}
+
+func TestClientReconnects(t *testing.T) {
+	withTimeout(t, func(ctx context.Context) {
+		client, conns := faultyClient(t, ctx, nil, faultconn.Faults{
+			Seed:            4,
+			DisconnectAfter: 500,
+		})
+
+		succeeded := 0
+		for i := 0; i < 50; i++ {
+			if echo(t, ctx, client, i) {
+				succeeded++
+			}
+		}
+
+		if len(conns()) < 2 || succeeded < 40 {
+			t.Errorf("%d calls succeeded over %d connections", succeeded, len(conns()))
+		}
+	})
+}
+
+func TestPendingCallsFailOnDisconnect(t *testing.T) {
+	withTimeout(t, func(ctx context.Context) {
+		client, conns := faultyClient(t, ctx, nil, faultconn.Faults{})
+
+		stream, err := cmdpb.NewBenchmarkClient(client).BidirectionalEcho(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conns()[0].Close()
+
+		if _, err := stream.Recv(); pw_rpc.StatusCode(err) != pb.StatusCode_UNAVAILABLE {
+			t.Errorf("Recv() after disconnect = %v, want UNAVAILABLE", err)
+		}
+
+		bidi(t, ctx, client)
+	})
+}
@@ -0,0 +1,156 @@
+package pw_capture
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_hdlc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// jsonRecord is the JSON lines form of a Record.
+type jsonRecord struct {
+	Time      time.Time       `json:"time"`
+	Direction string          `json:"direction"`
+	Address   *uint64         `json:"address,omitempty"`
+	Control   *byte           `json:"control,omitempty"`
+	Raw       string          `json:"raw,omitempty"`
+	Packet    json.RawMessage `json:"packet,omitempty"`
+	Summary   string          `json:"summary,omitempty"`
+}
+
+type jsonWriter struct {
+	w   io.Writer
+	enc *json.Encoder
+	mu  sync.Mutex
+}
+
+// NewJSONWriter writes one JSON object per frame to w. The records hold the
+// raw frame in hex, the decoded packet and a readable summary. Packets
+// captured without an HDLC frame have no address, control or raw frame.
+func NewJSONWriter(w io.Writer) Writer {
+	return &jsonWriter{
+		w:   w,
+		enc: json.NewEncoder(w),
+	}
+}
+
+func (jw *jsonWriter) Capture(at time.Time, direction pw_rpc.Direction, frame *pw_hdlc.Frame, packet *pb.RpcPacket) {
+	jw.Write(&Record{
+		Time:      at,
+		Direction: direction,
+		Frame:     frame,
+		Packet:    packet,
+	})
+}
+
+func (jw *jsonWriter) Write(r *Record) error {
+	jr := &jsonRecord{
+		Time:      r.Time,
+		Direction: r.Direction.String(),
+	}
+
+	if r.Frame != nil {
+		address, control := r.Frame.Address(), r.Frame.Control()
+		jr.Address = &address
+		jr.Control = &control
+		jr.Raw = hex.EncodeToString(r.Frame.Raw())
+	}
+
+	if r.Packet != nil {
+		packet, err := protojson.Marshal(r.Packet)
+		if err != nil {
+			return err
+		}
+		jr.Packet = packet
+		jr.Summary = pw_rpc.FormatPacket(r.Packet)
+	}
+
+	jw.mu.Lock()
+	defer jw.mu.Unlock()
+
+	return jw.enc.Encode(jr)
+}
+
+func (jw *jsonWriter) Close() error {
+	return closer(jw.w)
+}
+
+// JSONReader reads the records of a JSON lines capture.
+type JSONReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func NewJSONReader(r io.Reader) *JSONReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	return &JSONReader{scanner: scanner}
+}
+
+// Read returns the next record, or io.EOF at the end of the capture.
+func (jr *JSONReader) Read() (*Record, error) {
+	for jr.scanner.Scan() {
+		jr.line++
+
+		line := jr.scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var j jsonRecord
+		if err := json.Unmarshal(line, &j); err != nil {
+			return nil, fmt.Errorf("line %d: %w", jr.line, err)
+		}
+
+		return j.record(jr.line)
+	}
+
+	if err := jr.scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, io.EOF
+}
+
+func (j *jsonRecord) record(line int) (*Record, error) {
+	r := &Record{
+		Time:      j.Time,
+		Direction: pw_rpc.Inbound,
+	}
+
+	if j.Raw != "" {
+		raw, err := hex.DecodeString(j.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		r.Frame, err = parseRaw(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	} else if len(j.Packet) == 0 {
+		return nil, fmt.Errorf("line %d: %w: no frame or packet", line, ErrBadRecord)
+	}
+
+	if j.Direction == pw_rpc.Outbound.String() {
+		r.Direction = pw_rpc.Outbound
+	}
+
+	if len(j.Packet) > 0 {
+		r.Packet = &pb.RpcPacket{}
+		if err := protojson.Unmarshal(j.Packet, r.Packet); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+
+	return r, nil
+}
@@ -0,0 +1,160 @@
+package pw_capture
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_hdlc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// LinkTypeUser0 is LINKTYPE_USER0 (DLT_USER0), reserved for private use.
+	// Wireshark can be told to dissect it as HDLC in its DLT_USER settings.
+	LinkTypeUser0 = 147
+	// LinkTypeUser1 is LINKTYPE_USER1 (DLT_USER1), used for the encoded
+	// RpcPackets captured without an HDLC frame.
+	LinkTypeUser1 = 148
+
+	kSectionHeaderBlock    = 0x0A0D0D0A
+	kInterfaceDescBlock    = 0x00000001
+	kEnhancedPacketBlock   = 0x00000006
+	kByteOrderMagic        = 0x1A2B3C4D
+	kOptionEnd             = 0
+	kOptionIfTsResol       = 9
+	kOptionEpbFlags        = 2
+	kEpbFlagsInbound       = 0x1
+	kEpbFlagsOutbound      = 0x2
+	kNanosecondsResolution = 9
+	kHdlcInterface         = 0
+	kPacketInterface       = 1
+)
+
+type pcapngWriter struct {
+	w io.Writer
+	// hasPackets is set once the LINKTYPE_USER1 interface has been written.
+	hasPackets bool
+	mu         sync.Mutex
+}
+
+// NewPcapngWriter writes a pcapng capture to w with one LINKTYPE_USER0
+// interface. Each frame is stored as its raw contents (address, control,
+// payload and FCS) with nanosecond timestamps, and its direction is recorded
+// in the epb_flags option. Packets captured without an HDLC frame are stored
+// encoded on a second, LINKTYPE_USER1, interface, added when the first one
+// is written.
+func NewPcapngWriter(w io.Writer) (Writer, error) {
+	pw := &pcapngWriter{w: w}
+
+	shb := binary.LittleEndian.AppendUint32(nil, kByteOrderMagic)
+	shb = binary.LittleEndian.AppendUint16(shb, 1) // Major version
+	shb = binary.LittleEndian.AppendUint16(shb, 0) // Minor version
+	shb = binary.LittleEndian.AppendUint64(shb, 0xFFFFFFFFFFFFFFFF)
+	if err := pw.writeBlock(kSectionHeaderBlock, shb); err != nil {
+		return nil, err
+	}
+
+	if err := pw.writeInterface(LinkTypeUser0); err != nil {
+		return nil, err
+	}
+
+	return pw, nil
+}
+
+func (pw *pcapngWriter) writeInterface(linkType uint16) error {
+	idb := binary.LittleEndian.AppendUint16(nil, linkType)
+	idb = binary.LittleEndian.AppendUint16(idb, 0) // Reserved
+	idb = binary.LittleEndian.AppendUint32(idb, 0) // No snap length
+	idb = appendOption(idb, kOptionIfTsResol, []byte{kNanosecondsResolution})
+	idb = appendOption(idb, kOptionEnd, nil)
+
+	return pw.writeBlock(kInterfaceDescBlock, idb)
+}
+
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+
+	return append(b, make([]byte, padding(len(value)))...)
+}
+
+func padding(n int) int {
+	return (4 - n%4) % 4
+}
+
+func (pw *pcapngWriter) writeBlock(blockType uint32, body []byte) error {
+	length := uint32(12 + len(body))
+
+	b := binary.LittleEndian.AppendUint32(nil, blockType)
+	b = binary.LittleEndian.AppendUint32(b, length)
+	b = append(b, body...)
+	b = binary.LittleEndian.AppendUint32(b, length)
+
+	_, err := pw.w.Write(b)
+
+	return err
+}
+
+func (pw *pcapngWriter) Capture(at time.Time, direction pw_rpc.Direction, frame *pw_hdlc.Frame, packet *pb.RpcPacket) {
+	pw.Write(&Record{
+		Time:      at,
+		Direction: direction,
+		Frame:     frame,
+		Packet:    packet,
+	})
+}
+
+func (pw *pcapngWriter) Write(r *Record) error {
+	var raw []byte
+	iface := uint32(kHdlcInterface)
+	switch {
+	case r.Frame != nil:
+		raw = r.Frame.Raw()
+	case r.Packet != nil:
+		var err error
+		if raw, err = proto.Marshal(r.Packet); err != nil {
+			return err
+		}
+		iface = kPacketInterface
+	default:
+		return ErrBadRecord
+	}
+
+	ts := uint64(r.Time.UnixNano())
+
+	flags := uint32(kEpbFlagsInbound)
+	if r.Direction == pw_rpc.Outbound {
+		flags = kEpbFlagsOutbound
+	}
+
+	epb := binary.LittleEndian.AppendUint32(nil, iface)
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts>>32))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(raw)))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(raw)))
+	epb = append(epb, raw...)
+	epb = append(epb, make([]byte, padding(len(raw)))...)
+	epb = appendOption(epb, kOptionEpbFlags, binary.LittleEndian.AppendUint32(nil, flags))
+	epb = appendOption(epb, kOptionEnd, nil)
+
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	if iface == kPacketInterface && !pw.hasPackets {
+		if err := pw.writeInterface(LinkTypeUser1); err != nil {
+			return err
+		}
+		pw.hasPackets = true
+	}
+
+	return pw.writeBlock(kEnhancedPacketBlock, epb)
+}
+
+func (pw *pcapngWriter) Close() error {
+	return closer(pw.w)
+}
@@ -0,0 +1,84 @@
+package pw_capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_hdlc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_varint"
+)
+
+var (
+	ErrBadRecord = errors.New("bad capture record")
+)
+
+// Record is one captured frame.
+type Record struct {
+	Time      time.Time
+	Direction pw_rpc.Direction
+	// Frame is the HDLC frame, or nil for a packet captured in another
+	// framing.
+	Frame *pw_hdlc.Frame
+	// Packet is the decoded RPC packet, or nil for frames on other addresses.
+	Packet *pb.RpcPacket
+}
+
+// Writer saves the frames it captures. It is a pw_rpc.Tap, so it can be
+// given to Client.SetTap or Server.SetTap.
+type Writer interface {
+	pw_rpc.Tap
+	Write(*Record) error
+	Close() error
+}
+
+// Create opens a capture file. Files ending in .pcapng are written in pcapng
+// format, anything else as JSON lines.
+func Create(path string) (Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if filepath.Ext(path) == ".pcapng" {
+		w, err := NewPcapngWriter(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return w, nil
+	}
+
+	return NewJSONWriter(f), nil
+}
+
+// parseRaw rebuilds a frame from its raw contents, as returned by
+// pw_hdlc.Frame.Raw.
+func parseRaw(raw []byte) (*pw_hdlc.Frame, error) {
+	address, addressSize := pw_varint.Decode(raw, pw_varint.OneTerminatedLeastSignificant)
+	if addressSize < 1 || len(raw) < addressSize+5 {
+		return nil, ErrBadRecord
+	}
+
+	fcsStart := len(raw) - 4
+	if binary.LittleEndian.Uint32(raw[fcsStart:]) != crc32.ChecksumIEEE(raw[:fcsStart]) {
+		return nil, ErrBadRecord
+	}
+
+	return pw_hdlc.NewFrame(address, raw[addressSize], raw[addressSize+1:fcsStart]), nil
+}
+
+// closer closes the underlying writer if it is an io.Closer.
+func closer(w io.Writer) error {
+	if c, ok := w.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
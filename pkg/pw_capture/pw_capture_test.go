@@ -0,0 +1,185 @@
+package pw_capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_hdlc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+func testRecord(t *testing.T) *Record {
+	packet := &pb.RpcPacket{
+		Type:      pb.PacketType_REQUEST,
+		ChannelId: 1,
+		ServiceId: 0x01020304,
+		MethodId:  0x05060708,
+		Payload:   []byte{0x0a, 0x02, 'h', 'i'},
+		CallId:    3,
+	}
+
+	payload, err := proto.Marshal(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Record{
+		Time:      time.Unix(1700000000, 123456789).UTC(),
+		Direction: pw_rpc.Outbound,
+		Frame:     pw_hdlc.NewFrame('R', 0x03, payload),
+		Packet:    packet,
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	want := testRecord(t)
+
+	var buf bytes.Buffer
+	w := NewJSONWriter(&buf)
+	if err := w.Write(want); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewJSONReader(&buf)
+
+	got, err := r.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !got.Time.Equal(want.Time) || got.Direction != want.Direction {
+		t.Errorf("got %v %v, want %v %v", got.Time, got.Direction, want.Time, want.Direction)
+	}
+
+	if !bytes.Equal(got.Frame.Raw(), want.Frame.Raw()) {
+		t.Errorf("raw = %x, want %x", got.Frame.Raw(), want.Frame.Raw())
+	}
+
+	if !proto.Equal(got.Packet, want.Packet) {
+		t.Errorf("packet = %v, want %v", got.Packet, want.Packet)
+	}
+
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("Read at end = %v, want EOF", err)
+	}
+}
+
+func TestPcapngBlocks(t *testing.T) {
+	var buf bytes.Buffer
+
+	w, err := NewPcapngWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := testRecord(t)
+	if err := w.Write(record); err != nil {
+		t.Fatal(err)
+	}
+
+	var types []uint32
+	b := buf.Bytes()
+	for len(b) > 0 {
+		if len(b) < 12 {
+			t.Fatalf("truncated block: %x", b)
+		}
+
+		blockType := binary.LittleEndian.Uint32(b)
+		length := binary.LittleEndian.Uint32(b[4:])
+		if length%4 != 0 || int(length) > len(b) || binary.LittleEndian.Uint32(b[length-4:]) != length {
+			t.Fatalf("bad block length %d", length)
+		}
+
+		if blockType == kEnhancedPacketBlock {
+			raw := record.Frame.Raw()
+			if got := binary.LittleEndian.Uint32(b[20:]); got != uint32(len(raw)) {
+				t.Errorf("captured length = %d, want %d", got, len(raw))
+			}
+			if !bytes.Equal(b[28:28+len(raw)], raw) {
+				t.Errorf("packet data = %x, want %x", b[28:28+len(raw)], raw)
+			}
+		}
+
+		types = append(types, blockType)
+		b = b[length:]
+	}
+
+	want := []uint32{kSectionHeaderBlock, kInterfaceDescBlock, kEnhancedPacketBlock}
+	if len(types) != len(want) {
+		t.Fatalf("blocks = %x, want %x", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Errorf("block %d = %x, want %x", i, types[i], want[i])
+		}
+	}
+}
+
+func TestRecordWithoutFrame(t *testing.T) {
+	want := testRecord(t)
+	want.Frame = nil
+
+	var buf bytes.Buffer
+	if err := NewJSONWriter(&buf).Write(want); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := NewJSONReader(&buf).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Frame != nil || !proto.Equal(got.Packet, want.Packet) {
+		t.Errorf("got frame %v packet %v, want no frame and %v", got.Frame, got.Packet, want.Packet)
+	}
+
+	buf.Reset()
+	w, err := NewPcapngWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(testRecord(t)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := w.Write(want); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The packets go on a second interface, described once.
+	var interfaces []uint32
+	b := buf.Bytes()
+	for len(b) > 0 {
+		blockType := binary.LittleEndian.Uint32(b)
+		length := binary.LittleEndian.Uint32(b[4:])
+
+		switch blockType {
+		case kInterfaceDescBlock:
+			if linkType := binary.LittleEndian.Uint16(b[8:]); int(linkType) != LinkTypeUser0+len(interfaces) {
+				t.Errorf("interface %d link type = %d", len(interfaces), linkType)
+			}
+			interfaces = append(interfaces, blockType)
+		case kEnhancedPacketBlock:
+			iface := binary.LittleEndian.Uint32(b[8:])
+			if int(iface) >= len(interfaces) {
+				t.Errorf("packet on undescribed interface %d", iface)
+			}
+			if iface == kPacketInterface {
+				packet := &pb.RpcPacket{}
+				if err := proto.Unmarshal(b[28:28+binary.LittleEndian.Uint32(b[20:])], packet); err != nil || !proto.Equal(packet, want.Packet) {
+					t.Errorf("packet data = %v, %v", packet, err)
+				}
+			}
+		}
+
+		b = b[length:]
+	}
+	if len(interfaces) != 2 {
+		t.Errorf("%d interfaces, want 2", len(interfaces))
+	}
+}
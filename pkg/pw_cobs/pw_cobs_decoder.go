@@ -0,0 +1,119 @@
+package pw_cobs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+type Decoder interface {
+	// Decode returns the payload of the next frame. A frame that cannot be
+	// decoded or fails its checksum is dropped with a DecodeError.
+	Decode(context.Context) ([]byte, error)
+}
+
+func NewDecoder(reader io.Reader, checksum Checksum) Decoder {
+	return &decoder{
+		reader:   reader,
+		checksum: checksum,
+	}
+}
+
+type decoder struct {
+	reader   io.Reader
+	checksum Checksum
+	// remaining is the number of data bytes left in the current block, and
+	// code the code that started it; zero before the first block.
+	remaining int
+	code      byte
+	buffer    []byte
+	mu        sync.Mutex
+}
+
+func (d *decoder) Decode(ctx context.Context) (payload []byte, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	buf := make([]byte, 1)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("cancelled")
+		default:
+			_, err = d.reader.Read(buf)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		payload, err = d.process(buf[0])
+		if err == ErrUnavailable {
+			continue
+		}
+
+		d.reset()
+
+		return payload, err
+	}
+}
+
+func (d *decoder) reset() {
+	d.remaining = 0
+	d.code = 0
+	d.buffer = nil
+}
+
+func (d *decoder) dataLoss(reason string) error {
+	return &DecodeError{
+		Err:    ErrDataLoss,
+		Reason: reason,
+		Data:   d.buffer,
+	}
+}
+
+func (d *decoder) process(newByte byte) ([]byte, error) {
+	if newByte == kDelimiter {
+		return d.checkFrame()
+	}
+
+	if d.remaining > 0 {
+		d.buffer = append(d.buffer, newByte)
+		d.remaining--
+		return nil, ErrUnavailable
+	}
+
+	// A new block. The previous block stood for a zero unless it was full.
+	if d.code != 0 && d.code != kMaxBlock {
+		d.buffer = append(d.buffer, kDelimiter)
+	}
+	d.code = newByte
+	d.remaining = int(newByte) - 1
+
+	return nil, ErrUnavailable
+}
+
+func (d *decoder) checkFrame() ([]byte, error) {
+	// Empty frames are not an error; repeated delimiters are okay.
+	if d.code == 0 {
+		return nil, ErrUnavailable
+	}
+
+	if d.remaining > 0 {
+		return nil, d.dataLoss(ReasonTruncated)
+	}
+
+	size := len(d.buffer) - d.checksum.Size()
+	if size < 0 {
+		return nil, d.dataLoss(ReasonTooShort)
+	}
+
+	payload := d.buffer[:size]
+	if !bytes.Equal(d.checksum.Append(nil, payload), d.buffer[size:]) {
+		return nil, d.dataLoss(ReasonBadCrc)
+	}
+
+	return payload, nil
+}
@@ -0,0 +1,44 @@
+package pw_cobs
+
+import (
+	"io"
+	"sync"
+)
+
+type Encoder interface {
+	Encode(payload []byte) error
+}
+
+// NewEncoder returns an Encoder that writes each payload to writer as one
+// frame, between delimiters, ending with checksum.
+func NewEncoder(writer io.Writer, checksum Checksum) Encoder {
+	return &encoder{
+		writer:   writer,
+		checksum: checksum,
+	}
+}
+
+type encoder struct {
+	writer   io.Writer
+	checksum Checksum
+	mu       sync.Mutex
+}
+
+// Encode writes the frame of payload with a single Write. The leading
+// delimiter ends any partial frame left on the line, as HDLC's opening flag
+// does.
+func (e *encoder) Encode(payload []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	data := e.checksum.Append(append(make([]byte, 0, len(payload)+e.checksum.Size()), payload...), payload)
+
+	frame := make([]byte, 0, MaxEncodedSize(len(data))+2)
+	frame = append(frame, kDelimiter)
+	frame = Encode(frame, data)
+	frame = append(frame, kDelimiter)
+
+	_, err := e.writer.Write(frame)
+
+	return err
+}
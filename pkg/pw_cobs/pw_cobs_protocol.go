@@ -0,0 +1,175 @@
+// Package pw_cobs frames packets with Consistent Overhead Byte Stuffing, as
+// Pigweed's pw_cobs does. Frames are delimited by zero bytes and may end
+// with a CRC of their data.
+package pw_cobs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+const (
+	kDelimiter = byte(0x00)
+	// kMaxBlock is the code of a block of 254 non-zero bytes that is not
+	// followed by a zero.
+	kMaxBlock = byte(0xFF)
+)
+
+var (
+	ErrDataLoss    = errors.New("data loss")
+	ErrUnavailable = errors.New("unavailable")
+)
+
+// The reasons a DecodeError gives for dropping a frame.
+const (
+	ReasonZeroByte  = "zero byte in block"
+	ReasonTruncated = "block shorter than its code"
+	ReasonTooShort  = "frame shorter than its checksum"
+	ReasonBadCrc    = "checksum mismatch"
+)
+
+// DecodeError describes a frame a decoder dropped. Err is ErrDataLoss and
+// Data holds the bytes of the frame that were decoded.
+type DecodeError struct {
+	Err    error
+	Reason string
+	Data   []byte
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s: %s (%d bytes)", e.Err, e.Reason, len(e.Data))
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Checksum is the CRC that ends each frame, in little endian byte order.
+type Checksum int
+
+const (
+	ChecksumNone Checksum = iota
+	// Crc16 is CRC-16-CCITT, as pw_checksum's Crc16Ccitt.
+	Crc16
+	// Crc32 is the IEEE CRC-32 of HDLC frames, as pw_checksum's Crc32.
+	Crc32
+)
+
+func (c Checksum) String() string {
+	switch c {
+	case ChecksumNone:
+		return "none"
+	case Crc16:
+		return "crc16"
+	case Crc32:
+		return "crc32"
+	}
+
+	return fmt.Sprintf("Checksum(%d)", int(c))
+}
+
+// Size is the number of bytes the checksum adds to a frame.
+func (c Checksum) Size() int {
+	switch c {
+	case Crc16:
+		return 2
+	case Crc32:
+		return 4
+	}
+
+	return 0
+}
+
+// Append appends the checksum of data to dst.
+func (c Checksum) Append(dst []byte, data []byte) []byte {
+	switch c {
+	case Crc16:
+		return binary.LittleEndian.AppendUint16(dst, crc16(0xFFFF, data))
+	case Crc32:
+		return binary.LittleEndian.AppendUint32(dst, crc32.ChecksumIEEE(data))
+	}
+
+	return dst
+}
+
+// crc16 updates a CRC-16-CCITT, polynomial 0x1021, with data.
+func crc16(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
+
+// MaxEncodedSize is the largest size of size bytes when COBS encoded,
+// without delimiters.
+func MaxEncodedSize(size int) int {
+	return size + size/254 + 1
+}
+
+// Encode appends the COBS encoding of data to dst. The encoding has no zero
+// bytes and no delimiter.
+func Encode(dst []byte, data []byte) []byte {
+	code := len(dst)
+	dst = append(dst, 1)
+
+	for i, b := range data {
+		if b == kDelimiter {
+			code = len(dst)
+			dst = append(dst, 1)
+			continue
+		}
+
+		dst = append(dst, b)
+		dst[code]++
+
+		// A full block ends without standing for a zero, so data that ends
+		// with one needs no further block.
+		if dst[code] == kMaxBlock && i+1 < len(data) {
+			code = len(dst)
+			dst = append(dst, 1)
+		}
+	}
+
+	return dst
+}
+
+// Decode appends the data of the COBS encoding in src to dst. It fails with
+// ErrDataLoss if src has a zero byte or ends within a block.
+func Decode(dst []byte, src []byte) ([]byte, error) {
+	for i := 0; i < len(src); {
+		code := src[i]
+		if code == kDelimiter {
+			return dst, &DecodeError{Err: ErrDataLoss, Reason: ReasonZeroByte, Data: dst}
+		}
+		i++
+
+		end := i + int(code) - 1
+		if end > len(src) {
+			return dst, &DecodeError{Err: ErrDataLoss, Reason: ReasonTruncated, Data: dst}
+		}
+
+		for _, b := range src[i:end] {
+			if b == kDelimiter {
+				return dst, &DecodeError{Err: ErrDataLoss, Reason: ReasonZeroByte, Data: dst}
+			}
+		}
+		dst = append(dst, src[i:end]...)
+		i = end
+
+		if code != kMaxBlock && i < len(src) {
+			dst = append(dst, kDelimiter)
+		}
+	}
+
+	return dst, nil
+}
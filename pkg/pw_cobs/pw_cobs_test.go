@@ -0,0 +1,216 @@
+package pw_cobs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+func sequence(from int, to int) []byte {
+	var data []byte
+	for b := from; b <= to; b++ {
+		data = append(data, byte(b))
+	}
+
+	return data
+}
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// kGoldenEncodings are the examples of the COBS paper, also used by
+// Pigweed's pw_cobs tests.
+var kGoldenEncodings = []struct {
+	name    string
+	data    []byte
+	encoded []byte
+}{
+	{"empty", []byte{}, []byte{0x01}},
+	{"zero", []byte{0x00}, []byte{0x01, 0x01}},
+	{"two zeros", []byte{0x00, 0x00}, []byte{0x01, 0x01, 0x01}},
+	{"zero inside", []byte{0x11, 0x22, 0x00, 0x33}, []byte{0x03, 0x11, 0x22, 0x02, 0x33}},
+	{"no zeros", []byte{0x11, 0x22, 0x33, 0x44}, []byte{0x05, 0x11, 0x22, 0x33, 0x44}},
+	{"zero last", []byte{0x11, 0x00, 0x00, 0x00}, []byte{0x02, 0x11, 0x01, 0x01, 0x01}},
+	{"full block", sequence(0x01, 0xfe), join([]byte{0xff}, sequence(0x01, 0xfe))},
+	{"zero then full block", sequence(0x00, 0xfe), join([]byte{0x01, 0xff}, sequence(0x01, 0xfe))},
+	{"full block then one", sequence(0x01, 0xff), join([]byte{0xff}, sequence(0x01, 0xfe), []byte{0x02, 0xff})},
+	{"full block then zero", join(sequence(0x02, 0xff), []byte{0x00}), join([]byte{0xff}, sequence(0x02, 0xff), []byte{0x01, 0x01})},
+	{"block before zero", join(sequence(0x03, 0xff), []byte{0x00, 0x01}), join([]byte{0xfe}, sequence(0x03, 0xff), []byte{0x02, 0x01})},
+}
+
+func TestGoldenEncodings(t *testing.T) {
+	for _, golden := range kGoldenEncodings {
+		if encoded := Encode(nil, golden.data); !bytes.Equal(encoded, golden.encoded) {
+			t.Errorf("%s: Encode() = %x, want %x", golden.name, encoded, golden.encoded)
+		}
+
+		if len(golden.encoded) > MaxEncodedSize(len(golden.data)) {
+			t.Errorf("%s: %d encoded bytes, more than MaxEncodedSize(%d) = %d", golden.name, len(golden.encoded), len(golden.data), MaxEncodedSize(len(golden.data)))
+		}
+
+		data, err := Decode(nil, golden.encoded)
+		if err != nil || !bytes.Equal(data, golden.data) {
+			t.Errorf("%s: Decode() = %x, %v, want %x", golden.name, data, err, golden.data)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, bad := range [][]byte{
+		{0x03, 0x11},
+		{0x02, 0x00},
+		{0x00},
+		{0xff, 0x01},
+	} {
+		if _, err := Decode(nil, bad); !errors.Is(err, ErrDataLoss) {
+			t.Errorf("Decode(%x) = %v, want %v", bad, err, ErrDataLoss)
+		}
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	// The CRC catalogue check values of "123456789".
+	check := []byte("123456789")
+
+	tests := []struct {
+		checksum Checksum
+		want     []byte
+	}{
+		{ChecksumNone, nil},
+		{Crc16, []byte{0xb1, 0x29}},
+		{Crc32, []byte{0x26, 0x39, 0xf4, 0xcb}},
+	}
+
+	for _, test := range tests {
+		got := test.checksum.Append(nil, check)
+		if !bytes.Equal(got, test.want) || len(got) != test.checksum.Size() {
+			t.Errorf("%s: Append() = %x, want %x", test.checksum, got, test.want)
+		}
+	}
+}
+
+func encode(t testing.TB, checksum Checksum, payload []byte) []byte {
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf, checksum).Encode(payload); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// decodeAll decodes every frame in data. Only data loss errors are allowed.
+func decodeAll(t testing.TB, checksum Checksum, data []byte) [][]byte {
+	var frames [][]byte
+
+	decoder := NewDecoder(bytes.NewReader(data), checksum)
+	for i := 0; i <= len(data); i++ {
+		frame, err := decoder.Decode(context.Background())
+		if err == io.EOF {
+			return frames
+		} else if errors.Is(err, ErrDataLoss) {
+			continue
+		} else if err != nil {
+			t.Fatalf("Decode(%x): %s", data, err)
+		}
+
+		frames = append(frames, frame)
+	}
+
+	t.Fatalf("Decode(%x) did not reach the end of the data", data)
+	return nil
+}
+
+func TestFrames(t *testing.T) {
+	for _, checksum := range []Checksum{ChecksumNone, Crc16, Crc32} {
+		var stream []byte
+		for _, golden := range kGoldenEncodings {
+			stream = append(stream, encode(t, checksum, golden.data)...)
+		}
+
+		// Noise before the first frame is dropped at its delimiter.
+		frames := decodeAll(t, checksum, append([]byte{0x05, 0x11}, stream...))
+		if len(frames) != len(kGoldenEncodings) {
+			t.Fatalf("%s: decoded %d frames, want %d", checksum, len(frames), len(kGoldenEncodings))
+		}
+		for i, golden := range kGoldenEncodings {
+			if !bytes.Equal(frames[i], golden.data) {
+				t.Errorf("%s: %s: decoded %x, want %x", checksum, golden.name, frames[i], golden.data)
+			}
+		}
+	}
+}
+
+func TestCorruptedFrames(t *testing.T) {
+	payload := []byte{0x11, 0x22, 0x00, 0x33}
+
+	for _, checksum := range []Checksum{Crc16, Crc32} {
+		for i := 1; i < len(encode(t, checksum, payload))-1; i++ {
+			corrupted := encode(t, checksum, payload)
+			corrupted[i] ^= 0x40
+
+			if frames := decodeAll(t, checksum, corrupted); len(frames) != 0 {
+				t.Errorf("%s: decoded %x with byte %d corrupted", checksum, frames, i)
+			}
+		}
+	}
+
+	// A frame shorter than its checksum.
+	var err *DecodeError
+	_, decodeErr := NewDecoder(bytes.NewReader([]byte{0x00, 0x02, 0x11, 0x00}), Crc32).Decode(context.Background())
+	if !errors.As(decodeErr, &err) || err.Reason != ReasonTooShort {
+		t.Errorf("Decode() = %v, want %s", decodeErr, ReasonTooShort)
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add([]byte{}, uint8(Crc32))
+	f.Add([]byte{kDelimiter, kDelimiter}, uint8(ChecksumNone))
+	f.Add([]byte{0x05, 0x11, kDelimiter, 0xff, 0x01}, uint8(Crc16))
+	f.Add(encode(f, Crc32, []byte("hello")), uint8(Crc32))
+	f.Add(encode(f, Crc16, sequence(0x00, 0xff)), uint8(Crc16))
+
+	f.Fuzz(func(t *testing.T, data []byte, c uint8) {
+		checksum := Checksum(c % 3)
+
+		for _, frame := range decodeAll(t, checksum, data) {
+			// A frame that was decoded survives a round trip unchanged.
+			again := decodeAll(t, checksum, encode(t, checksum, frame))
+			if len(again) != 1 || !bytes.Equal(again[0], frame) {
+				t.Fatalf("frame %x did not survive a round trip", frame)
+			}
+		}
+
+		// The block decoder never fails by panicking.
+		Decode(nil, data)
+	})
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	for _, golden := range kGoldenEncodings {
+		f.Add(golden.data, uint8(Crc32))
+	}
+	f.Add(bytes.Repeat([]byte{0x00}, 300), uint8(ChecksumNone))
+	f.Add(bytes.Repeat([]byte{0xaa}, 600), uint8(Crc16))
+
+	f.Fuzz(func(t *testing.T, payload []byte, c uint8) {
+		checksum := Checksum(c % 3)
+
+		encoded := Encode(nil, payload)
+		if bytes.IndexByte(encoded, kDelimiter) >= 0 || len(encoded) > MaxEncodedSize(len(payload)) {
+			t.Fatalf("Encode(%x) = %x", payload, encoded)
+		}
+
+		decoded, err := Decode(nil, encoded)
+		if err != nil || !bytes.Equal(decoded, payload) {
+			t.Fatalf("Decode(Encode(%x)) = %x, %v", payload, decoded, err)
+		}
+
+		frames := decodeAll(t, checksum, encode(t, checksum, payload))
+		if len(frames) != 1 || !bytes.Equal(frames[0], payload) {
+			t.Fatalf("decoded %x, want %x", frames, payload)
+		}
+	})
+}
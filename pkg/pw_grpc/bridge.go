@@ -0,0 +1,152 @@
+package pw_grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// Bridge serves pw_rpc calls made by a device by forwarding them to a gRPC
+// backend. Each bridged service is registered on a pw_rpc.Server with
+// generic handlers that relay the requests, responses and status.
+type Bridge interface {
+	// Register adds forwarders for the named services to server. With no
+	// names, every service in the bridge's descriptors is registered.
+	Register(server pw_rpc.Server, services ...protoreflect.FullName) error
+}
+
+type bridge struct {
+	cc    grpc.ClientConnInterface
+	files *protoregistry.Files
+}
+
+// NewBridge creates a bridge to the gRPC backend behind cc. The services are
+// resolved from files; if files is nil the descriptors linked into the
+// binary are used.
+func NewBridge(cc grpc.ClientConnInterface, files *protoregistry.Files) Bridge {
+	if files == nil {
+		files = protoregistry.GlobalFiles
+	}
+
+	return &bridge{
+		cc:    cc,
+		files: files,
+	}
+}
+
+func (b *bridge) Register(server pw_rpc.Server, services ...protoreflect.FullName) error {
+	if len(services) == 0 {
+		b.files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+			for i := 0; i < fd.Services().Len(); i++ {
+				services = append(services, fd.Services().Get(i).FullName())
+			}
+			return true
+		})
+	}
+
+	for _, name := range services {
+		d, err := b.files.FindDescriptorByName(name)
+		if err != nil {
+			return fmt.Errorf("service not found: %s", name)
+		}
+
+		sd, ok := d.(protoreflect.ServiceDescriptor)
+		if !ok {
+			return fmt.Errorf("not a service: %s", name)
+		}
+
+		if err := server.Register(b.serviceDesc(sd), b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// serviceDesc builds a grpc.ServiceDesc whose handlers forward to the backend.
+func (b *bridge) serviceDesc(sd protoreflect.ServiceDescriptor) *grpc.ServiceDesc {
+	desc := &grpc.ServiceDesc{
+		ServiceName: string(sd.FullName()),
+		HandlerType: (*any)(nil),
+		Metadata:    sd.ParentFile().Path(),
+	}
+
+	for i := 0; i < sd.Methods().Len(); i++ {
+		md := sd.Methods().Get(i)
+		method := fmt.Sprintf("/%s/%s", sd.FullName(), md.Name())
+
+		if !md.IsStreamingClient() && !md.IsStreamingServer() {
+			desc.Methods = append(desc.Methods, grpc.MethodDesc{
+				MethodName: string(md.Name()),
+				Handler:    b.unaryHandler(method),
+			})
+			continue
+		}
+
+		stream := grpc.StreamDesc{
+			StreamName:    string(md.Name()),
+			ServerStreams: md.IsStreamingServer(),
+			ClientStreams: md.IsStreamingClient(),
+		}
+		stream.Handler = b.streamHandler(method, &stream)
+
+		desc.Streams = append(desc.Streams, stream)
+	}
+
+	return desc
+}
+
+func (b *bridge) unaryHandler(method string) func(any, context.Context, func(any) error, grpc.UnaryServerInterceptor) (any, error) {
+	return func(srv any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+		var request, response pw_rpc.RawMessage
+
+		if err := dec(&request); err != nil {
+			return nil, err
+		}
+
+		err := b.cc.Invoke(ctx, method, &request, &response, kRawCallOptions...)
+		if err != nil {
+			return nil, err
+		}
+
+		return &response, nil
+	}
+}
+
+func (b *bridge) streamHandler(method string, desc *grpc.StreamDesc) grpc.StreamHandler {
+	return func(srv any, ss grpc.ServerStream) error {
+		ctx, cancel := context.WithCancel(ss.Context())
+		defer cancel()
+
+		cs, err := b.cc.NewStream(ctx, desc, method, kRawCallOptions...)
+		if err != nil {
+			return err
+		}
+
+		// A request the backend rejects ends the call with its error,
+		// rather than waiting for a response that will not come.
+		return relay(ss, cs, desc.ClientStreams, cancel, func() error {
+			for {
+				var response pw_rpc.RawMessage
+
+				err := cs.RecvMsg(&response)
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+
+				if err := ss.SendMsg(&response); err != nil {
+					return err
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package pw_grpc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_grpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpctest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// bridgedClient connects a pw_rpc client to a server that bridges the relay
+// service to a gRPC backend, through wrap if it is not nil.
+func bridgedClient(t *testing.T, ctx context.Context, wrap func(grpc.ClientConnInterface) grpc.ClientConnInterface) pw_rpc.Client {
+	t.Helper()
+
+	backend := grpc.NewServer(grpc.ForceServerCodecV2(pw_grpc.RawCodec))
+	backend.RegisterService(&kRelayServiceDesc, struct{}{})
+
+	var cc grpc.ClientConnInterface = serve(t, backend)
+	if wrap != nil {
+		cc = wrap(cc)
+	}
+
+	device := pw_rpc.NewServer("")
+	if err := pw_grpc.NewBridge(cc, kRelayFiles).Register(device, kRelayService); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := pw_rpctest.NewServerClient(ctx, device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	return client
+}
+
+// rejectingConn opens streams that fail to send kReject but stay open, as a
+// misbehaving backend or interceptor can.
+type rejectingConn struct {
+	grpc.ClientConnInterface
+}
+
+const kReject = "reject"
+
+func (c rejectingConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	cs, err := c.ClientConnInterface.NewStream(ctx, desc, method, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return rejectingStream{cs}, nil
+}
+
+type rejectingStream struct {
+	grpc.ClientStream
+}
+
+func (s rejectingStream) SendMsg(m any) error {
+	if request, ok := m.(*pw_rpc.RawMessage); ok && string(*request) == kReject {
+		return status.Error(codes.InvalidArgument, "rejected")
+	}
+
+	return s.ClientStream.SendMsg(m)
+}
+
+func TestBridge(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	testRelay(t, ctx, bridgedClient(t, ctx, nil))
+}
+
+func TestBridgeRegisterErrors(t *testing.T) {
+	bridge := pw_grpc.NewBridge(nil, kRelayFiles)
+	server := pw_rpc.NewServer("")
+
+	if err := bridge.Register(server); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bridge.Register(server, kRelayService); !errors.Is(err, pw_rpc.ErrDuplicateService) {
+		t.Errorf("second Register = %v, want %v", err, pw_rpc.ErrDuplicateService)
+	}
+
+	if err := bridge.Register(server, "pw.test.Unknown"); err == nil {
+		t.Error("Register of an unknown service succeeded")
+	}
+}
+
+func TestBridgeForwardingFails(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client := bridgedClient(t, ctx, func(cc grpc.ClientConnInterface) grpc.ClientConnInterface {
+		return rejectingConn{cc}
+	})
+
+	// The backend is still waiting for requests, so only the failure to
+	// relay one can end the call.
+	stream := newStream(t, ctx, client, "ClientStream", true, false, "a", kReject)
+	wantCode(t, "ClientStream", stream.RecvMsg(&pw_rpc.RawMessage{}), codes.InvalidArgument)
+}
@@ -0,0 +1,57 @@
+package pw_grpc
+
+import (
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/mem"
+)
+
+const (
+	kRawCodecName = "pwrpc-raw"
+)
+
+// RawCodec passes the encoded protobuf messages in a pw_rpc.RawMessage
+// through gRPC untouched, and any other message to the proto codec. The
+// gateway and bridge force it on their own calls only; force it with
+// grpc.ForceCodecV2 or grpc.ForceServerCodecV2 to relay messages without
+// their Go types elsewhere.
+var RawCodec encoding.CodecV2 = rawCodec{fallback: encoding.GetCodecV2(proto.Name)}
+
+// kRawCallOptions make a call with RawCodec, but tell the peer that its
+// messages are plain protobuf.
+var kRawCallOptions = []grpc.CallOption{
+	grpc.ForceCodecV2(RawCodec),
+	grpc.CallContentSubtype(proto.Name),
+}
+
+type rawCodec struct {
+	fallback encoding.CodecV2
+}
+
+func (c rawCodec) Marshal(v any) (mem.BufferSlice, error) {
+	switch m := v.(type) {
+	case pw_rpc.RawMessage:
+		return mem.BufferSlice{mem.SliceBuffer(m)}, nil
+	case *pw_rpc.RawMessage:
+		return mem.BufferSlice{mem.SliceBuffer(*m)}, nil
+	}
+
+	return c.fallback.Marshal(v)
+}
+
+func (c rawCodec) Unmarshal(data mem.BufferSlice, v any) error {
+	m, ok := v.(*pw_rpc.RawMessage)
+	if !ok {
+		return c.fallback.Unmarshal(data, v)
+	}
+
+	*m = data.Materialize()
+
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return kRawCodecName
+}
@@ -0,0 +1,206 @@
+package pw_grpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// Gateway serves gRPC calls by forwarding them to a pw_rpc device. It runs
+// as the unknown service handler of a grpc.Server, so any method described
+// by its descriptors is relayed, along with the call's status.
+type Gateway interface {
+	// ServerOptions returns the options that route a grpc.Server's calls
+	// through the gateway.
+	ServerOptions() []grpc.ServerOption
+	// NewServer creates a grpc.Server that forwards every call.
+	NewServer(opts ...grpc.ServerOption) *grpc.Server
+}
+
+type gateway struct {
+	client pw_rpc.Client
+	files  *protoregistry.Files
+}
+
+// NewGateway creates a gateway to the device behind client. The descriptors
+// in files tell the gateway which calls stream; if files is nil the
+// descriptors linked into the binary are used.
+func NewGateway(client pw_rpc.Client, files *protoregistry.Files) Gateway {
+	if files == nil {
+		files = protoregistry.GlobalFiles
+	}
+
+	return &gateway{
+		client: client,
+		files:  files,
+	}
+}
+
+func (g *gateway) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ForceServerCodecV2(RawCodec),
+		grpc.UnknownServiceHandler(g.handle),
+	}
+}
+
+func (g *gateway) NewServer(opts ...grpc.ServerOption) *grpc.Server {
+	return grpc.NewServer(append(g.ServerOptions(), opts...)...)
+}
+
+func (g *gateway) findMethod(method string) (protoreflect.MethodDescriptor, error) {
+	name := strings.Replace(strings.TrimPrefix(method, "/"), "/", ".", 1)
+
+	d, err := g.files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, status.Errorf(codes.Unimplemented, "unknown method %s", method)
+	}
+
+	md, ok := d.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, status.Errorf(codes.Unimplemented, "unknown method %s", method)
+	}
+
+	return md, nil
+}
+
+func (g *gateway) handle(srv any, ss grpc.ServerStream) error {
+	method, ok := grpc.MethodFromServerStream(ss)
+	if !ok {
+		return status.Error(codes.Internal, "no method in stream")
+	}
+
+	md, err := g.findMethod(method)
+	if err != nil {
+		return err
+	}
+
+	if !md.IsStreamingClient() && !md.IsStreamingServer() {
+		return g.unary(ss, method)
+	}
+
+	return g.stream(ss, method, md)
+}
+
+func (g *gateway) unary(ss grpc.ServerStream, method string) error {
+	var request, response pw_rpc.RawMessage
+
+	if err := ss.RecvMsg(&request); err != nil {
+		return err
+	}
+
+	if err := g.client.Invoke(ss.Context(), method, &request, &response); err != nil {
+		return err
+	}
+
+	return ss.SendMsg(&response)
+}
+
+func (g *gateway) stream(ss grpc.ServerStream, method string, md protoreflect.MethodDescriptor) error {
+	ctx, cancel := context.WithCancel(ss.Context())
+	defer cancel()
+
+	desc := &grpc.StreamDesc{
+		StreamName:    string(md.Name()),
+		ServerStreams: md.IsStreamingServer(),
+		ClientStreams: md.IsStreamingClient(),
+	}
+
+	cs, err := g.client.NewStream(ctx, desc, method)
+	if err != nil {
+		return err
+	}
+
+	return relay(ss, cs, md.IsStreamingClient(), cancel, func() error {
+		for {
+			var response pw_rpc.RawMessage
+
+			err := cs.RecvMsg(&response)
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					// The gRPC caller went away; cancel the call on the device.
+					cs.SendMsg(nil)
+				}
+				return err
+			}
+
+			if err := ss.SendMsg(&response); err != nil {
+				cs.SendMsg(nil)
+				return err
+			}
+
+			// The device may answer before the caller half-closes, so its
+			// response ends the call rather than the end of the requests.
+			if !md.IsStreamingServer() {
+				return nil
+			}
+		}
+	})
+}
+
+// relay runs responses, the loop that relays a call's responses, while
+// forwardRequests relays its requests. The call ends with responses, or
+// when relaying a request fails, which cancels the call and returns that
+// error.
+func relay(ss grpc.ServerStream, cs grpc.ClientStream, clientStreams bool, cancel context.CancelFunc, responses func() error) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- forwardRequests(ss, cs, clientStreams)
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- responses()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case err := <-errs:
+		// SendMsg returns io.EOF once the call has ended; its status is
+		// left to responses.
+		if err != nil && !errors.Is(err, io.EOF) {
+			cancel()
+			<-done
+			return err
+		}
+	}
+
+	return <-done
+}
+
+// forwardRequests relays the gRPC caller's requests to the device, then
+// half-closes the pw_rpc call.
+func forwardRequests(ss grpc.ServerStream, cs grpc.ClientStream, clientStreams bool) error {
+	for {
+		var request pw_rpc.RawMessage
+
+		err := ss.RecvMsg(&request)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := cs.SendMsg(&request); err != nil {
+			return err
+		}
+
+		if !clientStreams {
+			break
+		}
+	}
+
+	return cs.CloseSend()
+}
@@ -0,0 +1,395 @@
+package pw_grpc_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_grpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpctest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	grpcproto "google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	kRelayService = "pw.test.Relay"
+	// kFail makes a call of the relay service fail with codes.NotFound, and
+	// kEarly makes a client stream answer before the caller half-closes.
+	kFail  = "fail"
+	kEarly = "early"
+)
+
+var errFail = status.Error(codes.NotFound, "failed on request")
+
+// kRelayFiles describes the relay service, which has a method of each kind.
+var kRelayFiles = relayFiles()
+
+func relayFiles() *protoregistry.Files {
+	method := func(name string, clientStreams bool, serverStreams bool) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{
+			Name:            proto.String(name),
+			InputType:       proto.String(".pw.test.Payload"),
+			OutputType:      proto.String(".pw.test.Payload"),
+			ClientStreaming: proto.Bool(clientStreams),
+			ServerStreaming: proto.Bool(serverStreams),
+		}
+	}
+
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:        proto.String("relay_test.proto"),
+		Package:     proto.String("pw.test"),
+		Syntax:      proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String("Payload")}},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Relay"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				method("Unary", false, false),
+				method("ServerStream", false, true),
+				method("ClientStream", true, false),
+				method("BidiStream", true, true),
+			},
+		}},
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	files := &protoregistry.Files{}
+	if err := files.RegisterFile(fd); err != nil {
+		panic(err)
+	}
+
+	return files
+}
+
+// kRelayServiceDesc implements the relay service with raw messages, so that
+// it can be served by both pw_rpc and gRPC servers.
+var kRelayServiceDesc = grpc.ServiceDesc{
+	ServiceName: kRelayService,
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Unary", Handler: relayUnary},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "ServerStream", Handler: relayServerStream, ServerStreams: true},
+		{StreamName: "ClientStream", Handler: relayClientStream, ClientStreams: true},
+		{StreamName: "BidiStream", Handler: relayBidiStream, ServerStreams: true, ClientStreams: true},
+	},
+}
+
+func relayUnary(srv any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+	request := pw_rpc.RawMessage{}
+	if err := dec(&request); err != nil {
+		return nil, err
+	}
+
+	if string(request) == kFail {
+		return nil, errFail
+	}
+
+	return &request, nil
+}
+
+// relayServerStream sends the request three times, numbered.
+func relayServerStream(srv any, stream grpc.ServerStream) error {
+	request := pw_rpc.RawMessage{}
+	if err := stream.RecvMsg(&request); err != nil {
+		return err
+	}
+
+	for i := 0; i < 3; i++ {
+		response := pw_rpc.RawMessage(fmt.Sprintf("%s %d", request, i))
+		if err := stream.SendMsg(&response); err != nil {
+			return err
+		}
+
+		if string(request) == kFail {
+			return errFail
+		}
+	}
+
+	return nil
+}
+
+// relayClientStream answers with the concatenated requests.
+func relayClientStream(srv any, stream grpc.ServerStream) error {
+	var all []byte
+
+	for {
+		request := pw_rpc.RawMessage{}
+		err := stream.RecvMsg(&request)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		switch string(request) {
+		case kFail:
+			return errFail
+		case kEarly:
+			return stream.SendMsg(&request)
+		}
+
+		all = append(all, request...)
+	}
+
+	response := pw_rpc.RawMessage(all)
+
+	return stream.SendMsg(&response)
+}
+
+func relayBidiStream(srv any, stream grpc.ServerStream) error {
+	for {
+		request := pw_rpc.RawMessage{}
+		err := stream.RecvMsg(&request)
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if string(request) == kFail {
+			return errFail
+		}
+
+		if err := stream.SendMsg(&request); err != nil {
+			return err
+		}
+	}
+}
+
+// kRawDialOption lets a test client send and receive pw_rpc.RawMessage.
+var kRawDialOption = grpc.WithDefaultCallOptions(grpc.ForceCodecV2(pw_grpc.RawCodec))
+
+// serve runs server on an in-memory listener and returns a connection to it.
+func serve(t *testing.T, server *grpc.Server, opts ...grpc.DialOption) *grpc.ClientConn {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	opts = append(opts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+
+	cc, err := grpc.NewClient("passthrough:///bufconn", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+
+	return cc
+}
+
+// relayMethod is the full name of a method of the relay service.
+func relayMethod(name string) string {
+	return "/" + kRelayService + "/" + name
+}
+
+func wantCode(t *testing.T, call string, err error, code codes.Code) {
+	t.Helper()
+
+	if status.Code(err) != code {
+		t.Errorf("%s: %v, want %s", call, err, code)
+	}
+}
+
+// recvAll receives the responses of stream until it ends.
+func recvAll(stream grpc.ClientStream) ([]string, error) {
+	var responses []string
+
+	for {
+		response := pw_rpc.RawMessage{}
+		if err := stream.RecvMsg(&response); errors.Is(err, io.EOF) {
+			return responses, nil
+		} else if err != nil {
+			return responses, err
+		}
+
+		responses = append(responses, string(response))
+	}
+}
+
+func newStream(t *testing.T, ctx context.Context, cc grpc.ClientConnInterface, name string, clientStreams bool, serverStreams bool, requests ...string) grpc.ClientStream {
+	t.Helper()
+
+	desc := &grpc.StreamDesc{StreamName: name, ClientStreams: clientStreams, ServerStreams: serverStreams}
+
+	stream, err := cc.NewStream(ctx, desc, relayMethod(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, request := range requests {
+		if err := stream.SendMsg(pw_rpc.RawMessage(request)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return stream
+}
+
+// testRelay makes each kind of call of the relay service through cc, with
+// and without a failing status.
+func testRelay(t *testing.T, ctx context.Context, cc grpc.ClientConnInterface) {
+	t.Run("Unary", func(t *testing.T) {
+		response := pw_rpc.RawMessage{}
+		if err := cc.Invoke(ctx, relayMethod("Unary"), pw_rpc.RawMessage("ping"), &response); err != nil || string(response) != "ping" {
+			t.Errorf("Unary = %q, %v", response, err)
+		}
+
+		err := cc.Invoke(ctx, relayMethod("Unary"), pw_rpc.RawMessage(kFail), &response)
+		wantCode(t, "Unary", err, codes.NotFound)
+	})
+
+	t.Run("ServerStream", func(t *testing.T) {
+		stream := newStream(t, ctx, cc, "ServerStream", false, true, "ping")
+		stream.CloseSend()
+		responses, err := recvAll(stream)
+		if err != nil || strings.Join(responses, ",") != "ping 0,ping 1,ping 2" {
+			t.Errorf("ServerStream = %q, %v", responses, err)
+		}
+
+		stream = newStream(t, ctx, cc, "ServerStream", false, true, kFail)
+		stream.CloseSend()
+		responses, err = recvAll(stream)
+		if len(responses) != 1 {
+			t.Errorf("ServerStream(%s) = %q", kFail, responses)
+		}
+		wantCode(t, "ServerStream", err, codes.NotFound)
+	})
+
+	t.Run("ClientStream", func(t *testing.T) {
+		// The single response is received once, as by CloseAndRecv.
+		stream := newStream(t, ctx, cc, "ClientStream", true, false, "a", "b", "c")
+		stream.CloseSend()
+		response := pw_rpc.RawMessage{}
+		if err := stream.RecvMsg(&response); err != nil || string(response) != "abc" {
+			t.Errorf("ClientStream = %q, %v", response, err)
+		}
+
+		// These calls are answered before the requests end.
+		stream = newStream(t, ctx, cc, "ClientStream", true, false, kEarly)
+		if err := stream.RecvMsg(&response); err != nil || string(response) != kEarly {
+			t.Errorf("ClientStream(%s) = %q, %v", kEarly, response, err)
+		}
+
+		stream = newStream(t, ctx, cc, "ClientStream", true, false, "a", kFail)
+		wantCode(t, "ClientStream", stream.RecvMsg(&response), codes.NotFound)
+	})
+
+	t.Run("BidiStream", func(t *testing.T) {
+		stream := newStream(t, ctx, cc, "BidiStream", true, true)
+		for _, request := range []string{"x", "y"} {
+			if err := stream.SendMsg(pw_rpc.RawMessage(request)); err != nil {
+				t.Fatal(err)
+			}
+			response := pw_rpc.RawMessage{}
+			if err := stream.RecvMsg(&response); err != nil || string(response) != request {
+				t.Errorf("BidiStream(%s) = %q, %v", request, response, err)
+			}
+		}
+		stream.CloseSend()
+		if responses, err := recvAll(stream); err != nil || len(responses) != 0 {
+			t.Errorf("BidiStream after CloseSend = %q, %v", responses, err)
+		}
+
+		stream = newStream(t, ctx, cc, "BidiStream", true, true, kFail)
+		_, err := recvAll(stream)
+		wantCode(t, "BidiStream", err, codes.NotFound)
+	})
+}
+
+// gatewayConn connects to a gateway to a pw_rpc device that serves the relay
+// service.
+func gatewayConn(t *testing.T, ctx context.Context) (*grpc.ClientConn, *grpc.Server) {
+	t.Helper()
+
+	device := pw_rpc.NewServer("")
+	if err := device.Register(&kRelayServiceDesc, struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := pw_rpctest.NewServerClient(ctx, device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	server := pw_grpc.NewGateway(client, kRelayFiles).NewServer()
+
+	return serve(t, server, kRawDialOption), server
+}
+
+func TestGateway(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cc, _ := gatewayConn(t, ctx)
+
+	testRelay(t, ctx, cc)
+
+	err := cc.Invoke(ctx, "/pw.test.Unknown/Method", pw_rpc.RawMessage("ping"), &pw_rpc.RawMessage{})
+	wantCode(t, "unknown method", err, codes.Unimplemented)
+}
+
+func TestGatewayWithOtherServices(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	device := pw_rpc.NewServer("")
+	if err := device.Register(&kRelayServiceDesc, struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := pw_rpctest.NewServerClient(ctx, device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// A service with generated messages works alongside the gateway.
+	server := pw_grpc.NewGateway(client, kRelayFiles).NewServer()
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	cc := serve(t, server, kRawDialOption)
+
+	res, err := healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil || res.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Check = %v, %v", res, err)
+	}
+
+	response := pw_rpc.RawMessage{}
+	if err := cc.Invoke(ctx, relayMethod("Unary"), pw_rpc.RawMessage("ping"), &response); err != nil || string(response) != "ping" {
+		t.Errorf("Unary = %q, %v", response, err)
+	}
+}
+
+func TestProtoCodecUntouched(t *testing.T) {
+	// Importing pw_grpc leaves the proto codec of other servers and clients
+	// as it is.
+	if _, err := encoding.GetCodecV2(grpcproto.Name).Marshal(pw_rpc.RawMessage("ping")); err == nil {
+		t.Error("the proto codec marshals pw_rpc.RawMessage")
+	}
+}
@@ -0,0 +1,213 @@
+package pw_hdlc
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// kSequenceModulus is the modulus of the 3 bit sequence numbers of
+	// basic (modulo 8) HDLC.
+	kSequenceModulus = 8
+
+	kPollFinalBit = byte(0x10)
+
+	kInformationMask    = byte(0x01)
+	kInformationPattern = byte(0x00)
+	kFrameTypeMask      = byte(0x03)
+	kSupervisoryPattern = byte(0x01)
+	kUnnumberedPattern  = byte(0x03)
+
+	kSendSequenceShift    = 1
+	kReceiveSequenceShift = 5
+	kSupervisoryShift     = 2
+)
+
+// FrameType is the kind of frame a control field starts.
+type FrameType int
+
+const (
+	// InformationFrame (I-frame) carries sequenced data.
+	InformationFrame FrameType = iota
+	// SupervisoryFrame (S-frame) acknowledges I-frames and controls their
+	// flow.
+	SupervisoryFrame
+	// UnnumberedFrame (U-frame) manages the link, or carries unsequenced
+	// data in UI frames as Pigweed devices send.
+	UnnumberedFrame
+)
+
+func (t FrameType) String() string {
+	switch t {
+	case InformationFrame:
+		return "I"
+	case SupervisoryFrame:
+		return "S"
+	case UnnumberedFrame:
+		return "U"
+	}
+
+	return fmt.Sprintf("FrameType(%d)", int(t))
+}
+
+// SupervisoryFunction is the function of an S-frame: bits 2 and 3 of its
+// control field.
+type SupervisoryFunction byte
+
+const (
+	ReceiveReady SupervisoryFunction = iota
+	ReceiveNotReady
+	Reject
+	SelectiveReject
+)
+
+func (f SupervisoryFunction) String() string {
+	switch f {
+	case ReceiveReady:
+		return "RR"
+	case ReceiveNotReady:
+		return "RNR"
+	case Reject:
+		return "REJ"
+	case SelectiveReject:
+		return "SREJ"
+	}
+
+	return fmt.Sprintf("SupervisoryFunction(%d)", byte(f))
+}
+
+// UnnumberedFunction is the function of a U-frame: its control field
+// without the poll/final bit.
+type UnnumberedFunction byte
+
+const (
+	UnnumberedInformation     UnnumberedFunction = 0x03
+	SetNormalResponseMode     UnnumberedFunction = 0x83
+	SetAsyncBalancedMode      UnnumberedFunction = 0x2F
+	Disconnect                UnnumberedFunction = 0x43
+	UnnumberedAcknowledgement UnnumberedFunction = 0x63
+	DisconnectedMode          UnnumberedFunction = 0x0F
+	FrameReject               UnnumberedFunction = 0x87
+	ExchangeIdentification    UnnumberedFunction = 0xAF
+	Test                      UnnumberedFunction = 0xE3
+)
+
+func (f UnnumberedFunction) String() string {
+	switch f {
+	case UnnumberedInformation:
+		return "UI"
+	case SetNormalResponseMode:
+		return "SNRM"
+	case SetAsyncBalancedMode:
+		return "SABM"
+	case Disconnect:
+		return "DISC"
+	case UnnumberedAcknowledgement:
+		return "UA"
+	case DisconnectedMode:
+		return "DM"
+	case FrameReject:
+		return "FRMR"
+	case ExchangeIdentification:
+		return "XID"
+	case Test:
+		return "TEST"
+	}
+
+	return fmt.Sprintf("UnnumberedFunction(0x%02x)", byte(f))
+}
+
+// Control is the control field of a frame, which holds its type, sequence
+// numbers and poll/final bit.
+type Control byte
+
+// InformationControl is the control field of an I-frame with send sequence
+// number ns that acknowledges the frames before nr. Sequence numbers are
+// taken modulo 8.
+func InformationControl(ns uint8, nr uint8, poll bool) Control {
+	c := kInformationPattern | (ns%kSequenceModulus)<<kSendSequenceShift | (nr%kSequenceModulus)<<kReceiveSequenceShift
+	if poll {
+		c |= kPollFinalBit
+	}
+
+	return Control(c)
+}
+
+// SupervisoryControl is the control field of an S-frame that acknowledges
+// the frames before nr.
+func SupervisoryControl(function SupervisoryFunction, nr uint8, pollFinal bool) Control {
+	c := kSupervisoryPattern | byte(function&0x03)<<kSupervisoryShift | (nr%kSequenceModulus)<<kReceiveSequenceShift
+	if pollFinal {
+		c |= kPollFinalBit
+	}
+
+	return Control(c)
+}
+
+// UnnumberedControl is the control field of a U-frame.
+func UnnumberedControl(function UnnumberedFunction, pollFinal bool) Control {
+	c := byte(function)&^kPollFinalBit | kUnnumberedPattern
+	if pollFinal {
+		c |= kPollFinalBit
+	}
+
+	return Control(c)
+}
+
+func (c Control) Type() FrameType {
+	switch {
+	case byte(c)&kInformationMask == kInformationPattern:
+		return InformationFrame
+	case byte(c)&kFrameTypeMask == kSupervisoryPattern:
+		return SupervisoryFrame
+	}
+
+	return UnnumberedFrame
+}
+
+// SendSequence is N(S), the sequence number of an I-frame.
+func (c Control) SendSequence() uint8 {
+	return byte(c) >> kSendSequenceShift % kSequenceModulus
+}
+
+// ReceiveSequence is N(R), the sequence number of the next I-frame the
+// sender of an I- or S-frame expects.
+func (c Control) ReceiveSequence() uint8 {
+	return byte(c) >> kReceiveSequenceShift
+}
+
+// PollFinal is the poll bit of a command or the final bit of a response.
+func (c Control) PollFinal() bool {
+	return byte(c)&kPollFinalBit != 0
+}
+
+// Supervisory is the function of an S-frame.
+func (c Control) Supervisory() SupervisoryFunction {
+	return SupervisoryFunction(byte(c) >> kSupervisoryShift & 0x03)
+}
+
+// Unnumbered is the function of a U-frame.
+func (c Control) Unnumbered() UnnumberedFunction {
+	return UnnumberedFunction(byte(c) &^ kPollFinalBit)
+}
+
+// String describes the control field, e.g. "I ns=2 nr=5 P/F", "RR nr=3" or
+// "UI".
+func (c Control) String() string {
+	var s strings.Builder
+
+	switch c.Type() {
+	case InformationFrame:
+		fmt.Fprintf(&s, "I ns=%d nr=%d", c.SendSequence(), c.ReceiveSequence())
+	case SupervisoryFrame:
+		fmt.Fprintf(&s, "%s nr=%d", c.Supervisory(), c.ReceiveSequence())
+	default:
+		s.WriteString(c.Unnumbered().String())
+	}
+
+	if c.PollFinal() {
+		s.WriteString(" P/F")
+	}
+
+	return s.String()
+}
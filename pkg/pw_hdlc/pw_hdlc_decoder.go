@@ -25,6 +25,7 @@ func NewDecoder(reader io.Reader, address uint64) Decoder {
 		lastReadBytes:     make([]byte, 4),
 		lastReadByteIndex: 0,
 		fcs:               0,
+		interFrameReason:  ReasonDiscarded,
 	}
 }
 
@@ -37,11 +38,13 @@ type decoder struct {
 	lastReadBytes     []byte
 	lastReadByteIndex int
 	fcs               uint32
+	interFrameReason  string
 	mu                sync.Mutex
 }
 
 func (d *decoder) Decode(ctx context.Context) (frame *Frame, err error) {
 	d.mu.Lock()
+	defer d.mu.Unlock()
 
 	buf := make([]byte, 1)
 
@@ -68,8 +71,6 @@ func (d *decoder) Decode(ctx context.Context) (frame *Frame, err error) {
 
 	d.reset()
 
-	d.mu.Unlock()
-
 	return frame, err
 }
 
@@ -94,6 +95,16 @@ func (d *decoder) reset() {
 	d.lastReadByteIndex = 0
 	d.fcs = 0
 	d.buffer = make([]byte, 0)
+	d.interFrameReason = ReasonDiscarded
+}
+
+// dataLoss reports the bytes read since the last frame as lost.
+func (d *decoder) dataLoss(reason string) error {
+	return &DecodeError{
+		Err:    ErrDataLoss,
+		Reason: reason,
+		Data:   d.buffer,
+	}
 }
 
 func (d *decoder) escape(b byte) byte {
@@ -108,12 +119,19 @@ func (d *decoder) process(newByte byte) (frame *Frame, err error) {
 
 			// Report an error if non-flag bytes were read between frames.
 			if d.currentFrameSize != 0 {
-				err = ErrDataLoss
+				err = d.dataLoss(d.interFrameReason)
 				break
 			}
 		} else {
-			// Count bytes to track how many are discarded.
+			// Count bytes to track how many are discarded. They are reported
+			// early if there are too many to keep.
 			d.currentFrameSize += 1
+			d.buffer = append(d.buffer, newByte)
+
+			if len(d.buffer) >= kMaxBufferSize {
+				err = d.dataLoss(d.interFrameReason)
+				break
+			}
 		}
 
 		err = ErrUnavailable // Report error when starting a new frame.
@@ -130,6 +148,13 @@ func (d *decoder) process(newByte byte) (frame *Frame, err error) {
 			break
 		}
 
+		if len(d.buffer) >= kMaxBufferSize {
+			// The rest of the frame is discarded up to the next flag.
+			d.state = kInterFrame
+			err = d.dataLoss(ReasonTooLong)
+			break
+		}
+
 		if newByte == kEscape {
 			d.state = kFrameEscape
 		} else {
@@ -141,7 +166,7 @@ func (d *decoder) process(newByte byte) (frame *Frame, err error) {
 		// The flag character cannot be escaped; return an error.
 		if newByte == kFlag {
 			d.state = kFrame
-			err = ErrDataLoss
+			err = d.dataLoss(ReasonEscapedFlag)
 
 			break
 		}
@@ -150,6 +175,7 @@ func (d *decoder) process(newByte byte) (frame *Frame, err error) {
 			// Two escape characters in a row is illegal -- invalidate this frame.
 			// The frame is reported abandoned when the next flag byte appears.
 			d.state = kInterFrame
+			d.interFrameReason = ReasonBadEscape
 
 			// Count the escape byte so that the inter-frame state detects an error.
 			d.currentFrameSize += 1
@@ -187,11 +213,11 @@ func (d *decoder) checkFrame() error {
 	}
 
 	if d.currentFrameSize < kMinContentSizeBytes {
-		return ErrDataLoss
+		return d.dataLoss(ReasonTooShort)
 	}
 
 	if !d.verifyFrameCheckSequence() {
-		return ErrDataLoss
+		return d.dataLoss(ReasonBadFcs)
 	}
 
 	if d.currentFrameSize > len(d.buffer) {
@@ -10,7 +10,11 @@ import (
 )
 
 type Encoder interface {
+	// Encode writes payload in a UI frame to the encoder's address.
 	Encode(payload []byte) error
+	// EncodeFrame writes a frame of any type, with its own address and
+	// control field.
+	EncodeFrame(frame *Frame) error
 }
 
 func NewEncoder(writer io.Writer, address uint64) Encoder {
@@ -29,15 +33,19 @@ type encoder struct {
 }
 
 func (e *encoder) Encode(payload []byte) error {
+	return e.EncodeFrame(NewFrame(e.address, kUnnumberedUFrame, payload))
+}
+
+func (e *encoder) EncodeFrame(frame *Frame) error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	err := e.startFrame(e.address, kUnnumberedUFrame)
+	err := e.startFrame(frame.Address(), frame.Control())
 	if err != nil {
 		return err
 	}
 
-	err = e.writeData(payload)
+	err = e.writeData(frame.Payload())
 	if err != nil {
 		return err
 	}
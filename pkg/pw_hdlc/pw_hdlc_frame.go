@@ -1,5 +1,12 @@
 package pw_hdlc
 
+import (
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_varint"
+)
+
 type Frame struct {
 	address uint64
 	control byte
@@ -25,3 +32,19 @@ func (f *Frame) Address() uint64 {
 func (f *Frame) Control() byte {
 	return f.control
 }
+
+// ControlField returns the frame's control byte with its type, sequence
+// numbers and poll/final bit.
+func (f *Frame) ControlField() Control {
+	return Control(f.control)
+}
+
+// Raw returns the frame's contents as they are sent before escaping: the
+// address, control, payload and frame check sequence, without the flags.
+func (f *Frame) Raw() []byte {
+	raw := pw_varint.Encode(f.address, pw_varint.OneTerminatedLeastSignificant)
+	raw = append(raw, f.control)
+	raw = append(raw, f.payload...)
+
+	return binary.LittleEndian.AppendUint32(raw, crc32.ChecksumIEEE(raw))
+}
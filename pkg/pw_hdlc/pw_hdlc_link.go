@@ -0,0 +1,488 @@
+package pw_hdlc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// kMaxWindow is the most I-frames that can await acknowledgement with
+	// modulo 8 sequence numbers.
+	kMaxWindow = kSequenceModulus - 1
+
+	kDefaultRetransmitTimeout = 250 * time.Millisecond
+	kDefaultMaxRetransmits    = 10
+
+	// kLinkQueueSize is how many received frames wait for Decode before the
+	// link stops reading.
+	kLinkQueueSize = 64
+)
+
+var (
+	ErrLinkFailed = errors.New("link failed")
+	ErrLinkClosed = errors.New("link closed")
+)
+
+// LinkConfig configures a reliable link. Zero fields take their defaults.
+type LinkConfig struct {
+	// Address is the address of the link's frames.
+	Address uint64
+	// Window is how many I-frames may await acknowledgement, up to 7, the
+	// default.
+	Window int
+	// RetransmitTimeout is how long to wait for an acknowledgement before
+	// sending the unacknowledged frames again, 250ms by default.
+	RetransmitTimeout time.Duration
+	// MaxRetransmits is how many timeouts in a row fail the link, 10 by
+	// default.
+	MaxRetransmits int
+	// Hook, if set, is called with every frame the link reads or writes,
+	// including the frames that manage the link and those sent again, e.g.
+	// to capture them.
+	Hook func(outbound bool, frame *Frame)
+}
+
+// LinkStats counts the frames of a link.
+type LinkStats struct {
+	Sent          int
+	Received      int
+	Retransmitted int
+	Rejected      int
+	Timeouts      int
+}
+
+// Link is a reliable HDLC link in asynchronous balanced mode. Both ends set
+// it up with SABM and UA, then send data in sequence numbered I-frames that
+// are acknowledged with RR. A frame received out of sequence is answered
+// with REJ and the sender goes back to the rejected frame; a frame lost with
+// its acknowledgement is sent again after a timeout.
+//
+// Decode returns the I-frames of the link's address in order, without gaps
+// or duplicates. UI frames, and frames on other addresses such as logs, are
+// returned as they arrive. Encode sends its payload in an I-frame, waiting
+// while the window is full; EncodeFrame sends a frame without sequencing it.
+type Link interface {
+	Decoder
+	Encoder
+	Stats() LinkStats
+	// Close ends the link and closes its connection.
+	Close() error
+}
+
+// NewLink starts a link over rwc and reads it until the link is closed or
+// fails. Both ends of rwc must run a link on the same address.
+func NewLink(rwc io.ReadWriteCloser, config LinkConfig) Link {
+	if config.Window <= 0 || config.Window > kMaxWindow {
+		config.Window = kMaxWindow
+	}
+	if config.RetransmitTimeout <= 0 {
+		config.RetransmitTimeout = kDefaultRetransmitTimeout
+	}
+	if config.MaxRetransmits <= 0 {
+		config.MaxRetransmits = kDefaultMaxRetransmits
+	}
+
+	l := &link{
+		rwc:     rwc,
+		config:  config,
+		encoder: NewEncoder(rwc, config.Address),
+		decoder: NewDecoder(rwc, config.Address),
+		frames:  make(chan *Frame, kLinkQueueSize),
+		done:    make(chan struct{}),
+	}
+	l.cond = sync.NewCond(&l.mu)
+
+	l.mu.Lock()
+	l.timer = time.AfterFunc(config.RetransmitTimeout, l.timeout)
+	l.unnumbered(SetAsyncBalancedMode, true)
+	l.mu.Unlock()
+
+	go l.read()
+	go l.write()
+
+	return l
+}
+
+type link struct {
+	rwc     io.ReadWriteCloser
+	config  LinkConfig
+	encoder Encoder
+	decoder Decoder
+	frames  chan *Frame
+	done    chan struct{}
+	timer   *time.Timer
+	once    sync.Once
+	mu      sync.Mutex
+	cond    *sync.Cond
+	err     error
+	stats   LinkStats
+	// established is set once the peer has answered our SABM, or sent its
+	// own.
+	established bool
+	// vs is the sequence number of the next new I-frame, va that of the
+	// oldest unacknowledged one and vr the one expected next.
+	vs uint8
+	va uint8
+	vr uint8
+	// rejected is set from sending REJ until the rejected frame arrives, so
+	// that a burst of frames out of sequence is rejected once.
+	rejected bool
+	retries  int
+	// unacked holds the payloads of the frames from va to vs, and queue
+	// those waiting for room in the window.
+	unacked [][]byte
+	queue   [][]byte
+	queued  uint64
+	// out holds the frames for the writer, so that reading never waits on
+	// a write.
+	out []*Frame
+}
+
+func (l *link) Decode(ctx context.Context) (*Frame, error) {
+	select {
+	case frame := <-l.frames:
+		return frame, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-l.done:
+	}
+
+	// Frames received before the link ended are still returned.
+	select {
+	case frame := <-l.frames:
+		return frame, nil
+	default:
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return nil, l.err
+}
+
+func (l *link) Encode(payload []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err != nil {
+		return l.err
+	}
+
+	ticket := l.queued
+	l.queued++
+	l.queue = append(l.queue, append([]byte(nil), payload...))
+	l.transmit()
+
+	// The payloads before the queue have been sent.
+	for l.err == nil && l.queued-uint64(len(l.queue)) <= ticket {
+		l.cond.Wait()
+	}
+
+	if l.queued-uint64(len(l.queue)) > ticket {
+		return nil
+	}
+
+	return l.err
+}
+
+func (l *link) EncodeFrame(frame *Frame) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err != nil {
+		return l.err
+	}
+
+	l.send(frame)
+
+	return nil
+}
+
+func (l *link) Stats() LinkStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.stats
+}
+
+func (l *link) Close() error {
+	var err error
+	l.once.Do(func() {
+		l.mu.Lock()
+		l.stop(ErrLinkClosed)
+		l.mu.Unlock()
+
+		err = l.rwc.Close()
+	})
+
+	return err
+}
+
+// stop ends the link with err, unless it already ended. l.mu must be held.
+func (l *link) stop(err error) {
+	if l.err != nil {
+		return
+	}
+
+	l.err = err
+	l.timer.Stop()
+	close(l.done)
+	l.cond.Broadcast()
+}
+
+func (l *link) fail(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.stop(err)
+}
+
+// send queues frame for the writer. l.mu must be held, as for the other
+// methods below.
+func (l *link) send(frame *Frame) {
+	l.out = append(l.out, frame)
+	l.cond.Broadcast()
+}
+
+func (l *link) unnumbered(function UnnumberedFunction, pollFinal bool) {
+	l.send(NewFrame(l.config.Address, byte(UnnumberedControl(function, pollFinal)), nil))
+}
+
+func (l *link) supervisory(function SupervisoryFunction, pollFinal bool) {
+	l.send(NewFrame(l.config.Address, byte(SupervisoryControl(function, l.vr, pollFinal)), nil))
+}
+
+func (l *link) information(ns uint8, payload []byte) {
+	l.send(NewFrame(l.config.Address, byte(InformationControl(ns, l.vr, false)), payload))
+}
+
+// transmit sends the queued payloads that fit in the window.
+func (l *link) transmit() {
+	if !l.established {
+		return
+	}
+
+	for len(l.queue) > 0 && len(l.unacked) < l.config.Window {
+		payload := l.queue[0]
+		l.queue = l.queue[1:]
+
+		l.information(l.vs, payload)
+		l.unacked = append(l.unacked, payload)
+		l.vs = (l.vs + 1) % kSequenceModulus
+		l.stats.Sent++
+
+		if len(l.unacked) == 1 {
+			l.timer.Reset(l.config.RetransmitTimeout)
+		}
+	}
+
+	l.cond.Broadcast()
+}
+
+// retransmit goes back to the oldest unacknowledged frame and sends it and
+// every frame after it again.
+func (l *link) retransmit() {
+	for i, payload := range l.unacked {
+		l.information((l.va+uint8(i))%kSequenceModulus, payload)
+		l.stats.Retransmitted++
+	}
+
+	if len(l.unacked) > 0 {
+		l.timer.Reset(l.config.RetransmitTimeout)
+	}
+}
+
+// acknowledge drops the frames before nr from the window. An nr outside of
+// the window is stale and ignored.
+func (l *link) acknowledge(nr uint8) {
+	n := int((nr + kSequenceModulus - l.va) % kSequenceModulus)
+	if n == 0 || n > len(l.unacked) {
+		return
+	}
+
+	l.unacked = l.unacked[n:]
+	l.va = nr
+	l.retries = 0
+
+	if len(l.unacked) == 0 {
+		l.timer.Stop()
+	} else {
+		l.timer.Reset(l.config.RetransmitTimeout)
+	}
+
+	l.transmit()
+}
+
+// reset starts the sequence numbers again when the peer sends SABM, e.g.
+// after it restarted. Unacknowledged frames are sent again, renumbered.
+func (l *link) reset(pollFinal bool) {
+	l.queue = append(append([][]byte(nil), l.unacked...), l.queue...)
+	l.unacked = nil
+	l.vs, l.va, l.vr = 0, 0, 0
+	l.rejected = false
+	l.retries = 0
+	l.established = true
+	l.timer.Stop()
+
+	l.unnumbered(UnnumberedAcknowledgement, pollFinal)
+	l.transmit()
+}
+
+func (l *link) timeout() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err != nil || l.established && len(l.unacked) == 0 {
+		return
+	}
+
+	l.retries++
+	if l.retries > l.config.MaxRetransmits {
+		l.stop(ErrLinkFailed)
+		return
+	}
+
+	l.stats.Timeouts++
+
+	if !l.established {
+		l.unnumbered(SetAsyncBalancedMode, true)
+		l.timer.Reset(l.config.RetransmitTimeout)
+		return
+	}
+
+	l.retransmit()
+}
+
+// receive handles a frame of the link's address. It returns the frame if it
+// is the next I-frame in sequence, for Decode.
+func (l *link) receive(frame *Frame) *Frame {
+	c := frame.ControlField()
+
+	switch c.Type() {
+	case InformationFrame:
+		// I-frames sent after a lost UA are dropped; the peer sends them
+		// again after answering our next SABM.
+		if !l.established {
+			return nil
+		}
+
+		l.acknowledge(c.ReceiveSequence())
+
+		if c.SendSequence() != l.vr {
+			if l.rejected {
+				l.supervisory(ReceiveReady, c.PollFinal())
+				return nil
+			}
+
+			l.rejected = true
+			l.stats.Rejected++
+			l.supervisory(Reject, c.PollFinal())
+			return nil
+		}
+
+		l.vr = (l.vr + 1) % kSequenceModulus
+		l.rejected = false
+		l.stats.Received++
+		l.supervisory(ReceiveReady, c.PollFinal())
+
+		return frame
+	case SupervisoryFrame:
+		if !l.established {
+			return nil
+		}
+
+		// RNR is taken as RR, as the link never holds back its frames.
+		l.acknowledge(c.ReceiveSequence())
+
+		switch c.Supervisory() {
+		case Reject, SelectiveReject:
+			l.retransmit()
+		}
+	case UnnumberedFrame:
+		switch c.Unnumbered() {
+		case SetAsyncBalancedMode:
+			l.reset(c.PollFinal())
+		case UnnumberedAcknowledgement:
+			if !l.established {
+				l.established = true
+				l.retries = 0
+				l.timer.Stop()
+				l.transmit()
+			}
+		}
+	}
+
+	return nil
+}
+
+func (l *link) read() {
+	for {
+		frame, err := l.decoder.Decode(context.Background())
+		if errors.Is(err, ErrDataLoss) {
+			continue
+		} else if err != nil {
+			l.fail(err)
+			return
+		}
+
+		if l.config.Hook != nil {
+			l.config.Hook(false, frame)
+		}
+
+		c := frame.ControlField()
+		if frame.Address() == l.config.Address && !(c.Type() == UnnumberedFrame && c.Unnumbered() == UnnumberedInformation) {
+			l.mu.Lock()
+			frame = l.receive(frame)
+			stopped := l.err != nil
+			l.mu.Unlock()
+
+			if stopped {
+				return
+			}
+			if frame == nil {
+				continue
+			}
+		}
+
+		select {
+		case l.frames <- frame:
+		case <-l.done:
+			return
+		}
+	}
+}
+
+func (l *link) write() {
+	l.mu.Lock()
+
+	for {
+		for len(l.out) == 0 && l.err == nil {
+			l.cond.Wait()
+		}
+
+		if l.err != nil {
+			l.mu.Unlock()
+			return
+		}
+
+		out := l.out
+		l.out = nil
+		l.mu.Unlock()
+
+		for _, frame := range out {
+			if err := l.encoder.EncodeFrame(frame); err != nil {
+				l.fail(err)
+				return
+			}
+
+			if l.config.Hook != nil {
+				l.config.Hook(true, frame)
+			}
+		}
+
+		l.mu.Lock()
+	}
+}
@@ -0,0 +1,344 @@
+package pw_hdlc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	kLinkTestTimeout = 10 * time.Second
+)
+
+// noisyConn drops and flips the bytes written to it, like a noisy UART.
+type noisyConn struct {
+	net.Conn
+	rng  *rand.Rand
+	rate float64
+	mu   sync.Mutex
+}
+
+func (c *noisyConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	out := make([]byte, 0, len(p))
+	for _, b := range p {
+		if c.rng.Float64() < c.rate {
+			continue
+		}
+		if c.rng.Float64() < c.rate {
+			b ^= 1 << c.rng.Intn(8)
+		}
+		out = append(out, b)
+	}
+	c.mu.Unlock()
+
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// linkPair connects two links through a pipe that corrupts rate of the bytes
+// in each direction.
+func linkPair(t *testing.T, seed int64, rate float64, config LinkConfig) (Link, Link) {
+	t.Helper()
+
+	a, b := net.Pipe()
+	la := NewLink(&noisyConn{Conn: a, rng: rand.New(rand.NewSource(seed)), rate: rate}, config)
+	lb := NewLink(&noisyConn{Conn: b, rng: rand.New(rand.NewSource(seed + 1)), rate: rate}, config)
+	t.Cleanup(func() {
+		la.Close()
+		lb.Close()
+	})
+
+	return la, lb
+}
+
+// exchange sends n payloads from one link to the other and checks that they
+// arrive once each, in order.
+func exchange(t *testing.T, from Link, to Link, n int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), kLinkTestTimeout)
+	defer cancel()
+
+	go func() {
+		for i := 0; i < n; i++ {
+			if err := from.Encode([]byte(fmt.Sprintf("payload %d", i))); err != nil {
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		frame, err := to.Decode(ctx)
+		if err != nil {
+			return fmt.Errorf("frame %d: %w", i, err)
+		}
+
+		want := fmt.Sprintf("payload %d", i)
+		if string(frame.Payload()) != want {
+			return fmt.Errorf("frame %d = %q, want %q", i, frame.Payload(), want)
+		}
+		if frame.ControlField().Type() != InformationFrame {
+			return fmt.Errorf("frame %d control = %s", i, frame.ControlField())
+		}
+	}
+
+	return nil
+}
+
+// exchangeBoth sends n payloads each way at once.
+func exchangeBoth(t *testing.T, a Link, b Link, n int) {
+	t.Helper()
+
+	errs := make(chan error, 2)
+	go func() { errs <- exchange(t, a, b, n) }()
+	go func() { errs <- exchange(t, b, a, n) }()
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLink(t *testing.T) {
+	a, b := linkPair(t, 0, 0, LinkConfig{Address: 'R'})
+
+	// Enough frames for the sequence numbers to wrap many times.
+	exchangeBoth(t, a, b, 100)
+
+	stats := a.Stats()
+	if stats.Sent != 100 || stats.Received != 100 || stats.Retransmitted != 0 || stats.Rejected != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestLinkWindow(t *testing.T) {
+	for _, window := range []int{1, 3, 7} {
+		a, b := linkPair(t, 0, 0, LinkConfig{Address: 'R', Window: window})
+		exchangeBoth(t, a, b, 20)
+	}
+}
+
+func TestLinkRecoversLostFrames(t *testing.T) {
+	for seed := int64(0); seed < 4; seed++ {
+		t.Run(fmt.Sprint(seed), func(t *testing.T) {
+			a, b := linkPair(t, seed*2, 0.003, LinkConfig{
+				Address:           'R',
+				RetransmitTimeout: 20 * time.Millisecond,
+				MaxRetransmits:    50,
+			})
+
+			exchangeBoth(t, a, b, 200)
+
+			sa, sb := a.Stats(), b.Stats()
+			if sa.Retransmitted+sb.Retransmitted == 0 {
+				t.Errorf("nothing retransmitted: %+v, %+v", sa, sb)
+			}
+		})
+	}
+}
+
+// hooked is a frame passed to a link's Hook.
+type hooked struct {
+	outbound bool
+	control  Control
+}
+
+func TestLinkRejectsOutOfSequence(t *testing.T) {
+	host, device := net.Pipe()
+	hooks := make(chan hooked, 64)
+	l := NewLink(host, LinkConfig{
+		Address:           'R',
+		RetransmitTimeout: time.Minute,
+		Hook: func(outbound bool, frame *Frame) {
+			hooks <- hooked{outbound, frame.ControlField()}
+		},
+	})
+	defer l.Close()
+
+	// Every frame the peer sends or receives is also passed to the hook.
+	var want []hooked
+
+	peer := NewEncoder(device, 'R')
+	frames := make(chan *Frame, 16)
+	go func() {
+		decoder := NewDecoder(device, 'R')
+		for {
+			frame, err := decoder.Decode(context.Background())
+			if err != nil {
+				close(frames)
+				return
+			}
+			frames <- frame
+		}
+	}()
+
+	next := func() Control {
+		t.Helper()
+		select {
+		case frame := <-frames:
+			want = append(want, hooked{true, frame.ControlField()})
+			return frame.ControlField()
+		case <-time.After(kLinkTestTimeout):
+			t.Fatal("no frame")
+			return 0
+		}
+	}
+
+	if c := next(); c != UnnumberedControl(SetAsyncBalancedMode, true) {
+		t.Fatalf("first frame %s, want SABM", c)
+	}
+	send := func(c Control, payload string) {
+		t.Helper()
+		if err := peer.EncodeFrame(NewFrame('R', byte(c), []byte(payload))); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, hooked{false, c})
+	}
+
+	send(UnnumberedControl(UnnumberedAcknowledgement, true), "")
+	send(InformationControl(0, 0, false), "zero")
+	if c := next(); c != SupervisoryControl(ReceiveReady, 1, false) {
+		t.Errorf("after I(0): %s, want RR nr=1", c)
+	}
+
+	// I(1) was lost: I(2) is rejected once, and I(3) only acknowledged.
+	send(InformationControl(2, 0, false), "two")
+	if c := next(); c != SupervisoryControl(Reject, 1, false) {
+		t.Errorf("after I(2): %s, want REJ nr=1", c)
+	}
+	send(InformationControl(3, 0, false), "three")
+	if c := next(); c != SupervisoryControl(ReceiveReady, 1, false) {
+		t.Errorf("after I(3): %s, want RR nr=1", c)
+	}
+
+	send(InformationControl(1, 0, false), "one")
+	if c := next(); c != SupervisoryControl(ReceiveReady, 2, false) {
+		t.Errorf("after I(1): %s, want RR nr=2", c)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), kLinkTestTimeout)
+	defer cancel()
+	for _, want := range []string{"zero", "one"} {
+		frame, err := l.Decode(ctx)
+		if err != nil || string(frame.Payload()) != want {
+			t.Errorf("Decode = %v, %v, want %q", frame, err, want)
+		}
+	}
+
+	// A rejected frame is sent again from the rejected one on.
+	for _, payload := range []string{"a", "b"} {
+		if err := l.Encode([]byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []Control{InformationControl(0, 2, false), InformationControl(1, 2, false)} {
+		if c := next(); c != want {
+			t.Errorf("sent %s, want %s", c, want)
+		}
+	}
+	send(SupervisoryControl(Reject, 1, false), "")
+	if c := next(); c != InformationControl(1, 2, false) {
+		t.Errorf("after REJ nr=1: %s, want I ns=1 nr=2", c)
+	}
+
+	// The two directions are hooked by different goroutines, so only the
+	// order of each is known.
+	var got []hooked
+	for range want {
+		select {
+		case h := <-hooks:
+			got = append(got, h)
+		case <-time.After(kLinkTestTimeout):
+			t.Fatalf("hooked %d frames, want %d", len(got), len(want))
+		}
+	}
+	for _, outbound := range []bool{false, true} {
+		if g, w := fmt.Sprint(inDirection(got, outbound)), fmt.Sprint(inDirection(want, outbound)); g != w {
+			t.Errorf("hooked outbound=%t %s, want %s", outbound, g, w)
+		}
+	}
+}
+
+func inDirection(frames []hooked, outbound bool) []Control {
+	var controls []Control
+	for _, h := range frames {
+		if h.outbound == outbound {
+			controls = append(controls, h.control)
+		}
+	}
+
+	return controls
+}
+
+func TestLinkPassesOtherFrames(t *testing.T) {
+	a, b := linkPair(t, 0, 0, LinkConfig{Address: 'R'})
+
+	ctx, cancel := context.WithTimeout(context.Background(), kLinkTestTimeout)
+	defer cancel()
+
+	frames := []*Frame{
+		NewFrame(1, byte(UnnumberedControl(UnnumberedInformation, false)), []byte("log")),
+		NewFrame('R', byte(UnnumberedControl(UnnumberedInformation, false)), []byte("ui")),
+	}
+	for _, frame := range frames {
+		if err := a.EncodeFrame(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, want := range frames {
+		got, err := b.Decode(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Address() != want.Address() || got.Control() != want.Control() || string(got.Payload()) != string(want.Payload()) {
+			t.Errorf("Decode = %d %s %q, want %d %s %q", got.Address(), got.ControlField(), got.Payload(), want.Address(), want.ControlField(), want.Payload())
+		}
+	}
+}
+
+func TestLinkFails(t *testing.T) {
+	host, device := net.Pipe()
+	go io.Copy(io.Discard, device)
+
+	l := NewLink(host, LinkConfig{Address: 'R', RetransmitTimeout: time.Millisecond, MaxRetransmits: 3})
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), kLinkTestTimeout)
+	defer cancel()
+
+	if _, err := l.Decode(ctx); !errors.Is(err, ErrLinkFailed) {
+		t.Errorf("Decode = %v, want %v", err, ErrLinkFailed)
+	}
+	if err := l.Encode([]byte("x")); !errors.Is(err, ErrLinkFailed) {
+		t.Errorf("Encode = %v, want %v", err, ErrLinkFailed)
+	}
+	if stats := l.Stats(); stats.Timeouts != 3 {
+		t.Errorf("Timeouts = %d, want 3", stats.Timeouts)
+	}
+}
+
+func TestLinkClose(t *testing.T) {
+	a, _ := linkPair(t, 0, 0, LinkConfig{Address: 'R'})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := a.Decode(context.Background())
+		done <- err
+	}()
+
+	a.Close()
+
+	if err := <-done; !errors.Is(err, ErrLinkClosed) {
+		t.Errorf("Decode = %v, want %v", err, ErrLinkClosed)
+	}
+}
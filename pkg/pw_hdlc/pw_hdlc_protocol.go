@@ -1,6 +1,9 @@
 package pw_hdlc
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 const (
 	kFlag           = byte(0x7E)
@@ -12,6 +15,9 @@ const (
 	kControlSize         = 1
 	kFcsSize             = 4
 	kMinContentSizeBytes = 6
+	// kMaxBufferSize bounds the bytes a decoder keeps, in a frame or
+	// between frames. Beyond it they are dropped with a DecodeError.
+	kMaxBufferSize = 1 << 20
 )
 
 var (
@@ -36,6 +42,33 @@ var (
 	ErrShortBuffer       = errors.New("short buffer")
 )
 
+// The reasons a DecodeError gives for dropping bytes.
+const (
+	ReasonDiscarded   = "bytes outside of a frame"
+	ReasonTooShort    = "frame too short"
+	ReasonTooLong     = "frame too long"
+	ReasonBadFcs      = "frame check sequence mismatch"
+	ReasonEscapedFlag = "escaped flag"
+	ReasonBadEscape   = "invalid escape sequence"
+)
+
+// DecodeError describes the bytes a decoder dropped. Err is ErrDataLoss and
+// Data holds the unescaped contents of the dropped frame, or the bytes that
+// were discarded between frames.
+type DecodeError struct {
+	Err    error
+	Reason string
+	Data   []byte
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s: %s (%d bytes)", e.Err, e.Reason, len(e.Data))
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
 func NeedsEscaping(b byte) bool {
 	return b == kFlag || b == kEscape
 }
@@ -0,0 +1,231 @@
+package pw_hdlc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+func encode(t testing.TB, address uint64, payload []byte) []byte {
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf, address).Encode(payload); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// decodeAll decodes every frame in data. Only data loss errors are allowed.
+func decodeAll(t testing.TB, data []byte) []*Frame {
+	var frames []*Frame
+
+	decoder := NewDecoder(bytes.NewReader(data), 'R')
+	for i := 0; i <= len(data); i++ {
+		frame, err := decoder.Decode(context.Background())
+		if err == io.EOF {
+			return frames
+		} else if errors.Is(err, ErrDataLoss) {
+			continue
+		} else if err != nil {
+			t.Fatalf("Decode(%x): %s", data, err)
+		}
+
+		frames = append(frames, frame)
+	}
+
+	t.Fatalf("Decode(%x) did not reach the end of the data", data)
+	return nil
+}
+
+// kGoldenFrames are UI frames to address 0x7b from Pigweed's encoder tests.
+var kGoldenFrames = []struct {
+	name    string
+	payload []byte
+	encoded []byte
+}{
+	{"empty", []byte{}, []byte{kFlag, 0xf7, 0x03, 0x3f, 0x34, 0x2d, 0x83, kFlag}},
+	{"one byte", []byte("A"), []byte{kFlag, 0xf7, 0x03, 'A', 0x82, 0x9e, 0x3c, 0x65, kFlag}},
+	{"escaped escape", []byte{0x7d}, []byte{kFlag, 0xf7, 0x03, 0x7d, 0x5d, 0x05, 0xe2, 0x53, 0x4a, kFlag}},
+	{"escaped flag", []byte{0x7e}, []byte{kFlag, 0xf7, 0x03, 0x7d, 0x5e, 0xbf, 0xb3, 0x5a, 0xd3, kFlag}},
+	{"multibyte", []byte("ABC"), []byte{kFlag, 0xf7, 0x03, 'A', 'B', 'C', 0xe4, 0x0e, 0x41, 0x72, kFlag}},
+}
+
+func TestGoldenFrames(t *testing.T) {
+	const address = 0x7b
+
+	for _, golden := range kGoldenFrames {
+		if encoded := encode(t, address, golden.payload); !bytes.Equal(encoded, golden.encoded) {
+			t.Errorf("%s: Encode() = %x, want %x", golden.name, encoded, golden.encoded)
+		}
+
+		frames := decodeAll(t, golden.encoded)
+		if len(frames) != 1 || frames[0].Address() != address || frames[0].Control() != kUnnumberedUFrame || !bytes.Equal(frames[0].Payload(), golden.payload) {
+			t.Errorf("%s: Decode() = %v, want %x", golden.name, frames, golden.payload)
+		}
+
+		// A flipped bit fails the frame check sequence.
+		corrupted := bytes.Clone(golden.encoded)
+		corrupted[len(corrupted)-2] ^= 0x01
+		if frames := decodeAll(t, corrupted); len(frames) != 0 {
+			t.Errorf("%s: decoded a corrupted frame", golden.name)
+		}
+	}
+}
+
+// kControls are control fields of each frame type, with the names the HDLC
+// standard gives their bits.
+var kControls = []struct {
+	control Control
+	want    Control
+	name    string
+}{
+	{InformationControl(0, 0, false), 0x00, "I ns=0 nr=0"},
+	{InformationControl(3, 5, true), 0xb6, "I ns=3 nr=5 P/F"},
+	{InformationControl(7, 7, false), 0xee, "I ns=7 nr=7"},
+	{InformationControl(9, 10, false), 0x42, "I ns=1 nr=2"},
+	{SupervisoryControl(ReceiveReady, 2, false), 0x41, "RR nr=2"},
+	{SupervisoryControl(ReceiveNotReady, 0, false), 0x05, "RNR nr=0"},
+	{SupervisoryControl(Reject, 7, true), 0xf9, "REJ nr=7 P/F"},
+	{SupervisoryControl(SelectiveReject, 1, false), 0x2d, "SREJ nr=1"},
+	{UnnumberedControl(UnnumberedInformation, false), 0x03, "UI"},
+	{UnnumberedControl(UnnumberedInformation, true), 0x13, "UI P/F"},
+	{UnnumberedControl(SetAsyncBalancedMode, true), 0x3f, "SABM P/F"},
+	{UnnumberedControl(UnnumberedAcknowledgement, true), 0x73, "UA P/F"},
+	{UnnumberedControl(Disconnect, false), 0x43, "DISC"},
+	{UnnumberedControl(DisconnectedMode, false), 0x0f, "DM"},
+	{UnnumberedControl(FrameReject, false), 0x87, "FRMR"},
+}
+
+func TestControl(t *testing.T) {
+	for _, test := range kControls {
+		if test.control != test.want || test.control.String() != test.name {
+			t.Errorf("%s: control 0x%02x %q, want 0x%02x", test.name, byte(test.control), test.control, byte(test.want))
+		}
+	}
+
+	if Control(kUnnumberedUFrame).Type() != UnnumberedFrame || Control(kUnnumberedUFrame).Unnumbered() != UnnumberedInformation {
+		t.Errorf("0x%02x is not a UI frame", kUnnumberedUFrame)
+	}
+}
+
+// TestControlRoundTrip parses every control byte and builds it again from
+// its fields.
+func TestControlRoundTrip(t *testing.T) {
+	for b := 0; b < 256; b++ {
+		c := Control(b)
+
+		var again Control
+		switch c.Type() {
+		case InformationFrame:
+			again = InformationControl(c.SendSequence(), c.ReceiveSequence(), c.PollFinal())
+		case SupervisoryFrame:
+			again = SupervisoryControl(c.Supervisory(), c.ReceiveSequence(), c.PollFinal())
+		case UnnumberedFrame:
+			again = UnnumberedControl(c.Unnumbered(), c.PollFinal())
+		}
+
+		if again != c {
+			t.Errorf("0x%02x (%s) rebuilt as 0x%02x", b, c, byte(again))
+		}
+	}
+}
+
+func TestEncodeFrame(t *testing.T) {
+	frames := []*Frame{
+		NewFrame('R', byte(InformationControl(4, 1, true)), []byte{kFlag, 1, 2}),
+		NewFrame('R', byte(SupervisoryControl(Reject, 4, false)), nil),
+		NewFrame(1<<20, byte(UnnumberedControl(SetAsyncBalancedMode, true)), nil),
+	}
+
+	var buf bytes.Buffer
+	encoder := NewEncoder(&buf, 'R')
+	for _, frame := range frames {
+		if err := encoder.EncodeFrame(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	decoded := decodeAll(t, buf.Bytes())
+	if len(decoded) != len(frames) {
+		t.Fatalf("decoded %d frames, want %d", len(decoded), len(frames))
+	}
+	for i, frame := range frames {
+		got := decoded[i]
+		if got.Address() != frame.Address() || got.ControlField() != frame.ControlField() || !bytes.Equal(got.Payload(), frame.Payload()) {
+			t.Errorf("frame %d = %d %s %x, want %d %s %x", i, got.Address(), got.ControlField(), got.Payload(), frame.Address(), frame.ControlField(), frame.Payload())
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{kFlag, kFlag, kFlag})
+	f.Add([]byte{kFlag, kEscape, kFlag})
+	f.Add([]byte{kFlag, kEscape, kEscape, kFlag})
+	f.Add([]byte{1, 2, 3, kFlag, 0xa5, 0x03, kFlag})
+	f.Add(encode(f, 'R', []byte("hello")))
+	f.Add(encode(f, 1<<40, []byte{kFlag, kEscape}))
+	f.Add(append(encode(f, 1, nil), encode(f, 'R', []byte{0x08, 0x01})...))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, frame := range decodeAll(t, data) {
+			// A frame that passed its frame check sequence survives a round
+			// trip unchanged.
+			again := decodeAll(t, encode(t, frame.Address(), frame.Payload()))
+			if len(again) != 1 || again[0].Address() != frame.Address() || !bytes.Equal(again[0].Payload(), frame.Payload()) {
+				t.Fatalf("frame %d %x did not survive a round trip", frame.Address(), frame.Payload())
+			}
+		}
+	})
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	f.Add(uint64('R'), []byte{})
+	f.Add(uint64(0), []byte("hello"))
+	f.Add(uint64(1<<64-1), []byte{kFlag, kEscape, kFlag ^ kEscapeConstant})
+
+	f.Fuzz(func(t *testing.T, address uint64, payload []byte) {
+		frames := decodeAll(t, encode(t, address, payload))
+		if len(frames) != 1 {
+			t.Fatalf("decoded %d frames, want 1", len(frames))
+		}
+
+		frame := frames[0]
+		if frame.Address() != address || frame.Control() != kUnnumberedUFrame || !bytes.Equal(frame.Payload(), payload) {
+			t.Fatalf("decoded %d 0x%02x %x, want %d %x", frame.Address(), frame.Control(), frame.Payload(), address, payload)
+		}
+	})
+}
+
+func TestDecodeBoundsBuffer(t *testing.T) {
+	junk := bytes.Repeat([]byte{0xaa}, kMaxBufferSize+10)
+	frame := encode(t, 'R', []byte("ok"))
+
+	var data []byte
+	data = append(data, junk...)
+	data = append(data, frame...)
+	data = append(data, kFlag)
+	data = append(data, junk...)
+	data = append(data, frame...)
+
+	// Junk between frames is dropped as it fills the buffer, and a frame
+	// too long for it is dropped with the rest of it.
+	want := []string{ReasonDiscarded, ReasonDiscarded, "ok", ReasonTooLong, ReasonDiscarded, "ok"}
+	decoder := NewDecoder(bytes.NewReader(data), 'R')
+	for i, reason := range want {
+		frame, err := decoder.Decode(context.Background())
+
+		var decodeErr *DecodeError
+		if errors.As(err, &decodeErr) {
+			if decodeErr.Reason != reason || len(decodeErr.Data) > kMaxBufferSize {
+				t.Errorf("Decode %d = %s, want %s", i, err, reason)
+			}
+		} else if err != nil {
+			t.Fatalf("Decode %d: %s", i, err)
+		} else if string(frame.Payload()) != reason {
+			t.Errorf("Decode %d = %q, want %s", i, frame.Payload(), reason)
+		}
+	}
+}
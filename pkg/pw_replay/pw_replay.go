@@ -0,0 +1,345 @@
+// Package pw_replay replays a recorded device session. A session is recorded
+// with a pw_capture.Writer tapping a client or server, and replayed by a
+// Device, which answers the host's requests with the recorded responses.
+package pw_replay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_capture"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_hdlc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	kRpcAddress        = 'R'
+	kUnnumberedControl = byte(0x03)
+)
+
+var (
+	ErrUnmatched = errors.New("no recorded packet matches")
+)
+
+// Session is a recorded sequence of frames.
+type Session struct {
+	Records []*pw_capture.Record
+}
+
+// Load reads a session from a JSON lines capture.
+func Load(path string) (*Session, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	session, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return session, nil
+}
+
+// Read reads a session from a JSON lines capture.
+func Read(r io.Reader) (*Session, error) {
+	session := &Session{}
+	reader := pw_capture.NewJSONReader(r)
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return session, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		session.Records = append(session.Records, record)
+	}
+}
+
+// fromHost reports whether a record was sent by the host. Only packets tell,
+// regardless of which side recorded the session; every other frame, such as
+// a log, came from the device.
+func fromHost(r *pw_capture.Record) bool {
+	if r.Packet == nil {
+		return false
+	}
+
+	switch r.Packet.Type {
+	case pb.PacketType_REQUEST, pb.PacketType_CLIENT_STREAM,
+		pb.PacketType_CLIENT_ERROR, pb.PacketType_CLIENT_REQUEST_COMPLETION:
+		return true
+	}
+
+	return false
+}
+
+// callKey identifies a call in a session.
+type callKey struct {
+	channelId uint32
+	serviceId uint32
+	methodId  uint32
+	callId    uint32
+}
+
+func keyOf(packet *pb.RpcPacket) callKey {
+	return callKey{packet.ChannelId, packet.ServiceId, packet.MethodId, packet.CallId}
+}
+
+// matches reports whether a live host packet is the recorded one. The
+// channel and call ids are not compared, as they are up to the host.
+func matches(recorded *pb.RpcPacket, live *pb.RpcPacket) bool {
+	return recorded.Type == live.Type &&
+		recorded.ServiceId == live.ServiceId &&
+		recorded.MethodId == live.MethodId &&
+		bytes.Equal(recorded.Payload, live.Payload)
+}
+
+// Device is a fake device that replays a session.
+type Device interface {
+	// Serve answers the host's packets read from rwc until it fails or ctx
+	// is done. It can be given to pw_rpctest.NewClient.
+	Serve(ctx context.Context, rwc io.ReadWriteCloser) error
+	// Unmatched returns the host packets that did not match the session.
+	Unmatched() []*pb.RpcPacket
+	// Err returns ErrUnmatched if any host packet did not match the session.
+	Err() error
+}
+
+type device struct {
+	session   *Session
+	speed     float64
+	used      []bool
+	unmatched []*pb.RpcPacket
+	mu        sync.Mutex
+}
+
+// NewDevice replays session. The recorded delays between a request and its
+// responses are scaled by speed: 1 replays in real time and 0 without delay.
+func NewDevice(session *Session, speed float64) Device {
+	return &device{
+		session: session,
+		speed:   speed,
+		used:    make([]bool, len(session.Records)),
+	}
+}
+
+func (d *device) Unmatched() []*pb.RpcPacket {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]*pb.RpcPacket(nil), d.unmatched...)
+}
+
+func (d *device) Err() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.unmatched) != 0 {
+		return fmt.Errorf("%w: %s", ErrUnmatched, pw_rpc.FormatPacket(d.unmatched[0]))
+	}
+
+	return nil
+}
+
+func (d *device) Serve(ctx context.Context, rwc io.ReadWriteCloser) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	c := &replayConn{
+		device: d,
+		rwc:    rwc,
+	}
+
+	// The frames the device sent before the host's first packet, such as
+	// boot logs, are replayed as soon as the host connects, keeping the
+	// recorded gaps between them.
+	var first []int
+	for i, r := range d.session.Records {
+		if fromHost(r) {
+			break
+		}
+		first = append(first, i)
+	}
+	if len(first) > 0 {
+		go c.replay(ctx, nil, first, d.session.Records[first[0]].Time)
+	}
+
+	decoder := pw_hdlc.NewDecoder(rwc, kRpcAddress)
+	for {
+		frame, err := decoder.Decode(ctx)
+		if errors.Is(err, pw_hdlc.ErrDataLoss) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if frame.Address() != kRpcAddress {
+			continue
+		}
+
+		packet := &pb.RpcPacket{}
+		if err := proto.Unmarshal(frame.Payload(), packet); err != nil {
+			continue
+		}
+
+		c.handle(ctx, packet)
+	}
+}
+
+// match claims the first unused recorded packet that matches a live host
+// packet. It returns the index of the record, or -1.
+func (d *device) match(packet *pb.RpcPacket) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, r := range d.session.Records {
+		if !d.used[i] && fromHost(r) && matches(r.Packet, packet) {
+			d.used[i] = true
+			return i
+		}
+	}
+
+	// A cancellation depends on timing, so it need not have been recorded.
+	if packet.Type != pb.PacketType_CLIENT_ERROR {
+		d.unmatched = append(d.unmatched, packet)
+	}
+
+	return -1
+}
+
+// responses returns the records the device sent in answer to record i: the
+// packets of the same call up to the host's next packet for the call, and
+// the other frames up to the host's next packet.
+func (d *device) responses(i int) []int {
+	records := d.session.Records
+	key := keyOf(records[i].Packet)
+	others := true
+
+	var indexes []int
+	for j := i + 1; j < len(records); j++ {
+		r := records[j]
+
+		if fromHost(r) {
+			if keyOf(r.Packet) == key {
+				break
+			}
+			others = false
+			continue
+		}
+
+		if r.Packet == nil {
+			if others {
+				indexes = append(indexes, j)
+			}
+			continue
+		}
+
+		if keyOf(r.Packet) == key {
+			indexes = append(indexes, j)
+		}
+	}
+
+	return indexes
+}
+
+// replayConn is a connection to a host.
+type replayConn struct {
+	device *device
+	rwc    io.ReadWriteCloser
+	mu     sync.Mutex
+}
+
+func (c *replayConn) handle(ctx context.Context, packet *pb.RpcPacket) {
+	i := c.device.match(packet)
+	if i < 0 {
+		if packet.Type == pb.PacketType_REQUEST {
+			c.send(nil, &pb.RpcPacket{
+				Type:      pb.PacketType_SERVER_ERROR,
+				ChannelId: packet.ChannelId,
+				ServiceId: packet.ServiceId,
+				MethodId:  packet.MethodId,
+				CallId:    packet.CallId,
+				Status:    uint32(pb.StatusCode_NOT_FOUND),
+			})
+		}
+		return
+	}
+
+	go c.replay(ctx, packet, c.device.responses(i), c.device.session.Records[i].Time)
+}
+
+// replay sends the records, keeping their recorded delays from start. Their
+// packets are readdressed to the live packet's channel and call; the frames
+// that need not be are sent as recorded.
+func (c *replayConn) replay(ctx context.Context, live *pb.RpcPacket, indexes []int, start time.Time) {
+	begin := time.Now()
+
+	for _, i := range indexes {
+		r := c.device.session.Records[i]
+
+		if c.device.speed > 0 {
+			delay := time.Duration(float64(r.Time.Sub(start))/c.device.speed) - time.Since(begin)
+			if delay > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+			}
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if r.Packet == nil {
+			c.write(r.Frame)
+			continue
+		}
+
+		packet := r.Packet
+		if live != nil && (live.ChannelId != packet.ChannelId || live.CallId != packet.CallId) {
+			packet = proto.Clone(packet).(*pb.RpcPacket)
+			packet.ChannelId = live.ChannelId
+			packet.CallId = live.CallId
+		} else if r.Frame != nil {
+			c.write(r.Frame)
+			continue
+		}
+		c.send(r.Frame, packet)
+	}
+}
+
+// send writes packet in a frame with the address and control field of the
+// recorded frame, or in a UI frame if there is none.
+func (c *replayConn) send(recorded *pw_hdlc.Frame, packet *pb.RpcPacket) {
+	buf, err := proto.Marshal(packet)
+	if err != nil {
+		return
+	}
+
+	address, control := uint64(kRpcAddress), kUnnumberedControl
+	if recorded != nil {
+		address, control = recorded.Address(), recorded.Control()
+	}
+
+	c.write(pw_hdlc.NewFrame(address, control, buf))
+}
+
+func (c *replayConn) write(frame *pw_hdlc.Frame) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pw_hdlc.NewEncoder(c.rwc, frame.Address()).EncodeFrame(frame)
+}
@@ -0,0 +1,211 @@
+package pw_replay_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	cmdpb "github.com/robertfarnum/go-pw-rpc/cmd/pb"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_capture"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_hdlc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_replay"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpctest"
+	"google.golang.org/protobuf/proto"
+)
+
+// session runs the calls against the client and checks the responses.
+func session(t *testing.T, ctx context.Context, c pw_rpc.Client) {
+	t.Helper()
+
+	client := cmdpb.NewBenchmarkClient(c)
+
+	for _, payload := range []string{"one", "two"} {
+		if err := pw_rpctest.Echo(ctx, c, []byte(payload)); err != nil {
+			t.Fatalf("UnaryEcho(%q): %s", payload, err)
+		}
+	}
+
+	stream, err := client.BidirectionalEcho(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, payload := range []string{"a", "b"} {
+		if err := stream.Send(&cmdpb.Payload{Payload: []byte(payload)}); err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("BidirectionalEcho(%q): %s", payload, err)
+		}
+		if string(res.Payload) != payload {
+			t.Errorf("BidirectionalEcho(%q) = %q", payload, res.Payload)
+		}
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Errorf("BidirectionalEcho Recv() = %v, want io.EOF", err)
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Record a session with a real server.
+	server, err := pw_rpctest.NewEchoServer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := pw_rpctest.NewServerClient(ctx, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var capture bytes.Buffer
+	c.SetTap(pw_capture.NewJSONWriter(&capture))
+
+	session(t, ctx, c)
+	c.Close()
+
+	recorded, err := pw_replay.Read(&capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replay it without the server.
+	device := pw_replay.NewDevice(recorded, 0)
+
+	c, err = pw_rpctest.NewClient(ctx, device.Serve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	session(t, ctx, c)
+
+	if err := device.Err(); err != nil {
+		t.Errorf("replay: %s", err)
+	}
+
+	// A request that was not recorded fails.
+	_, err = cmdpb.NewBenchmarkClient(c).UnaryEcho(ctx, &cmdpb.Payload{Payload: []byte("three")})
+	if pw_rpc.StatusCode(err) != pb.StatusCode_NOT_FOUND {
+		t.Errorf("UnaryEcho(\"three\") = %v, want NOT_FOUND", err)
+	}
+	if !errors.Is(device.Err(), pw_replay.ErrUnmatched) {
+		t.Errorf("Err() = %v, want ErrUnmatched", device.Err())
+	}
+}
+
+func TestReplayBootTiming(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Boot logs recorded long ago, 200ms apart.
+	at := time.Now().Add(-time.Hour)
+	recorded := &pw_replay.Session{}
+	for i, log := range []string{"boot", "ready"} {
+		recorded.Records = append(recorded.Records, &pw_capture.Record{
+			Time:      at.Add(time.Duration(i) * 200 * time.Millisecond),
+			Direction: pw_rpc.Inbound,
+			Frame:     pw_hdlc.NewFrame(1, 0x03, []byte(log)),
+		})
+	}
+
+	host, conn := net.Pipe()
+	defer host.Close()
+	go pw_replay.NewDevice(recorded, 1).Serve(ctx, conn)
+
+	decoder := pw_hdlc.NewDecoder(host, 1)
+	var times []time.Time
+	for _, want := range []string{"boot", "ready"} {
+		frame, err := decoder.Decode(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(frame.Payload()) != want {
+			t.Errorf("frame = %q, want %q", frame.Payload(), want)
+		}
+		times = append(times, time.Now())
+	}
+
+	if gap := times[1].Sub(times[0]); gap < 150*time.Millisecond {
+		t.Errorf("logs replayed %s apart, want about 200ms", gap)
+	}
+}
+
+func TestReplayKeepsFrames(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	request := &pb.RpcPacket{Type: pb.PacketType_REQUEST, ChannelId: 1, ServiceId: 2, MethodId: 3, CallId: 4}
+	response := &pb.RpcPacket{Type: pb.PacketType_RESPONSE, ChannelId: 1, ServiceId: 2, MethodId: 3, CallId: 4, Payload: []byte("pong")}
+
+	// The response was recorded in an I-frame, with a repeated call_id that
+	// proto.Marshal would not write.
+	encoded, err := proto.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded = append([]byte{0x38, 0x04}, encoded...)
+	iframe := pw_hdlc.NewFrame('R', 0x02, encoded)
+
+	recorded := &pw_replay.Session{}
+	for i := 0; i < 2; i++ {
+		recorded.Records = append(recorded.Records,
+			&pw_capture.Record{Direction: pw_rpc.Outbound, Packet: request},
+			&pw_capture.Record{Direction: pw_rpc.Inbound, Frame: iframe, Packet: response})
+	}
+
+	host, conn := net.Pipe()
+	defer host.Close()
+	go pw_replay.NewDevice(recorded, 0).Serve(ctx, conn)
+
+	encoder := pw_hdlc.NewEncoder(host, 'R')
+	decoder := pw_hdlc.NewDecoder(host, 'R')
+
+	// The same call gets the recorded frame; another call gets a frame
+	// with the same control field, readdressed to it.
+	for _, callId := range []uint32{4, 9} {
+		live := proto.Clone(request).(*pb.RpcPacket)
+		live.CallId = callId
+		buf, err := proto.Marshal(live)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := encoder.Encode(buf); err != nil {
+			t.Fatal(err)
+		}
+
+		frame, err := decoder.Decode(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame.Control() != iframe.Control() {
+			t.Errorf("call %d: control = 0x%02x, want 0x%02x", callId, frame.Control(), iframe.Control())
+		}
+
+		got := &pb.RpcPacket{}
+		if err := proto.Unmarshal(frame.Payload(), got); err != nil {
+			t.Fatal(err)
+		}
+		if got.CallId != callId || string(got.Payload) != "pong" {
+			t.Errorf("call %d: response %v", callId, got)
+		}
+		if callId == response.CallId && !bytes.Equal(frame.Raw(), iframe.Raw()) {
+			t.Errorf("call %d: frame = %x, want %x as recorded", callId, frame.Raw(), iframe.Raw())
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package pw_rpc
+
+import (
+	"sync"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
+	"google.golang.org/grpc"
+)
+
+// Call is a handle to an RPC. It exposes the pw_rpc details of the call that
+// the gRPC interfaces cannot express: the channel, the ids and the final
+// pw_rpc status. Pass it to a call with WithCall.
+type Call struct {
+	channelId uint32
+	serviceId Key
+	methodId  Key
+	callId    uint32
+	status    pb.StatusCode
+	done      bool
+	mu        sync.Mutex
+}
+
+func (c *Call) ChannelId() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.channelId
+}
+
+func (c *Call) ServiceId() Key {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.serviceId
+}
+
+func (c *Call) MethodId() Key {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.methodId
+}
+
+func (c *Call) CallId() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.callId
+}
+
+// Status returns the status the call completed with, and whether it has
+// completed.
+func (c *Call) Status() (pb.StatusCode, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.status, c.done
+}
+
+func (c *Call) start(key StreamKey, channelId uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.channelId = channelId
+	c.serviceId = key.serviceId
+	c.methodId = key.methodId
+	c.callId = key.callId
+}
+
+func (c *Call) finish(status pb.StatusCode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.status = status
+	c.done = true
+}
+
+type callOption struct {
+	grpc.EmptyCallOption
+	call *Call
+}
+
+// WithCall fills in call as the RPC it is passed to progresses.
+func WithCall(call *Call) grpc.CallOption {
+	return callOption{call: call}
+}
+
+type methodIdsOption struct {
+	grpc.EmptyCallOption
+	serviceId Key
+	methodId  Key
+}
+
+// WithMethodIds addresses a call by precomputed ids, so that the service and
+// method names are not hashed when the call starts.
+func WithMethodIds(serviceId Key, methodId Key) grpc.CallOption {
+	return methodIdsOption{serviceId: serviceId, methodId: methodId}
+}
+
+type channelIdOption struct {
+	grpc.EmptyCallOption
+	channelId uint32
+}
+
+// WithChannelId sends a call on channelId instead of the default channel.
+func WithChannelId(channelId uint32) grpc.CallOption {
+	return channelIdOption{channelId: channelId}
+}
+
+type callIdOption struct {
+	grpc.EmptyCallOption
+	callId uint32
+}
+
+// WithCallId sets the call_id of a call. Clients assign call ids themselves;
+// servers use it to echo the id of the request they are answering.
+func WithCallId(callId uint32) grpc.CallOption {
+	return callIdOption{callId: callId}
+}
+
+type registryOption struct {
+	grpc.EmptyCallOption
+	registry Registry
+}
+
+// withRegistry describes the packets of a call with the registry of the
+// client or server making it.
+func withRegistry(registry Registry) grpc.CallOption {
+	return registryOption{registry: registry}
+}
+
+// UnaryCall is a completed unary call and its response.
+type UnaryCall[Res any] struct {
+	*Call
+	Response *Res
+}
+
+// ServerStreamingCall is a server streaming call in progress.
+type ServerStreamingCall[Res any] struct {
+	*Call
+	grpc.ServerStreamingClient[Res]
+}
+
+// ClientStreamingCall is a client streaming call in progress.
+type ClientStreamingCall[Req any, Res any] struct {
+	*Call
+	grpc.ClientStreamingClient[Req, Res]
+}
+
+// BidiStreamingCall is a bidirectional streaming call in progress.
+type BidiStreamingCall[Req any, Res any] struct {
+	*Call
+	grpc.BidiStreamingClient[Req, Res]
+}
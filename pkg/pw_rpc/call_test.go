@@ -0,0 +1,300 @@
+package pw_rpc_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	cmdpb "github.com/robertfarnum/go-pw-rpc/cmd/pb"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpctest"
+	"google.golang.org/grpc"
+)
+
+// conformanceClient calls the conformance service through the typed handles,
+// the way protoc-gen-go-pwrpc generates clients.
+type conformanceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func (c conformanceClient) options(name string, call *pw_rpc.Call, opts []grpc.CallOption) []grpc.CallOption {
+	return append([]grpc.CallOption{
+		pw_rpc.WithMethodIds(pw_rpc.NewKey(kConformanceService), pw_rpc.NewKey(name)),
+		pw_rpc.WithCall(call),
+	}, opts...)
+}
+
+func (c conformanceClient) Unary(ctx context.Context, in pw_rpc.RawMessage, opts ...grpc.CallOption) (*pw_rpc.UnaryCall[pw_rpc.RawMessage], error) {
+	call := &pw_rpc.Call{}
+	out := new(pw_rpc.RawMessage)
+	err := c.cc.Invoke(ctx, method("Unary"), in, out, c.options("Unary", call, opts)...)
+	return &pw_rpc.UnaryCall[pw_rpc.RawMessage]{Call: call, Response: out}, err
+}
+
+func (c conformanceClient) ServerStream(ctx context.Context, in pw_rpc.RawMessage, opts ...grpc.CallOption) (*pw_rpc.ServerStreamingCall[pw_rpc.RawMessage], error) {
+	call := &pw_rpc.Call{}
+	stream, err := c.cc.NewStream(ctx, kServerStreamDesc, method("ServerStream"), c.options("ServerStream", call, opts)...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[pw_rpc.RawMessage, pw_rpc.RawMessage]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return &pw_rpc.ServerStreamingCall[pw_rpc.RawMessage]{Call: call, ServerStreamingClient: x}, nil
+}
+
+func (c conformanceClient) ClientStream(ctx context.Context, opts ...grpc.CallOption) (*pw_rpc.ClientStreamingCall[pw_rpc.RawMessage, pw_rpc.RawMessage], error) {
+	call := &pw_rpc.Call{}
+	stream, err := c.cc.NewStream(ctx, kClientStreamDesc, method("ClientStream"), c.options("ClientStream", call, opts)...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[pw_rpc.RawMessage, pw_rpc.RawMessage]{ClientStream: stream}
+	return &pw_rpc.ClientStreamingCall[pw_rpc.RawMessage, pw_rpc.RawMessage]{Call: call, ClientStreamingClient: x}, nil
+}
+
+func (c conformanceClient) Bidi(ctx context.Context, opts ...grpc.CallOption) (*pw_rpc.BidiStreamingCall[pw_rpc.RawMessage, pw_rpc.RawMessage], error) {
+	call := &pw_rpc.Call{}
+	stream, err := c.cc.NewStream(ctx, kBidiDesc, method("Bidi"), c.options("Bidi", call, opts)...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[pw_rpc.RawMessage, pw_rpc.RawMessage]{ClientStream: stream}
+	return &pw_rpc.BidiStreamingCall[pw_rpc.RawMessage, pw_rpc.RawMessage]{Call: call, BidiStreamingClient: x}, nil
+}
+
+// tappedClient returns a client of a server with the conformance and echo
+// services. The packets the client sends and receives are shown to the tap.
+func tappedClient(t *testing.T, ctx context.Context) (pw_rpc.Client, chanTap) {
+	t.Helper()
+
+	s := pw_rpc.NewServer("")
+	if err := s.Register(&kConformanceServiceDesc, struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(&cmdpb.Benchmark_ServiceDesc, &pw_rpctest.EchoServer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	host, device := net.Pipe()
+	go s.Serve(ctx, device)
+
+	tap := make(chanTap, 64)
+	c := pw_rpc.NewClientWithDialer(func(context.Context) (io.ReadWriteCloser, error) {
+		return host, nil
+	})
+	c.SetTap(tap)
+	t.Cleanup(c.Close)
+
+	return c, tap
+}
+
+// checkWire checks the call against the packets tapped for it: each carries
+// the call's channel and call ids, and the last one received its status.
+func checkWire(t *testing.T, tap chanTap, call *pw_rpc.Call) {
+	t.Helper()
+
+	code, done := call.Status()
+	if !done {
+		t.Fatalf("call %d has not completed", call.CallId())
+	}
+
+	var last *pb.RpcPacket
+	for {
+		var got tapped
+		select {
+		case got = <-tap:
+		default:
+			if last == nil {
+				t.Fatalf("call %d: no packets received", call.CallId())
+			}
+			if pb.StatusCode(last.Status) != code {
+				t.Errorf("Status() = %s, last %s has %s", code, last.Type, pb.StatusCode(last.Status))
+			}
+			return
+		}
+
+		if got.packet == nil {
+			continue
+		}
+		if got.packet.ChannelId != call.ChannelId() || got.packet.CallId != call.CallId() {
+			t.Errorf("%s %s on channel %d call %d, want channel %d call %d", got.direction, got.packet.Type,
+				got.packet.ChannelId, got.packet.CallId, call.ChannelId(), call.CallId())
+		}
+		if got.direction == pw_rpc.Inbound {
+			last = got.packet
+		}
+	}
+}
+
+func TestTypedCalls(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), kConformanceTimeout)
+	defer cancel()
+
+	c, tap := tappedClient(t, ctx)
+	client := conformanceClient{c}
+
+	t.Run("unary", func(t *testing.T) {
+		call, err := client.Unary(ctx, pw_rpc.RawMessage("hello"))
+		if err != nil || string(*call.Response) != "hello" {
+			t.Fatalf("Unary = %q, %v", *call.Response, err)
+		}
+		checkWire(t, tap, call.Call)
+	})
+
+	t.Run("unary error", func(t *testing.T) {
+		call, err := client.Unary(ctx, pw_rpc.RawMessage(kConformanceFailWord), pw_rpc.WithChannelId(3))
+		if err == nil {
+			t.Fatal("Unary succeeded")
+		}
+		if code, _ := call.Status(); code != pb.StatusCode_INVALID_ARGUMENT || call.ChannelId() != 3 {
+			t.Errorf("Status() = %s on channel %d", code, call.ChannelId())
+		}
+		checkWire(t, tap, call.Call)
+	})
+
+	t.Run("server stream", func(t *testing.T) {
+		call, err := client.ServerStream(ctx, pw_rpc.RawMessage("abc"), pw_rpc.WithChannelId(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var all string
+		for {
+			in, err := call.Recv()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			all += string(*in)
+		}
+		if all != "abc" {
+			t.Errorf("Recv = %q", all)
+		}
+		checkWire(t, tap, call.Call)
+	})
+
+	t.Run("client stream", func(t *testing.T) {
+		call, err := client.ClientStream(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, in := range []string{"a", "b", "c"} {
+			m := pw_rpc.RawMessage(in)
+			if err := call.Send(&m); err != nil {
+				t.Fatal(err)
+			}
+		}
+		out, err := call.CloseAndRecv()
+		if err != nil || string(*out) != "abc" {
+			t.Fatalf("CloseAndRecv = %q, %v", *out, err)
+		}
+		checkWire(t, tap, call.Call)
+	})
+
+	t.Run("bidi", func(t *testing.T) {
+		call, err := client.Bidi(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := pw_rpc.RawMessage("ping")
+		if err := call.Send(&in); err != nil {
+			t.Fatal(err)
+		}
+		out, err := call.Recv()
+		if err != nil || string(*out) != "ping" {
+			t.Fatalf("Recv = %q, %v", *out, err)
+		}
+		if err := call.CloseSend(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := call.Recv(); err != io.EOF {
+			t.Fatalf("Recv = %v, want EOF", err)
+		}
+		checkWire(t, tap, call.Call)
+	})
+}
+
+func TestTypedCallsRoutedByCallId(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), kConformanceTimeout)
+	defer cancel()
+
+	c, _ := tappedClient(t, ctx)
+	client := conformanceClient{c}
+
+	first, err := client.Bidi(ctx, pw_rpc.WithCallId(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := client.Bidi(ctx, pw_rpc.WithCallId(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.CallId() != 5 || second.CallId() != 6 {
+		t.Fatalf("CallId() = %d and %d, want 5 and 6", first.CallId(), second.CallId())
+	}
+
+	calls := []*pw_rpc.BidiStreamingCall[pw_rpc.RawMessage, pw_rpc.RawMessage]{first, second}
+	for i, call := range calls {
+		in := pw_rpc.RawMessage{byte('a' + i)}
+		if err := call.Send(&in); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Receive in the other order, so each reply has waited on its own call.
+	for i := len(calls) - 1; i >= 0; i-- {
+		out, err := calls[i].Recv()
+		if err != nil || string(*out) != string(rune('a'+i)) {
+			t.Errorf("call %d Recv = %q, %v", calls[i].CallId(), *out, err)
+		}
+	}
+	for _, call := range calls {
+		call.CloseSend()
+		if _, err := call.Recv(); err != io.EOF {
+			t.Errorf("call %d Recv = %v, want EOF", call.CallId(), err)
+		}
+	}
+}
+
+func TestGeneratedClient(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), kConformanceTimeout)
+	defer cancel()
+
+	c, tap := tappedClient(t, ctx)
+	client := cmdpb.NewBenchmarkPwrpcClient(c)
+
+	unary, err := client.UnaryEcho(ctx, &cmdpb.Payload{Payload: []byte("hello")})
+	if err != nil || string(unary.Response.Payload) != "hello" {
+		t.Fatalf("UnaryEcho = %v, %v", unary.Response, err)
+	}
+	if unary.ServiceId() != cmdpb.Benchmark_ServiceId || unary.MethodId() != cmdpb.Benchmark_UnaryEcho_MethodId {
+		t.Errorf("UnaryEcho ids = %#08x/%#08x", unary.ServiceId(), unary.MethodId())
+	}
+	checkWire(t, tap, unary.Call)
+
+	bidi, err := client.BidirectionalEcho(ctx, pw_rpc.WithChannelId(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bidi.Send(&cmdpb.Payload{Payload: []byte("ping")}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := bidi.Recv()
+	if err != nil || string(out.Payload) != "ping" {
+		t.Fatalf("Recv = %v, %v", out, err)
+	}
+	bidi.CloseSend()
+	if _, err := bidi.Recv(); err != io.EOF {
+		t.Fatalf("Recv = %v, want EOF", err)
+	}
+	if bidi.MethodId() != cmdpb.Benchmark_BidirectionalEcho_MethodId || bidi.ChannelId() != 2 {
+		t.Errorf("BidirectionalEcho method %#08x on channel %d", bidi.MethodId(), bidi.ChannelId())
+	}
+	checkWire(t, tap, bidi.Call)
+}
@@ -0,0 +1,597 @@
+package pw_rpc_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	cmdpb "github.com/robertfarnum/go-pw-rpc/cmd/pb"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_hdlc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
+	unittestpb "github.com/robertfarnum/go-pw-rpc/pkg/pw_unit_test/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	kRpcAddress          = 'R'
+	kConformanceService  = "pw.test.Conformance"
+	kConformanceTimeout  = 5 * time.Second
+	kConformanceFailWord = "fail"
+)
+
+// kGoldenHashes are 65599 hashes from pw_tokenizer's tests, followed by the
+// ids Pigweed's pw_rpc.ids.calculate gives real service and method names.
+// Names are hashed byte by byte, so bytes above 0x7f are not decoded as UTF-8.
+var kGoldenHashes = []struct {
+	name string
+	key  pw_rpc.Key
+}{
+	{"", 0},
+	{"a", 6363104},
+	{"A", 4263936},
+	{"\xa1", 10561440},
+	{"\xff", 16727746},
+	{"pw.rpc.Benchmark", 0xd7d70c1d},
+	{"UnaryEcho", 0x024e8b55},
+	{"BidirectionalEcho", 0x651fd4a9},
+	{"pw.rpc.EchoService", 0x14fbd052},
+	{"Echo", 0x8b470ee9},
+	{"pw.unit_test.UnitTest", 0xb19fa8d7},
+	{"Run", 0x37dcdc38},
+}
+
+func TestGoldenHashes(t *testing.T) {
+	for _, golden := range kGoldenHashes {
+		if key := pw_rpc.NewKey(golden.name); key != golden.key {
+			t.Errorf("NewKey(%q) = %#08x, want %#08x", golden.name, key, golden.key)
+		}
+	}
+
+	// The generated ids must agree with the hash used at run time.
+	generated := map[string]pw_rpc.Key{
+		"pw.rpc.Benchmark":      cmdpb.Benchmark_ServiceId,
+		"UnaryEcho":             cmdpb.Benchmark_UnaryEcho_MethodId,
+		"BidirectionalEcho":     cmdpb.Benchmark_BidirectionalEcho_MethodId,
+		"pw.unit_test.UnitTest": unittestpb.UnitTest_ServiceId,
+		"Run":                   unittestpb.UnitTest_Run_MethodId,
+	}
+	for name, key := range generated {
+		if got := pw_rpc.NewKey(name); got != key {
+			t.Errorf("NewKey(%q) = %#08x, generated %#08x", name, got, key)
+		}
+	}
+}
+
+// kGoldenPackets are RpcPackets as Pigweed's encoders put them on the wire.
+var kGoldenPackets = []struct {
+	name    string
+	packet  *pb.RpcPacket
+	encoded []byte
+}{
+	{
+		"request",
+		&pb.RpcPacket{Type: pb.PacketType_REQUEST, ChannelId: 1, ServiceId: 42, MethodId: 100, Payload: []byte("hello"), CallId: 7},
+		[]byte{0x10, 0x01, 0x1d, 0x2a, 0x00, 0x00, 0x00, 0x25, 0x64, 0x00, 0x00, 0x00, 0x2a, 0x05, 'h', 'e', 'l', 'l', 'o', 0x38, 0x07},
+	},
+	{
+		"response",
+		&pb.RpcPacket{Type: pb.PacketType_RESPONSE, ChannelId: 1, ServiceId: 42, MethodId: 100, Payload: []byte("hello"), CallId: 7},
+		[]byte{0x08, 0x01, 0x10, 0x01, 0x1d, 0x2a, 0x00, 0x00, 0x00, 0x25, 0x64, 0x00, 0x00, 0x00, 0x2a, 0x05, 'h', 'e', 'l', 'l', 'o', 0x38, 0x07},
+	},
+	{
+		"server error",
+		&pb.RpcPacket{Type: pb.PacketType_SERVER_ERROR, ChannelId: 1, ServiceId: 42, MethodId: 100, Status: uint32(pb.StatusCode_NOT_FOUND), CallId: 7},
+		[]byte{0x08, 0x05, 0x10, 0x01, 0x1d, 0x2a, 0x00, 0x00, 0x00, 0x25, 0x64, 0x00, 0x00, 0x00, 0x30, 0x05, 0x38, 0x07},
+	},
+	{
+		"client error",
+		&pb.RpcPacket{Type: pb.PacketType_CLIENT_ERROR, ChannelId: 2, ServiceId: 0xd7d70c1d, MethodId: 0x024e8b55, Status: uint32(pb.StatusCode_CANCELLED), CallId: 300},
+		[]byte{0x08, 0x04, 0x10, 0x02, 0x1d, 0x1d, 0x0c, 0xd7, 0xd7, 0x25, 0x55, 0x8b, 0x4e, 0x02, 0x30, 0x01, 0x38, 0xac, 0x02},
+	},
+	{
+		"client request completion",
+		&pb.RpcPacket{Type: pb.PacketType_CLIENT_REQUEST_COMPLETION, ChannelId: 1, ServiceId: 42, MethodId: 100, CallId: 1},
+		[]byte{0x08, 0x08, 0x10, 0x01, 0x1d, 0x2a, 0x00, 0x00, 0x00, 0x25, 0x64, 0x00, 0x00, 0x00, 0x38, 0x01},
+	},
+}
+
+func TestGoldenPackets(t *testing.T) {
+	for _, golden := range kGoldenPackets {
+		encoded, err := proto.MarshalOptions{Deterministic: true}.Marshal(golden.packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encoded, golden.encoded) {
+			t.Errorf("%s: Marshal() = %x, want %x", golden.name, encoded, golden.encoded)
+		}
+
+		decoded := &pb.RpcPacket{}
+		if err := proto.Unmarshal(golden.encoded, decoded); err != nil {
+			t.Fatalf("%s: %s", golden.name, err)
+		}
+		if !proto.Equal(decoded, golden.packet) {
+			t.Errorf("%s: Unmarshal() = %v, want %v", golden.name, decoded, golden.packet)
+		}
+	}
+
+	// Pigweed's C++ encoder writes every field, even those that are zero.
+	explicit := []byte{0x08, 0x00, 0x10, 0x01, 0x1d, 0x2a, 0x00, 0x00, 0x00, 0x25, 0x64, 0x00, 0x00, 0x00, 0x2a, 0x00, 0x30, 0x00, 0x38, 0x07}
+	decoded := &pb.RpcPacket{}
+	if err := proto.Unmarshal(explicit, decoded); err != nil {
+		t.Fatal(err)
+	}
+	want := &pb.RpcPacket{Type: pb.PacketType_REQUEST, ChannelId: 1, ServiceId: 42, MethodId: 100, CallId: 7}
+	if !proto.Equal(decoded, want) {
+		t.Errorf("Unmarshal(%x) = %v, want %v", explicit, decoded, want)
+	}
+}
+
+// conformanceStream echoes each message until the client stream ends.
+func conformanceStream(srv any, stream grpc.ServerStream) error {
+	for {
+		in := pw_rpc.RawMessage{}
+		if err := stream.RecvMsg(&in); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := stream.SendMsg(in); err != nil {
+			return err
+		}
+	}
+}
+
+// kConformanceServiceDesc has a method of each kind. A request of "fail"
+// ends the call with INVALID_ARGUMENT.
+var kConformanceServiceDesc = grpc.ServiceDesc{
+	ServiceName: kConformanceService,
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Unary",
+		Handler: func(srv any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+			in := pw_rpc.RawMessage{}
+			if err := dec(&in); err != nil {
+				return nil, err
+			}
+			if string(in) == kConformanceFailWord {
+				return nil, pw_rpc.StatusError(pb.StatusCode_INVALID_ARGUMENT)
+			}
+			return in, nil
+		},
+	}},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ServerStream",
+			ServerStreams: true,
+			// Sends each byte of the request as a message.
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				in := pw_rpc.RawMessage{}
+				if err := stream.RecvMsg(&in); err != nil {
+					return err
+				}
+				if string(in) == kConformanceFailWord {
+					return pw_rpc.StatusError(pb.StatusCode_INVALID_ARGUMENT)
+				}
+				for _, b := range in {
+					if err := stream.SendMsg(pw_rpc.RawMessage{b}); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			StreamName:    "ClientStream",
+			ClientStreams: true,
+			// Responds with the messages concatenated.
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				var all pw_rpc.RawMessage
+				for {
+					in := pw_rpc.RawMessage{}
+					if err := stream.RecvMsg(&in); err == io.EOF {
+						return stream.SendMsg(all)
+					} else if err != nil {
+						return err
+					}
+					all = append(all, in...)
+				}
+			},
+		},
+		{StreamName: "Bidi", ServerStreams: true, ClientStreams: true, Handler: conformanceStream},
+	},
+}
+
+// step is a packet of a call, sent by the host (the client) or by the device
+// (the server).
+type step struct {
+	fromHost bool
+	packet   *pb.RpcPacket
+}
+
+func packet(fromHost bool, pt pb.PacketType, method string, payload string, status pb.StatusCode) step {
+	return step{
+		fromHost: fromHost,
+		packet: &pb.RpcPacket{
+			Type:      pt,
+			ChannelId: 1,
+			ServiceId: uint32(pw_rpc.NewKey(kConformanceService)),
+			MethodId:  uint32(pw_rpc.NewKey(method)),
+			Payload:   []byte(payload),
+			Status:    uint32(status),
+			CallId:    1,
+		},
+	}
+}
+
+func host(pt pb.PacketType, method string, payload string, status pb.StatusCode) step {
+	return packet(true, pt, method, payload, status)
+}
+
+func device(pt pb.PacketType, method string, payload string, status pb.StatusCode) step {
+	return packet(false, pt, method, payload, status)
+}
+
+var (
+	kServerStreamDesc = &grpc.StreamDesc{StreamName: "ServerStream", ServerStreams: true}
+	kClientStreamDesc = &grpc.StreamDesc{StreamName: "ClientStream", ClientStreams: true}
+	kBidiDesc         = &grpc.StreamDesc{StreamName: "Bidi", ServerStreams: true, ClientStreams: true}
+)
+
+// kConformanceCalls are call sequences on the wire, with the client calls
+// that produce them. Each call returns the messages it received.
+var kConformanceCalls = []struct {
+	name   string
+	script []step
+	call   func(ctx context.Context, c pw_rpc.Client) ([]string, error)
+	want   []string
+	code   pb.StatusCode
+}{
+	{
+		name: "unary",
+		script: []step{
+			host(pb.PacketType_REQUEST, "Unary", "hello", pb.StatusCode_OK),
+			device(pb.PacketType_RESPONSE, "Unary", "hello", pb.StatusCode_OK),
+		},
+		call: func(ctx context.Context, c pw_rpc.Client) ([]string, error) {
+			return invoke(ctx, c, "Unary", "hello")
+		},
+		want: []string{"hello"},
+	},
+	{
+		name: "unary error",
+		script: []step{
+			host(pb.PacketType_REQUEST, "Unary", kConformanceFailWord, pb.StatusCode_OK),
+			device(pb.PacketType_RESPONSE, "Unary", "", pb.StatusCode_INVALID_ARGUMENT),
+		},
+		call: func(ctx context.Context, c pw_rpc.Client) ([]string, error) {
+			return invoke(ctx, c, "Unary", kConformanceFailWord)
+		},
+		want: []string{""},
+		code: pb.StatusCode_INVALID_ARGUMENT,
+	},
+	{
+		name: "unknown method",
+		script: []step{
+			host(pb.PacketType_REQUEST, "Missing", "hello", pb.StatusCode_OK),
+			device(pb.PacketType_SERVER_ERROR, "Missing", "", pb.StatusCode_NOT_FOUND),
+		},
+		call: func(ctx context.Context, c pw_rpc.Client) ([]string, error) {
+			return invoke(ctx, c, "Missing", "hello")
+		},
+		want: []string{""},
+		code: pb.StatusCode_NOT_FOUND,
+	},
+	{
+		name: "server stream",
+		script: []step{
+			host(pb.PacketType_REQUEST, "ServerStream", "abc", pb.StatusCode_OK),
+			device(pb.PacketType_SERVER_STREAM, "ServerStream", "a", pb.StatusCode_OK),
+			device(pb.PacketType_SERVER_STREAM, "ServerStream", "b", pb.StatusCode_OK),
+			device(pb.PacketType_SERVER_STREAM, "ServerStream", "c", pb.StatusCode_OK),
+			device(pb.PacketType_RESPONSE, "ServerStream", "", pb.StatusCode_OK),
+		},
+		call: func(ctx context.Context, c pw_rpc.Client) ([]string, error) {
+			cs, err := c.NewStream(ctx, kServerStreamDesc, method("ServerStream"), pw_rpc.WithCallId(1))
+			if err != nil {
+				return nil, err
+			}
+			if err := cs.SendMsg(pw_rpc.RawMessage("abc")); err != nil {
+				return nil, err
+			}
+			return recvAll(cs)
+		},
+		want: []string{"a", "b", "c"},
+	},
+	{
+		name: "server stream error",
+		script: []step{
+			host(pb.PacketType_REQUEST, "ServerStream", kConformanceFailWord, pb.StatusCode_OK),
+			device(pb.PacketType_RESPONSE, "ServerStream", "", pb.StatusCode_INVALID_ARGUMENT),
+		},
+		call: func(ctx context.Context, c pw_rpc.Client) ([]string, error) {
+			cs, err := c.NewStream(ctx, kServerStreamDesc, method("ServerStream"), pw_rpc.WithCallId(1))
+			if err != nil {
+				return nil, err
+			}
+			if err := cs.SendMsg(pw_rpc.RawMessage(kConformanceFailWord)); err != nil {
+				return nil, err
+			}
+			return recvAll(cs)
+		},
+		code: pb.StatusCode_INVALID_ARGUMENT,
+	},
+	{
+		name: "client stream",
+		script: []step{
+			host(pb.PacketType_REQUEST, "ClientStream", "", pb.StatusCode_OK),
+			host(pb.PacketType_CLIENT_STREAM, "ClientStream", "ab", pb.StatusCode_OK),
+			host(pb.PacketType_CLIENT_STREAM, "ClientStream", "cd", pb.StatusCode_OK),
+			host(pb.PacketType_CLIENT_REQUEST_COMPLETION, "ClientStream", "", pb.StatusCode_OK),
+			device(pb.PacketType_RESPONSE, "ClientStream", "abcd", pb.StatusCode_OK),
+		},
+		call: func(ctx context.Context, c pw_rpc.Client) ([]string, error) {
+			cs, err := c.NewStream(ctx, kClientStreamDesc, method("ClientStream"), pw_rpc.WithCallId(1))
+			if err != nil {
+				return nil, err
+			}
+			for _, m := range []string{"ab", "cd"} {
+				if err := cs.SendMsg(pw_rpc.RawMessage(m)); err != nil {
+					return nil, err
+				}
+			}
+			if err := cs.CloseSend(); err != nil {
+				return nil, err
+			}
+			reply := pw_rpc.RawMessage{}
+			err = cs.RecvMsg(&reply)
+			return []string{string(reply)}, err
+		},
+		want: []string{"abcd"},
+	},
+	{
+		name: "bidi",
+		script: []step{
+			host(pb.PacketType_REQUEST, "Bidi", "", pb.StatusCode_OK),
+			host(pb.PacketType_CLIENT_STREAM, "Bidi", "x", pb.StatusCode_OK),
+			device(pb.PacketType_SERVER_STREAM, "Bidi", "x", pb.StatusCode_OK),
+			host(pb.PacketType_CLIENT_STREAM, "Bidi", "y", pb.StatusCode_OK),
+			device(pb.PacketType_SERVER_STREAM, "Bidi", "y", pb.StatusCode_OK),
+			host(pb.PacketType_CLIENT_REQUEST_COMPLETION, "Bidi", "", pb.StatusCode_OK),
+			device(pb.PacketType_RESPONSE, "Bidi", "", pb.StatusCode_OK),
+		},
+		call: func(ctx context.Context, c pw_rpc.Client) ([]string, error) {
+			cs, err := c.NewStream(ctx, kBidiDesc, method("Bidi"), pw_rpc.WithCallId(1))
+			if err != nil {
+				return nil, err
+			}
+			var got []string
+			for _, m := range []string{"x", "y"} {
+				if err := cs.SendMsg(pw_rpc.RawMessage(m)); err != nil {
+					return got, err
+				}
+				reply := pw_rpc.RawMessage{}
+				if err := cs.RecvMsg(&reply); err != nil {
+					return got, err
+				}
+				got = append(got, string(reply))
+			}
+			if err := cs.CloseSend(); err != nil {
+				return got, err
+			}
+			more, err := recvAll(cs)
+			return append(got, more...), err
+		},
+		want: []string{"x", "y"},
+	},
+	{
+		name: "cancel",
+		script: []step{
+			host(pb.PacketType_REQUEST, "Bidi", "", pb.StatusCode_OK),
+			host(pb.PacketType_CLIENT_STREAM, "Bidi", "x", pb.StatusCode_OK),
+			device(pb.PacketType_SERVER_STREAM, "Bidi", "x", pb.StatusCode_OK),
+			host(pb.PacketType_CLIENT_ERROR, "Bidi", "", pb.StatusCode_CANCELLED),
+		},
+		call: func(ctx context.Context, c pw_rpc.Client) ([]string, error) {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			cs, err := c.NewStream(ctx, kBidiDesc, method("Bidi"), pw_rpc.WithCallId(1))
+			if err != nil {
+				return nil, err
+			}
+			if err := cs.SendMsg(pw_rpc.RawMessage("x")); err != nil {
+				return nil, err
+			}
+			reply := pw_rpc.RawMessage{}
+			if err := cs.RecvMsg(&reply); err != nil {
+				return nil, err
+			}
+			cancel()
+			more, err := recvAll(cs)
+			return append([]string{string(reply)}, more...), err
+		},
+		want: []string{"x"},
+		code: pb.StatusCode_CANCELLED,
+	},
+}
+
+func method(name string) string {
+	return fmt.Sprintf("/%s/%s", kConformanceService, name)
+}
+
+func invoke(ctx context.Context, c pw_rpc.Client, name string, request string) ([]string, error) {
+	reply := pw_rpc.RawMessage{}
+	err := c.Invoke(ctx, method(name), pw_rpc.RawMessage(request), &reply, pw_rpc.WithCallId(1))
+	return []string{string(reply)}, err
+}
+
+func recvAll(cs grpc.ClientStream) ([]string, error) {
+	var got []string
+	for {
+		reply := pw_rpc.RawMessage{}
+		if err := cs.RecvMsg(&reply); err == io.EOF {
+			return got, nil
+		} else if err != nil {
+			return got, err
+		}
+		got = append(got, string(reply))
+	}
+}
+
+// wire reads and writes RpcPackets in HDLC frames.
+type wire struct {
+	conn    net.Conn
+	encoder pw_hdlc.Encoder
+	decoder pw_hdlc.Decoder
+}
+
+func newWire(conn net.Conn) *wire {
+	conn.SetDeadline(time.Now().Add(kConformanceTimeout))
+
+	return &wire{
+		conn:    conn,
+		encoder: pw_hdlc.NewEncoder(conn, kRpcAddress),
+		decoder: pw_hdlc.NewDecoder(conn, kRpcAddress),
+	}
+}
+
+func (w *wire) send(packet *pb.RpcPacket) error {
+	buf, err := proto.Marshal(packet)
+	if err != nil {
+		return err
+	}
+
+	return w.encoder.Encode(buf)
+}
+
+func (w *wire) recv() (*pb.RpcPacket, error) {
+	frame, err := w.decoder.Decode(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	packet := &pb.RpcPacket{}
+	return packet, proto.Unmarshal(frame.Payload(), packet)
+}
+
+// expect reads the next packet and checks that it is want.
+func (w *wire) expect(want *pb.RpcPacket) error {
+	got, err := w.recv()
+	if err != nil {
+		return fmt.Errorf("want %s: %w", pw_rpc.FormatPacket(want), err)
+	}
+
+	if !proto.Equal(got, want) {
+		return fmt.Errorf("got %s, want %s", pw_rpc.FormatPacket(got), pw_rpc.FormatPacket(want))
+	}
+
+	return nil
+}
+
+// TestServerConformance plays the host side of each call against the server.
+func TestServerConformance(t *testing.T) {
+	for _, tc := range kConformanceCalls {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			s := pw_rpc.NewServer("")
+			if err := s.Register(&kConformanceServiceDesc, struct{}{}); err != nil {
+				t.Fatal(err)
+			}
+
+			hostConn, deviceConn := net.Pipe()
+			defer hostConn.Close()
+			go s.Serve(ctx, deviceConn)
+
+			w := newWire(hostConn)
+			for i, step := range tc.script {
+				var err error
+				if step.fromHost {
+					err = w.send(step.packet)
+				} else {
+					err = w.expect(step.packet)
+				}
+				if err != nil {
+					t.Fatalf("step %d: %s", i, err)
+				}
+			}
+
+			// A following call must be answered first: the server sent
+			// nothing more for the scripted one.
+			probe := host(pb.PacketType_REQUEST, "Unary", "probe", pb.StatusCode_OK).packet
+			probe.CallId = 2
+			if err := w.send(probe); err != nil {
+				t.Fatal(err)
+			}
+
+			response := device(pb.PacketType_RESPONSE, "Unary", "probe", pb.StatusCode_OK).packet
+			response.CallId = 2
+			if err := w.expect(response); err != nil {
+				t.Fatalf("after script: %s", err)
+			}
+		})
+	}
+}
+
+// TestClientConformance plays the device side of each call against the
+// client.
+func TestClientConformance(t *testing.T) {
+	for _, tc := range kConformanceCalls {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), kConformanceTimeout)
+			defer cancel()
+
+			hostConn, deviceConn := net.Pipe()
+			c := pw_rpc.NewClientWithDialer(func(context.Context) (io.ReadWriteCloser, error) {
+				return hostConn, nil
+			})
+
+			done := make(chan error, 1)
+			go func() {
+				w := newWire(deviceConn)
+				for i, step := range tc.script {
+					var err error
+					if step.fromHost {
+						err = w.expect(step.packet)
+					} else {
+						err = w.send(step.packet)
+					}
+					if err != nil {
+						done <- fmt.Errorf("step %d: %w", i, err)
+						return
+					}
+				}
+
+				// Nothing more may be sent until the client is closed.
+				if extra, err := w.recv(); err == nil {
+					done <- fmt.Errorf("unexpected %s", pw_rpc.FormatPacket(extra))
+					return
+				}
+				done <- nil
+			}()
+
+			got, err := tc.call(ctx, c)
+			c.Close()
+
+			if err := <-done; err != nil {
+				t.Fatalf("device: %s", err)
+			}
+
+			if code := pw_rpc.StatusCode(err); code != tc.code {
+				t.Errorf("call returned %v, want %s", err, tc.code)
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("call received %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package pw_rpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+)
+
+const (
+	// kMaxDatagramSize is the largest UDP payload.
+	kMaxDatagramSize = 65535
+	// kPeerQueueSize is the number of datagrams queued for a peer before
+	// more are dropped, as a socket buffer would.
+	kPeerQueueSize = 64
+)
+
+// NewDatagramConn returns a Conn that sends each packet as one datagram and
+// handles each datagram read as one packet, without HDLC framing. Every Read
+// of rwc must return a whole datagram and every Write must send one, as on a
+// connected UDP socket.
+func NewDatagramConn(rwc io.ReadWriteCloser, ph PacketHandler) Conn {
+	return NewPacketConn(DatagramFraming.NewPacketIO(rwc, ph), ph)
+}
+
+// udpTransport carries one packet per UDP datagram.
+type udpTransport struct{}
+
+func (udpTransport) Dial(ctx context.Context, endpoint *url.URL) (io.ReadWriteCloser, error) {
+	if endpoint.Host == "" {
+		return nil, fmt.Errorf("%w: no address in %q", ErrBadEndpoint, endpoint)
+	}
+
+	var d net.Dialer
+
+	return d.DialContext(ctx, "udp", endpoint.Host)
+}
+
+// Listen serves every peer that sends to the socket as a connection of its
+// own, accepted when its first datagram arrives.
+func (udpTransport) Listen(ctx context.Context, endpoint *url.URL) (Listener, error) {
+	if endpoint.Host == "" {
+		return nil, fmt.Errorf("%w: no address in %q", ErrBadEndpoint, endpoint)
+	}
+
+	var lc net.ListenConfig
+
+	pc, err := lc.ListenPacket(ctx, "udp", endpoint.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &udpListener{
+		pc:     pc,
+		peers:  map[string]*udpPeer{},
+		accept: make(chan *udpPeer),
+		closed: make(chan struct{}),
+	}
+
+	go l.read()
+
+	return l, nil
+}
+
+// udpListener demultiplexes the datagrams of a socket by peer address.
+type udpListener struct {
+	pc     net.PacketConn
+	peers  map[string]*udpPeer
+	accept chan *udpPeer
+	closed chan struct{}
+	once   sync.Once
+	mu     sync.Mutex
+}
+
+func (l *udpListener) read() {
+	defer l.Close()
+
+	buf := make([]byte, kMaxDatagramSize)
+
+	for {
+		n, addr, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		peer, ok := l.peer(addr)
+		if !ok {
+			select {
+			case l.accept <- peer:
+			case <-l.closed:
+				return
+			}
+		}
+
+		select {
+		case peer.in <- append([]byte(nil), buf[:n]...):
+		default:
+		}
+	}
+}
+
+// peer returns the peer at addr, adding it if it is new.
+func (l *udpListener) peer(addr net.Addr) (*udpPeer, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if peer, ok := l.peers[addr.String()]; ok {
+		return peer, true
+	}
+
+	peer := &udpPeer{
+		l:      l,
+		addr:   addr,
+		in:     make(chan []byte, kPeerQueueSize),
+		closed: make(chan struct{}),
+	}
+	l.peers[addr.String()] = peer
+
+	return peer, false
+}
+
+func (l *udpListener) Accept() (io.ReadWriteCloser, error) {
+	select {
+	case peer := <-l.accept:
+		return peer, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the socket, which also ends the connections of its peers.
+func (l *udpListener) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.closed)
+		err = l.pc.Close()
+	})
+
+	return err
+}
+
+func (l *udpListener) Addr() string {
+	return l.pc.LocalAddr().String()
+}
+
+// udpPeer is the connection of one peer of a udpListener. Once it is closed,
+// the next datagram from the peer is accepted as a new connection.
+type udpPeer struct {
+	l      *udpListener
+	addr   net.Addr
+	in     chan []byte
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (p *udpPeer) Read(b []byte) (int, error) {
+	select {
+	case datagram := <-p.in:
+		return copy(b, datagram), nil
+	case <-p.closed:
+		return 0, net.ErrClosed
+	case <-p.l.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (p *udpPeer) Write(b []byte) (int, error) {
+	return p.l.pc.WriteTo(b, p.addr)
+}
+
+func (p *udpPeer) Close() error {
+	p.once.Do(func() {
+		close(p.closed)
+
+		p.l.mu.Lock()
+		if p.l.peers[p.addr.String()] == p {
+			delete(p.l.peers, p.addr.String())
+		}
+		p.l.mu.Unlock()
+	})
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package pw_rpc_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+// serveUdp serves the conformance service on a UDP socket, as Server.Listen
+// does, and returns the socket's address.
+func serveUdp(t *testing.T, ctx context.Context) string {
+	t.Helper()
+
+	s := pw_rpc.NewServer("")
+	if err := s.Register(&kConformanceServiceDesc, struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	lis, err := pw_rpc.Listen(ctx, "udp://127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go s.Serve(ctx, conn)
+		}
+	}()
+
+	return lis.Addr()
+}
+
+func TestUdpDatagrams(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	address := serveUdp(t, ctx)
+
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// A request with HDLC flag bytes is sent as one bare packet.
+	request := &pb.RpcPacket{
+		Type:      pb.PacketType_REQUEST,
+		ChannelId: 1,
+		ServiceId: uint32(pw_rpc.NewKey(kConformanceService)),
+		MethodId:  uint32(pw_rpc.NewKey("Unary")),
+		Payload:   []byte{0x7e, 0x7d, 0x7e},
+		CallId:    7,
+	}
+	buf, err := proto.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	datagram := make([]byte, 1500)
+	n, err := conn.Read(datagram)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := &pb.RpcPacket{}
+	if err := proto.Unmarshal(datagram[:n], response); err != nil {
+		t.Fatalf("datagram % x is not a packet: %s", datagram[:n], err)
+	}
+
+	want := proto.Clone(request).(*pb.RpcPacket)
+	want.Type = pb.PacketType_RESPONSE
+	if !proto.Equal(response, want) {
+		t.Errorf("response = %v, want %v", response, want)
+	}
+}
+
+func TestUdpPeers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	address := serveUdp(t, ctx)
+
+	// Each peer's calls use the same call ids, so only the peer address
+	// tells the responses apart.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			c := pw_rpc.NewClient("udp://" + address)
+			defer c.Close()
+
+			for j := 0; j < 10; j++ {
+				request := fmt.Sprintf("peer %d call %d", i, j)
+
+				reply := pw_rpc.RawMessage{}
+				if err := c.Invoke(ctx, method("Unary"), pw_rpc.RawMessage(request), &reply, pw_rpc.WithCallId(1)); err != nil {
+					t.Error(err)
+					return
+				}
+				if string(reply) != request {
+					t.Errorf("reply = %q, want %q", reply, request)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestUdpServerStream(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c := pw_rpc.NewClient("udp://" + serveUdp(t, ctx))
+	defer c.Close()
+
+	stream, err := c.NewStream(ctx, kServerStreamDesc, method("ServerStream"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.SendMsg(pw_rpc.RawMessage("xyz")); err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	for {
+		msg := pw_rpc.RawMessage{}
+		if err := stream.RecvMsg(&msg); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got += string(msg)
+	}
+	if got != "xyz" {
+		t.Errorf("server stream = %q, want %q", got, "xyz")
+	}
+}
+
+func TestUdpPacketTooLarge(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c := pw_rpc.NewClient("udp://" + serveUdp(t, ctx))
+	defer c.Close()
+
+	err := c.Invoke(ctx, method("Unary"), make(pw_rpc.RawMessage, 70000), &pw_rpc.RawMessage{})
+	if !errors.Is(err, pw_rpc.ErrPacketTooLarge) {
+		t.Errorf("Invoke = %v, want %v", err, pw_rpc.ErrPacketTooLarge)
+	}
+}
@@ -1,8 +1,33 @@
 package pw_rpc
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
 
 var (
-	ErrCancelled  = errors.New("cancelled")
-	ErrBadAddress = errors.New("bad address")
+	ErrCancelled        = errors.New("cancelled")
+	ErrBadAddress       = errors.New("bad address")
+	ErrDuplicateService = errors.New("duplicate service registration")
+	ErrIdCollision      = errors.New("id collision")
+	ErrPacketTooLarge   = errors.New("packet too large")
 )
+
+// StatusError converts a pw_rpc status code into a gRPC status error. The
+// Pigweed status codes share their values with gRPC codes. OK maps to nil.
+func StatusError(code pb.StatusCode) error {
+	if code == pb.StatusCode_OK {
+		return nil
+	}
+
+	return status.Error(codes.Code(code), code.String())
+}
+
+// StatusCode returns the pw_rpc status code for err, which is OK for nil,
+// the code of a gRPC status error and UNKNOWN otherwise.
+func StatusCode(err error) pb.StatusCode {
+	return pb.StatusCode(status.Code(err))
+}
@@ -0,0 +1,377 @@
+package pw_rpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_cobs"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_hdlc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// kMaxPacketSize bounds the packets of framings that carry a length, so
+	// that a corrupted length does not allocate without limit.
+	kMaxPacketSize = 1 << 20
+)
+
+// PacketIO sends and receives the encoded RpcPackets of a connection in some
+// framing.
+type PacketIO interface {
+	// ReadPacket returns the next packet. Input that is not a packet, such
+	// as a corrupted frame, is skipped; an error ends the connection.
+	ReadPacket(ctx context.Context) ([]byte, error)
+	WritePacket(ctx context.Context, packet []byte) error
+	Close() error
+}
+
+// FrameTransport frames the packets sent over connections.
+type FrameTransport interface {
+	// NewPacketIO frames the packets of rwc. Input that is not for RPC, such
+	// as HDLC log frames, goes to ph if it is a LogHandler or Tap.
+	NewPacketIO(rwc io.ReadWriteCloser, ph PacketHandler) PacketIO
+}
+
+var (
+	// HdlcFraming sends packets in HDLC UI frames on the RPC address, as
+	// Pigweed devices do on serial lines.
+	HdlcFraming FrameTransport = hdlcFraming{}
+	// LengthPrefixedFraming precedes each packet with its length as a
+	// protobuf varint, for reliable streams that need no error detection.
+	LengthPrefixedFraming FrameTransport = lengthPrefixedFraming{}
+	// DatagramFraming sends each packet with one Write and reads one with
+	// each Read, for links that keep message boundaries, such as UDP.
+	DatagramFraming FrameTransport = datagramFraming{}
+)
+
+// defaultFraming is the framing of rwc when none is set: one packet per
+// datagram on UDP sockets and HDLC otherwise.
+func defaultFraming(rwc io.ReadWriteCloser) FrameTransport {
+	switch rwc.(type) {
+	case *net.UDPConn, *udpPeer:
+		return DatagramFraming
+	}
+
+	return HdlcFraming
+}
+
+// newConn connects ph to rwc in framing, or in the default framing of rwc
+// if it is nil.
+func newConn(rwc io.ReadWriteCloser, framing FrameTransport, ph PacketHandler) Conn {
+	if framing == nil {
+		framing = defaultFraming(rwc)
+	}
+
+	return NewPacketConn(framing.NewPacketIO(rwc, ph), ph)
+}
+
+type hdlcFraming struct{}
+
+func (hdlcFraming) NewPacketIO(rwc io.ReadWriteCloser, ph PacketHandler) PacketIO {
+	return &hdlcIO{
+		closer:  rwc,
+		encoder: pw_hdlc.NewEncoder(rwc, uint64(kDefaultRpcAddress)),
+		decoder: pw_hdlc.NewDecoder(rwc, uint64(kDefaultRpcAddress)),
+		ph:      ph,
+	}
+}
+
+// NewReliableHdlcFraming returns a framing that sends packets in the
+// I-frames of a reliable HDLC link, see pw_hdlc.Link, so that packets lost
+// on a noisy line are sent again rather than failing their calls. The
+// link's address is the RPC address; UI and log frames are still accepted.
+// The Tap sees every frame of the link, including retransmissions.
+func NewReliableHdlcFraming(config pw_hdlc.LinkConfig) FrameTransport {
+	config.Address = uint64(kDefaultRpcAddress)
+
+	return reliableHdlcFraming{config: config}
+}
+
+type reliableHdlcFraming struct {
+	config pw_hdlc.LinkConfig
+}
+
+func (f reliableHdlcFraming) NewPacketIO(rwc io.ReadWriteCloser, ph PacketHandler) PacketIO {
+	h := &hdlcIO{
+		ph:     ph,
+		linked: true,
+	}
+
+	config := f.config
+	config.Hook = func(outbound bool, frame *pw_hdlc.Frame) {
+		if outbound {
+			h.capture(Outbound, frame)
+		} else {
+			h.capture(Inbound, frame)
+		}
+	}
+
+	link := pw_hdlc.NewLink(rwc, config)
+	h.closer, h.encoder, h.decoder = link, link, link
+
+	return h
+}
+
+type hdlcIO struct {
+	closer  io.Closer
+	encoder pw_hdlc.Encoder
+	decoder pw_hdlc.Decoder
+	ph      PacketHandler
+	// linked is set when a pw_hdlc.Link shows its frames to the Tap, as
+	// only it knows the frames it sends.
+	linked bool
+	once   sync.Once
+}
+
+// capture shows a frame read or written to the handler's Tap, with the RPC
+// packet it carries, if any.
+func (h *hdlcIO) capture(direction Direction, frame *pw_hdlc.Frame) {
+	tap, ok := h.ph.(Tap)
+	if !ok {
+		return
+	}
+
+	var packet *pb.RpcPacket
+	if frame.Address() == uint64(kDefaultRpcAddress) && carriesData(frame.ControlField()) {
+		packet = &pb.RpcPacket{}
+		if err := proto.Unmarshal(frame.Payload(), packet); err != nil {
+			packet = nil
+		}
+	}
+
+	tap.Capture(time.Now(), direction, frame, packet)
+}
+
+// ReadPacket returns the payload of the next information frame, I or UI, on
+// the RPC address. Corrupted frames and frames on other addresses are
+// dropped, and the frames on the log address go to the LogHandler.
+// Every frame read is shown to the Tap, including those that manage a link.
+func (h *hdlcIO) ReadPacket(ctx context.Context) ([]byte, error) {
+	for {
+		frame, err := h.decoder.Decode(ctx)
+		if errors.Is(err, pw_hdlc.ErrDataLoss) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if frame == nil {
+			return nil, fmt.Errorf("no frame")
+		}
+
+		if !h.linked {
+			h.capture(Inbound, frame)
+		}
+
+		switch frame.Address() {
+		case uint64(kDefaultRpcAddress):
+			if carriesData(frame.ControlField()) {
+				return frame.Payload(), nil
+			}
+		case uint64(kDefaultLogAddress):
+			if lh, ok := h.ph.(LogHandler); ok {
+				lh.HandleLog(ctx, frame.Payload())
+				break
+			}
+
+			fmt.Fprintf(os.Stderr, "Pigweed Log: %s\n", string(frame.Payload()))
+		}
+	}
+}
+
+// carriesData reports whether frames with control c carry data rather than
+// manage the link.
+func carriesData(c pw_hdlc.Control) bool {
+	return c.Type() == pw_hdlc.InformationFrame || c.Type() == pw_hdlc.UnnumberedFrame && c.Unnumbered() == pw_hdlc.UnnumberedInformation
+}
+
+func (h *hdlcIO) WritePacket(ctx context.Context, packet []byte) error {
+	// A frame that failed part way leaves the stream unusable, so the
+	// connection is closed, which also ends ReadPacket.
+	if err := h.encoder.Encode(packet); err != nil {
+		h.Close()
+		return err
+	}
+
+	if !h.linked {
+		h.capture(Outbound, pw_hdlc.NewFrame(uint64(kDefaultRpcAddress), kUnnumberedControl, packet))
+	}
+
+	return nil
+}
+
+func (h *hdlcIO) Close() error {
+	var err error
+	h.once.Do(func() { err = h.closer.Close() })
+
+	return err
+}
+
+type lengthPrefixedFraming struct{}
+
+func (lengthPrefixedFraming) NewPacketIO(rwc io.ReadWriteCloser, ph PacketHandler) PacketIO {
+	return &lengthPrefixedIO{
+		rwc:    rwc,
+		reader: bufio.NewReader(rwc),
+	}
+}
+
+type lengthPrefixedIO struct {
+	rwc    io.ReadWriteCloser
+	reader *bufio.Reader
+	mu     sync.Mutex
+	once   sync.Once
+}
+
+// ReadPacket reads the next packet. Without a frame check the stream cannot
+// be resynchronized, so a bad length ends the connection.
+func (l *lengthPrefixedIO) ReadPacket(ctx context.Context) ([]byte, error) {
+	size, err := binary.ReadUvarint(l.reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if size > kMaxPacketSize {
+		return nil, fmt.Errorf("%w: length prefix of %d bytes", ErrPacketTooLarge, size)
+	}
+
+	packet := make([]byte, size)
+	if _, err := io.ReadFull(l.reader, packet); err != nil {
+		return nil, err
+	}
+
+	return packet, nil
+}
+
+func (l *lengthPrefixedIO) WritePacket(ctx context.Context, packet []byte) error {
+	if len(packet) > kMaxPacketSize {
+		return fmt.Errorf("%w: %d bytes", ErrPacketTooLarge, len(packet))
+	}
+
+	buf := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen32+len(packet)), uint64(len(packet)))
+	buf = append(buf, packet...)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// As with HDLC, a partial write leaves the stream unusable.
+	if _, err := l.rwc.Write(buf); err != nil {
+		l.Close()
+		return err
+	}
+
+	return nil
+}
+
+func (l *lengthPrefixedIO) Close() error {
+	var err error
+	l.once.Do(func() { err = l.rwc.Close() })
+
+	return err
+}
+
+// NewCobsFraming returns a framing that sends each packet in a COBS frame
+// that ends with checksum, as bootloaders that use pw_cobs do.
+func NewCobsFraming(checksum pw_cobs.Checksum) FrameTransport {
+	return cobsFraming{checksum: checksum}
+}
+
+type cobsFraming struct {
+	checksum pw_cobs.Checksum
+}
+
+func (f cobsFraming) NewPacketIO(rwc io.ReadWriteCloser, ph PacketHandler) PacketIO {
+	return &cobsIO{
+		rwc:     rwc,
+		encoder: pw_cobs.NewEncoder(rwc, f.checksum),
+		decoder: pw_cobs.NewDecoder(rwc, f.checksum),
+	}
+}
+
+type cobsIO struct {
+	rwc     io.ReadWriteCloser
+	encoder pw_cobs.Encoder
+	decoder pw_cobs.Decoder
+	once    sync.Once
+}
+
+// ReadPacket returns the payload of the next frame. Frames that cannot be
+// decoded or fail their checksum are dropped.
+func (c *cobsIO) ReadPacket(ctx context.Context) ([]byte, error) {
+	for {
+		packet, err := c.decoder.Decode(ctx)
+		if errors.Is(err, pw_cobs.ErrDataLoss) {
+			continue
+		}
+
+		return packet, err
+	}
+}
+
+func (c *cobsIO) WritePacket(ctx context.Context, packet []byte) error {
+	if err := c.encoder.Encode(packet); err != nil {
+		c.Close()
+		return err
+	}
+
+	return nil
+}
+
+func (c *cobsIO) Close() error {
+	var err error
+	c.once.Do(func() { err = c.rwc.Close() })
+
+	return err
+}
+
+type datagramFraming struct{}
+
+func (datagramFraming) NewPacketIO(rwc io.ReadWriteCloser, ph PacketHandler) PacketIO {
+	return &datagramIO{
+		rwc: rwc,
+		buf: make([]byte, kMaxDatagramSize),
+	}
+}
+
+type datagramIO struct {
+	rwc  io.ReadWriteCloser
+	buf  []byte
+	once sync.Once
+}
+
+func (d *datagramIO) ReadPacket(ctx context.Context) ([]byte, error) {
+	n, err := d.rwc.Read(d.buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(nil), d.buf[:n]...), nil
+}
+
+// WritePacket sends packet as one datagram. A lost datagram does not affect
+// the next one, so the connection stays open when a write fails.
+func (d *datagramIO) WritePacket(ctx context.Context, packet []byte) error {
+	if len(packet) > kMaxDatagramSize {
+		return fmt.Errorf("%w: %d bytes for a datagram", ErrPacketTooLarge, len(packet))
+	}
+
+	_, err := d.rwc.Write(packet)
+
+	return err
+}
+
+func (d *datagramIO) Close() error {
+	var err error
+	d.once.Do(func() { err = d.rwc.Close() })
+
+	return err
+}
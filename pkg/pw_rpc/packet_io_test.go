@@ -0,0 +1,348 @@
+package pw_rpc_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_cobs"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_hdlc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+// serveFraming serves the conformance service in framing on device, the
+// other end of a link from host.
+func serveFraming(t *testing.T, ctx context.Context, framing pw_rpc.FrameTransport, host io.ReadWriteCloser, device io.ReadWriteCloser) pw_rpc.Server {
+	t.Helper()
+
+	s := pw_rpc.NewServer("")
+	if err := s.Register(&kConformanceServiceDesc, struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	s.SetFraming(framing)
+
+	go s.Serve(ctx, device)
+	t.Cleanup(func() { host.Close() })
+
+	return s
+}
+
+// tapped is a frame shown to a chanTap.
+type tapped struct {
+	direction pw_rpc.Direction
+	frame     *pw_hdlc.Frame
+	packet    *pb.RpcPacket
+}
+
+// chanTap passes the frames shown to it to a channel.
+type chanTap chan tapped
+
+func (c chanTap) Capture(at time.Time, direction pw_rpc.Direction, frame *pw_hdlc.Frame, packet *pb.RpcPacket) {
+	c <- tapped{direction, frame, packet}
+}
+
+func TestLengthPrefixedFraming(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	host, device := net.Pipe()
+	serveFraming(t, ctx, pw_rpc.LengthPrefixedFraming, host, device)
+	host.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// 200 bytes of payload need a two byte length.
+	request := &pb.RpcPacket{
+		Type:      pb.PacketType_REQUEST,
+		ChannelId: 1,
+		ServiceId: uint32(pw_rpc.NewKey(kConformanceService)),
+		MethodId:  uint32(pw_rpc.NewKey("Unary")),
+		Payload:   make([]byte, 200),
+		CallId:    3,
+	}
+	buf, err := proto.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := host.Write(append(binary.AppendUvarint(nil, uint64(len(buf))), buf...)); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(host)
+	size, err := binary.ReadUvarint(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf = make([]byte, size)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	response := &pb.RpcPacket{}
+	if err := proto.Unmarshal(buf, response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Type != pb.PacketType_RESPONSE || response.CallId != 3 || len(response.Payload) != 200 {
+		t.Errorf("response = %v", response)
+	}
+}
+
+// messageLink is one end of a link that keeps message boundaries, like a
+// pw_stream or an RPC stream that tunnels packets.
+type messageLink struct {
+	in     <-chan []byte
+	out    chan<- []byte
+	closed chan struct{}
+	once   *sync.Once
+}
+
+func newMessageLink() (*messageLink, *messageLink) {
+	a, b := make(chan []byte, 16), make(chan []byte, 16)
+	closed, once := make(chan struct{}), &sync.Once{}
+
+	return &messageLink{in: a, out: b, closed: closed, once: once}, &messageLink{in: b, out: a, closed: closed, once: once}
+}
+
+func (m *messageLink) Read(p []byte) (int, error) {
+	select {
+	case msg := <-m.in:
+		if len(msg) > len(p) {
+			return 0, io.ErrShortBuffer
+		}
+		return copy(p, msg), nil
+	case <-m.closed:
+		return 0, io.EOF
+	}
+}
+
+func (m *messageLink) Write(p []byte) (int, error) {
+	select {
+	case m.out <- append([]byte(nil), p...):
+		return len(p), nil
+	case <-m.closed:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+func (m *messageLink) Close() error {
+	m.once.Do(func() { close(m.closed) })
+	return nil
+}
+
+func TestFramings(t *testing.T) {
+	framings := []struct {
+		name    string
+		framing pw_rpc.FrameTransport
+		link    func() (io.ReadWriteCloser, io.ReadWriteCloser)
+	}{
+		{"hdlc", pw_rpc.HdlcFraming, func() (io.ReadWriteCloser, io.ReadWriteCloser) { return net.Pipe() }},
+		{"hdlc-reliable", pw_rpc.NewReliableHdlcFraming(pw_hdlc.LinkConfig{}), func() (io.ReadWriteCloser, io.ReadWriteCloser) { return net.Pipe() }},
+		{"length-prefixed", pw_rpc.LengthPrefixedFraming, func() (io.ReadWriteCloser, io.ReadWriteCloser) { return net.Pipe() }},
+		{"cobs", pw_rpc.NewCobsFraming(pw_cobs.ChecksumNone), func() (io.ReadWriteCloser, io.ReadWriteCloser) { return net.Pipe() }},
+		{"cobs-crc16", pw_rpc.NewCobsFraming(pw_cobs.Crc16), func() (io.ReadWriteCloser, io.ReadWriteCloser) { return net.Pipe() }},
+		{"cobs-crc32", pw_rpc.NewCobsFraming(pw_cobs.Crc32), func() (io.ReadWriteCloser, io.ReadWriteCloser) { return net.Pipe() }},
+		{"datagram", pw_rpc.DatagramFraming, func() (io.ReadWriteCloser, io.ReadWriteCloser) { return newMessageLink() }},
+	}
+
+	for _, f := range framings {
+		t.Run(f.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			host, device := f.link()
+			serveFraming(t, ctx, f.framing, host, device)
+
+			c := pw_rpc.NewClientWithDialer(func(context.Context) (io.ReadWriteCloser, error) {
+				return host, nil
+			})
+			c.SetFraming(f.framing)
+			defer c.Close()
+
+			reply := pw_rpc.RawMessage{}
+			if err := c.Invoke(ctx, method("Unary"), pw_rpc.RawMessage("~}ping~"), &reply); err != nil {
+				t.Fatal(err)
+			}
+			if string(reply) != "~}ping~" {
+				t.Errorf("reply = %q", reply)
+			}
+
+			stream, err := c.NewStream(ctx, kClientStreamDesc, method("ClientStream"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, msg := range []string{"a", "b", "c"} {
+				if err := stream.SendMsg(pw_rpc.RawMessage(msg)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := stream.CloseSend(); err != nil {
+				t.Fatal(err)
+			}
+			reply = pw_rpc.RawMessage{}
+			if err := stream.RecvMsg(&reply); err != nil {
+				t.Fatal(err)
+			}
+			if string(reply) != "abc" {
+				t.Errorf("client stream reply = %q, want %q", reply, "abc")
+			}
+		})
+	}
+}
+
+func TestHdlcFrameTypes(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	host, device := net.Pipe()
+	tap := make(chanTap, 16)
+	// Set before any frame is sent, so the server sees the tap.
+	serveFraming(t, ctx, pw_rpc.HdlcFraming, host, device).SetTap(tap)
+	host.SetDeadline(time.Now().Add(5 * time.Second))
+
+	request, err := proto.Marshal(&pb.RpcPacket{
+		Type:      pb.PacketType_REQUEST,
+		ChannelId: 1,
+		ServiceId: uint32(pw_rpc.NewKey(kConformanceService)),
+		MethodId:  uint32(pw_rpc.NewKey("Unary")),
+		Payload:   []byte("ping"),
+		CallId:    5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Frames that manage the link are not packets, even when empty packets
+	// would parse; an I-frame carries a packet like a UI frame.
+	frames := []*pw_hdlc.Frame{
+		pw_hdlc.NewFrame(kRpcAddress, byte(pw_hdlc.UnnumberedControl(pw_hdlc.SetAsyncBalancedMode, true)), nil),
+		pw_hdlc.NewFrame(kRpcAddress, byte(pw_hdlc.SupervisoryControl(pw_hdlc.ReceiveReady, 0, false)), nil),
+		pw_hdlc.NewFrame(kRpcAddress, byte(pw_hdlc.InformationControl(0, 0, false)), request),
+	}
+
+	go func() {
+		encoder := pw_hdlc.NewEncoder(host, kRpcAddress)
+		for _, frame := range frames {
+			encoder.EncodeFrame(frame)
+		}
+	}()
+
+	frame, err := pw_hdlc.NewDecoder(host, kRpcAddress).Decode(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := &pb.RpcPacket{}
+	if err := proto.Unmarshal(frame.Payload(), response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Type != pb.PacketType_RESPONSE || response.CallId != 5 || string(response.Payload) != "ping" {
+		t.Errorf("first response = %v, want the RESPONSE to the I-frame", response)
+	}
+	// The tap sees the frames as they were sent, link frames included.
+	want := append(frames, pw_hdlc.NewFrame(kRpcAddress, byte(pw_hdlc.UnnumberedControl(pw_hdlc.UnnumberedInformation, false)), frame.Payload()))
+	for i, w := range want {
+		var got tapped
+		select {
+		case got = <-tap:
+		case <-ctx.Done():
+			t.Fatalf("tap frame %d: %s", i, ctx.Err())
+		}
+
+		direction := pw_rpc.Inbound
+		if i == len(frames) {
+			direction = pw_rpc.Outbound
+		}
+		if got.direction != direction || string(got.frame.Raw()) != string(w.Raw()) {
+			t.Errorf("tap frame %d = %s %s, want %s %s", i, got.direction, got.frame.ControlField(), direction, w.ControlField())
+		}
+		if hasPacket := got.packet != nil; hasPacket != (i >= 2) {
+			t.Errorf("tap frame %d packet = %v", i, got.packet)
+		}
+	}
+}
+
+func TestReliableHdlcTap(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	framing := pw_rpc.NewReliableHdlcFraming(pw_hdlc.LinkConfig{})
+	host, device := net.Pipe()
+	tap := make(chanTap, 64)
+	serveFraming(t, ctx, framing, host, device).SetTap(tap)
+
+	c := pw_rpc.NewClientWithDialer(func(context.Context) (io.ReadWriteCloser, error) {
+		return host, nil
+	})
+	c.SetFraming(framing)
+	defer c.Close()
+
+	reply := pw_rpc.RawMessage{}
+	if err := c.Invoke(ctx, method("Unary"), pw_rpc.RawMessage("ping"), &reply); err != nil {
+		t.Fatal(err)
+	}
+
+	// The tap sees the link being set up, the packets in their I-frames and
+	// their acknowledgements.
+	want := map[string]bool{
+		"rx SABM": false, "tx UA": false,
+		"rx I REQUEST": false, "tx RR": false,
+		"tx I RESPONSE": false, "rx RR": false,
+	}
+	for seen := 0; seen < len(want); {
+		var got tapped
+		select {
+		case got = <-tap:
+		case <-ctx.Done():
+			t.Fatalf("tap saw only %v", want)
+		}
+
+		control := got.frame.ControlField()
+		if control.Type() == pw_hdlc.UnnumberedFrame && control.Unnumbered() == pw_hdlc.UnnumberedInformation {
+			t.Errorf("tap saw a UI frame on the link")
+		}
+
+		key := fmt.Sprintf("%s %s", got.direction, strings.Fields(control.String())[0])
+		if got.packet != nil {
+			key += " " + got.packet.Type.String()
+		}
+		if seenBefore, ok := want[key]; ok && !seenBefore {
+			want[key] = true
+			seen++
+		}
+	}
+}
+
+func TestTapWithoutHdlc(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	host, device := net.Pipe()
+	tap := make(chanTap, 16)
+	serveFraming(t, ctx, pw_rpc.LengthPrefixedFraming, host, device).SetTap(tap)
+
+	c := pw_rpc.NewClientWithDialer(func(context.Context) (io.ReadWriteCloser, error) {
+		return host, nil
+	})
+	c.SetFraming(pw_rpc.LengthPrefixedFraming)
+	defer c.Close()
+
+	reply := pw_rpc.RawMessage{}
+	if err := c.Invoke(ctx, method("Unary"), pw_rpc.RawMessage("ping"), &reply); err != nil {
+		t.Fatal(err)
+	}
+
+	// There is no HDLC frame to show, only the packets.
+	for _, want := range []pb.PacketType{pb.PacketType_REQUEST, pb.PacketType_RESPONSE} {
+		got := <-tap
+		if got.frame != nil || got.packet == nil || got.packet.Type != want {
+			t.Errorf("tap saw frame %v packet %v, want %s", got.frame, got.packet, want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package pw_rpc
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// RawMessage is an encoded protobuf payload. Sending or receiving a
+// *RawMessage relays the payload as is, without knowing the message type.
+type RawMessage []byte
+
+func marshalPayload(m any) ([]byte, error) {
+	switch m := m.(type) {
+	case nil:
+		return []byte{}, nil
+	case []byte:
+		return m, nil
+	case RawMessage:
+		return m, nil
+	case *RawMessage:
+		return *m, nil
+	case protoreflect.ProtoMessage:
+		return proto.Marshal(m)
+	}
+
+	return nil, fmt.Errorf("message not a ProtoMessage: %T", m)
+}
+
+func unmarshalPayload(payload []byte, m any) error {
+	switch m := m.(type) {
+	case *RawMessage:
+		*m = append((*m)[:0], payload...)
+		return nil
+	case protoreflect.ProtoMessage:
+		return proto.Unmarshal(payload, m)
+	}
+
+	return fmt.Errorf("message not a ProtoMessage: %T", m)
+}
+
+// contextError converts the reason ctx ended into a gRPC status error.
+// A stream aborted with a status error reports that error instead.
+func contextError(ctx context.Context) error {
+	if cause := context.Cause(ctx); cause != nil {
+		if _, ok := status.FromError(cause); ok {
+			return cause
+		}
+	}
+
+	return status.FromContextError(ctx.Err()).Err()
+}
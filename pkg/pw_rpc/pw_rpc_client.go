@@ -4,10 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net"
+	"io"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_hdlc"
 	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
 	"google.golang.org/grpc"
 )
@@ -21,27 +24,61 @@ var (
 type Client interface {
 	grpc.ClientConnInterface
 	PacketHandler
+	Connect(context.Context) error
 	GetConn() Conn
 	CloseStream(Stream)
+	SetLogHandler(LogHandler)
+	// SetTap shows every frame sent and received by the client to tap.
+	SetTap(Tap)
+	// SetFraming frames the packets of the connections the client makes
+	// from now on. By default UDP sockets carry one packet per datagram and
+	// other connections use HDLC.
+	SetFraming(FrameTransport)
+	// Registry returns the names the client uses in its diagnostics. It
+	// falls back to the DefaultRegistry.
+	Registry() Registry
 	Close()
 }
 
+// Dialer opens the transport a client sends its framed packets over.
+type Dialer func(ctx context.Context) (io.ReadWriteCloser, error)
+
 type client struct {
 	endpoint      string
+	dialer        Dialer
 	conn          Conn
 	streamManager StreamManager
+	logHandler    LogHandler
+	tap           Tap
+	framing       FrameTransport
+	registry      Registry
+	lastCallId    atomic.Uint32
 	mu            sync.Mutex
 }
 
+// NewClient creates a client that connects to endpoint, a URI of a registered
+// transport scheme or a TCP host:port. See ParseEndpoint.
 func NewClient(endpoint string) Client {
 	return &client{
 		endpoint:      endpoint,
 		conn:          nil,
 		streamManager: NewStreamManager(),
+		registry:      NewRegistry(DefaultRegistry),
+	}
+}
+
+// NewClientWithDialer creates a client that connects with dialer instead of
+// dialing an endpoint, e.g. to talk over stdio.
+func NewClientWithDialer(dialer Dialer) Client {
+	return &client{
+		dialer:        dialer,
+		conn:          nil,
+		streamManager: NewStreamManager(),
+		registry:      NewRegistry(DefaultRegistry),
 	}
 }
 
-func (c *client) connectAttempt(ctx context.Context) (conn net.Conn, err error) {
+func (c *client) connectAttempt(ctx context.Context) (conn io.ReadWriteCloser, err error) {
 	if c == nil {
 		return nil, ErrClientIsNil
 	}
@@ -50,70 +87,134 @@ func (c *client) connectAttempt(ctx context.Context) (conn net.Conn, err error)
 	case <-ctx.Done():
 		return nil, ErrCancelled
 	default:
-		conn, err = net.Dial("tcp", c.endpoint)
-		if err != nil {
-			return nil, err
+		if c.dialer != nil {
+			return c.dialer(ctx)
 		}
-	}
 
-	return conn, nil
+		return Dial(ctx, c.endpoint)
+	}
 }
 
-func (c *client) connect(ctx context.Context) error {
+// connect returns the client's connection, dialing it if there is none.
+func (c *client) connect(ctx context.Context) (Conn, error) {
 	if c == nil {
-		return ErrClientIsNil
+		return nil, ErrClientIsNil
 	}
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	if c.conn != nil {
-		return nil
+		return c.conn, nil
 	}
 
-	conn, err := c.connectAttempt(ctx)
+	rwc, err := c.connectAttempt(ctx)
 	for err != nil {
 		select {
 		case <-ctx.Done():
-			return ErrCancelled
+			return nil, ErrCancelled
 		case <-time.After(time.Second):
-			conn, err = c.connectAttempt(ctx)
+			rwc, err = c.connectAttempt(ctx)
 		}
 	}
 
-	c.conn = NewConn(conn, c)
+	c.conn = newConn(rwc, c.framing, c)
 
-	go func() {
-		c.mu.Lock()
-		if c.conn == nil {
-			c.mu.Unlock()
-			return
-		}
-		c.mu.Unlock()
+	// The connection outlives the call that opened it.
+	go c.recv(context.WithoutCancel(ctx), c.conn)
 
-		ctx, cancel := context.WithCancel(ctx)
-		err := c.conn.Recv(ctx)
-		if err != nil {
-			fmt.Printf("Server Disconnect: %s\n", err)
-			c.mu.Lock()
-			c.conn.Close()
-			c.conn = nil
-			c.mu.Unlock()
-		}
-		cancel()
-	}()
+	return c.conn, nil
+}
+
+// recv handles the packets of conn until it fails. The calls on a lost
+// connection fail with UNAVAILABLE; the next call reconnects.
+func (c *client) recv(ctx context.Context, conn Conn) {
+	c.drop(conn, conn.Recv(ctx))
+}
+
+// drop forgets a connection that failed with err.
+func (c *client) drop(conn Conn, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The connection is only lost if the client did not close it.
+	if c.conn != conn {
+		return
+	}
 
+	fmt.Printf("Server Disconnect: %s\n", err)
+	c.conn.Close()
+	c.conn = nil
+	c.streamManager.Abort(conn, StatusError(pb.StatusCode_UNAVAILABLE))
+}
+
+// Connect establishes the connection ahead of the first call, so that log
+// frames are received before any RPC is made.
+func (c *client) Connect(ctx context.Context) error {
+	_, err := c.connect(ctx)
 	return err
 }
 
 func (c *client) GetConn() Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	return c.conn
 }
 
+func (c *client) Registry() Registry {
+	return c.registry
+}
+
 func (c *client) CloseStream(stream Stream) {
 	c.streamManager.RemoveStream(stream)
 }
 
+// SetLogHandler routes the device's log frames to lh instead of stderr.
+func (c *client) SetLogHandler(lh LogHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.logHandler = lh
+}
+
+func (c *client) HandleLog(ctx context.Context, payload []byte) {
+	c.mu.Lock()
+	lh := c.logHandler
+	c.mu.Unlock()
+
+	if lh == nil {
+		fmt.Fprintf(os.Stderr, "Pigweed Log: %s\n", string(payload))
+		return
+	}
+
+	lh.HandleLog(ctx, payload)
+}
+
+func (c *client) SetTap(tap Tap) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tap = tap
+}
+
+func (c *client) SetFraming(framing FrameTransport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.framing = framing
+}
+
+func (c *client) Capture(at time.Time, direction Direction, frame *pw_hdlc.Frame, packet *pb.RpcPacket) {
+	c.mu.Lock()
+	tap := c.tap
+	c.mu.Unlock()
+
+	if tap != nil {
+		tap.Capture(at, direction, frame, packet)
+	}
+}
+
 func (c *client) HandlePacket(ctx context.Context, conn Conn, packet *pb.RpcPacket) error {
 	switch packet.Type {
 	case pb.PacketType_REQUEST:
@@ -123,9 +224,9 @@ func (c *client) HandlePacket(ctx context.Context, conn Conn, packet *pb.RpcPack
 	case pb.PacketType_CLIENT_STREAM:
 		return fmt.Errorf("client received client stream packet")
 	case pb.PacketType_RESPONSE, pb.PacketType_SERVER_STREAM, pb.PacketType_SERVER_ERROR:
-		s := c.streamManager.GetStream(Key(packet.ServiceId), Key(packet.MethodId))
+		s := c.streamManager.GetStream(Key(packet.ServiceId), Key(packet.MethodId), packet.CallId)
 		if s == nil {
-			return fmt.Errorf("stream not found: %d %d", packet.ServiceId, packet.MethodId)
+			return fmt.Errorf("stream not found: %s", c.registry.FormatPacket(packet))
 		}
 
 		s.PacketReceived(packet)
@@ -141,19 +242,28 @@ func (c *client) Close() {
 		return
 	}
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if c.conn != nil {
 		c.conn.Close()
+		c.streamManager.Abort(c.conn, StatusError(pb.StatusCode_CANCELLED))
 		c.conn = nil
 	}
 }
 
+// callOptions assigns the next call id to a call, unless opts set one.
+func (c *client) callOptions(opts []grpc.CallOption) []grpc.CallOption {
+	return append([]grpc.CallOption{WithCallId(c.lastCallId.Add(1)), withRegistry(c.registry)}, opts...)
+}
+
 func (c *client) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
-	err := c.connect(ctx)
+	conn, err := c.connect(ctx)
 	if err != nil {
 		return err
 	}
 
-	stream, err := NewStream(ctx, nil, c.conn, method, opts...)
+	stream, err := NewStream(ctx, nil, conn, method, c.callOptions(opts)...)
 	if err != nil {
 		return err
 	}
@@ -161,32 +271,52 @@ func (c *client) Invoke(ctx context.Context, method string, args, reply any, opt
 	c.streamManager.AddStream(stream)
 
 	if err := stream.Send(args, pb.StatusCode_OK, pb.PacketType_REQUEST); err != nil {
+		c.streamManager.RemoveStream(stream)
+		c.drop(conn, err)
 		return err
 	}
 
-	_, _, err = stream.Recv(reply)
+	pt, status, err := stream.Recv(reply)
+
+	if pt == pb.PacketType(-1) && err == nil {
+		sendCancel(stream)
+	}
 
 	c.streamManager.RemoveStream(stream)
 
-	return err
+	if err != nil {
+		return err
+	}
+
+	if pt == pb.PacketType(-1) {
+		return contextError(stream.Context())
+	}
+
+	return StatusError(status)
 }
 
 func (c *client) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
-	err := c.connect(ctx)
+	conn, err := c.connect(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	if c.conn == nil {
-		return nil, fmt.Errorf("connection is nil")
-	}
-
-	stream, err := NewClientStream(ctx, desc, c, method, opts...)
+	stream, err := newClientStream(ctx, desc, c, conn, method, c.callOptions(opts)...)
 	if err != nil {
 		return nil, err
 	}
 
 	c.streamManager.AddStream(stream.GetStream())
 
+	// Client and bidirectional streaming calls are opened by an empty REQUEST;
+	// the messages follow as CLIENT_STREAM packets.
+	if desc != nil && desc.ClientStreams {
+		err = stream.GetStream().Send(nil, pb.StatusCode_OK, pb.PacketType_REQUEST)
+		if err != nil {
+			c.streamManager.RemoveStream(stream.GetStream())
+			return nil, err
+		}
+	}
+
 	return stream, err
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
@@ -25,7 +26,6 @@ type clientStream struct {
 	s         Stream
 	desc      *grpc.StreamDesc
 	c         Client
-	firstSend bool
 	closeSend bool
 }
 
@@ -73,12 +73,7 @@ func (cs *clientStream) SendMsg(m any) error {
 	}
 
 	if cs.desc != nil && cs.desc.ClientStreams {
-		if !cs.firstSend {
-			cs.firstSend = true
-			return cs.s.Send(m, pb.StatusCode_OK, pb.PacketType_REQUEST)
-		} else {
-			return cs.s.Send(m, pb.StatusCode_OK, pb.PacketType_CLIENT_STREAM)
-		}
+		return cs.s.Send(m, pb.StatusCode_OK, pb.PacketType_CLIENT_STREAM)
 	}
 
 	if cs.desc != nil && cs.desc.ServerStreams {
@@ -101,7 +96,7 @@ func (cs *clientStream) RecvMsg(m any) error {
 	}
 
 	if cs.desc != nil && cs.desc.ServerStreams {
-		pt, _, err := cs.s.Recv(m)
+		pt, status, err := cs.s.Recv(m)
 		if err != nil {
 			cs.c.CloseStream(cs.s)
 			return err
@@ -109,18 +104,49 @@ func (cs *clientStream) RecvMsg(m any) error {
 		switch pt {
 		case pb.PacketType_RESPONSE:
 			cs.c.CloseStream(cs.s)
+			if status != pb.StatusCode_OK {
+				return StatusError(status)
+			}
 			return io.EOF
 		case pb.PacketType_SERVER_STREAM:
 			return nil
+		case pb.PacketType_SERVER_ERROR:
+			cs.c.CloseStream(cs.s)
+			return StatusError(status)
+		case pb.PacketType(-1):
+			sendCancel(cs.s)
+			cs.c.CloseStream(cs.s)
+			return contextError(cs.s.Context())
 		default:
 			cs.c.CloseStream(cs.s)
 			return fmt.Errorf("unexpected packet type: %s", pt)
 		}
 	}
 
-	_, _, err := cs.s.Recv(m)
+	pt, status, err := cs.s.Recv(m)
+	if err != nil {
+		return err
+	}
 
-	return err
+	if pt == pb.PacketType(-1) {
+		sendCancel(cs.s)
+		cs.c.CloseStream(cs.s)
+		return contextError(cs.s.Context())
+	}
+
+	return StatusError(status)
+}
+
+// sendCancel tells the server that the client gave up on a call whose
+// context ended. A call that was already closed, or aborted because its
+// connection was lost, has no server to tell.
+func sendCancel(s Stream) {
+	cause := context.Cause(s.Context())
+	if _, aborted := status.FromError(cause); cause == nil || cause == errStreamClosed || aborted {
+		return
+	}
+
+	s.Send(nil, pb.StatusCode_CANCELLED, pb.PacketType_CLIENT_ERROR)
 }
 
 func (cs *clientStream) GetStream() Stream {
@@ -128,7 +154,11 @@ func (cs *clientStream) GetStream() Stream {
 }
 
 func NewClientStream(ctx context.Context, desc *grpc.StreamDesc, c Client, method string, opts ...grpc.CallOption) (ClientStream, error) {
-	s, err := NewStream(ctx, desc, c.GetConn(), method, opts...)
+	return newClientStream(ctx, desc, c, c.GetConn(), method, opts...)
+}
+
+func newClientStream(ctx context.Context, desc *grpc.StreamDesc, c Client, conn Conn, method string, opts ...grpc.CallOption) (ClientStream, error) {
+	s, err := NewStream(ctx, desc, conn, method, opts...)
 	if err != nil {
 		return nil, err
 	}
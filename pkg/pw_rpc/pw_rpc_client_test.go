@@ -0,0 +1,74 @@
+package pw_rpc
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// fuzzDevice accepts writes and blocks reads until it is closed.
+type fuzzDevice struct {
+	closed chan struct{}
+}
+
+func (d *fuzzDevice) Read(p []byte) (int, error) {
+	<-d.closed
+	return 0, io.EOF
+}
+
+func (d *fuzzDevice) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (d *fuzzDevice) Close() error {
+	select {
+	case <-d.closed:
+	default:
+		close(d.closed)
+	}
+	return nil
+}
+
+func FuzzClientHandlePacket(f *testing.F) {
+	fuzzSeeds(f)
+
+	c := NewClientWithDialer(func(context.Context) (io.ReadWriteCloser, error) {
+		return &fuzzDevice{closed: make(chan struct{})}, nil
+	})
+	defer c.Close()
+
+	desc := &grpc.StreamDesc{StreamName: "Bidi", ServerStreams: true, ClientStreams: true}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		packet := &pb.RpcPacket{}
+		if err := proto.Unmarshal(data, packet); err != nil {
+			return
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		cs, err := c.NewStream(ctx, desc, "/pw.test.Fuzz/Bidi")
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream := cs.(ClientStream).GetStream()
+
+		// The packet as is, then addressed to the open call.
+		c.HandlePacket(ctx, fuzzConn{}, packet)
+
+		packet.ChannelId = stream.ChannelId()
+		packet.ServiceId = uint32(stream.Key().serviceId)
+		packet.MethodId = uint32(stream.Key().methodId)
+		packet.CallId = stream.CallId()
+		c.HandlePacket(ctx, fuzzConn{}, packet)
+
+		cancel()
+		cs.RecvMsg(&pb.RpcPacket{})
+		c.CloseStream(stream)
+	})
+}
@@ -3,8 +3,9 @@ package pw_rpc
 import (
 	"context"
 	"fmt"
-	"net"
-	"os"
+	"io"
+	"sync"
+	"time"
 
 	"github.com/robertfarnum/go-pw-rpc/pkg/pw_hdlc"
 	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
@@ -14,31 +15,68 @@ import (
 var (
 	kDefaultRpcAddress = 'R'
 	kDefaultLogAddress = 1
+	kUnnumberedControl = byte(0x03)
 )
 
 type PacketHandler interface {
 	HandlePacket(context.Context, Conn, *pb.RpcPacket) error
 }
 
+// LogHandler receives the payloads of frames sent to the log address. A
+// PacketHandler that also implements LogHandler gets the device's log output;
+// otherwise it is printed to stderr.
+type LogHandler interface {
+	HandleLog(context.Context, []byte)
+}
+
+type Direction int
+
+const (
+	Inbound Direction = iota
+	Outbound
+)
+
+func (d Direction) String() string {
+	if d == Outbound {
+		return "tx"
+	}
+
+	return "rx"
+}
+
+// Tap observes every frame on a connection. packet is the decoded RPC packet,
+// or nil for frames that carry none, such as logs. frame is nil in framings
+// other than HDLC, which show only the packets that could be decoded. A
+// PacketHandler that also implements Tap sees the frames of its connections.
+type Tap interface {
+	Capture(at time.Time, direction Direction, frame *pw_hdlc.Frame, packet *pb.RpcPacket)
+}
+
 type Conn interface {
+	// Recv handles the frames read from the connection until reading fails
+	// or ctx is done.
 	Recv(context.Context) error
 	Send(context.Context, *pb.RpcPacket) error
 	Close()
 }
 
 type conn struct {
-	conn    net.Conn
-	encoder pw_hdlc.Encoder
-	decoder pw_hdlc.Decoder
-	ph      PacketHandler
+	pio  PacketIO
+	ph   PacketHandler
+	once sync.Once
+}
+
+// NewConn returns a Conn that sends HDLC framed packets over rwc.
+func NewConn(rwc io.ReadWriteCloser, ph PacketHandler) Conn {
+	return NewPacketConn(HdlcFraming.NewPacketIO(rwc, ph), ph)
 }
 
-func NewConn(netConn net.Conn, ph PacketHandler) Conn {
+// NewPacketConn returns a Conn that sends and receives packets with pio, so
+// that RPC can run over any framing or message link.
+func NewPacketConn(pio PacketIO, ph PacketHandler) Conn {
 	return &conn{
-		conn:    netConn,
-		encoder: pw_hdlc.NewEncoder(netConn, uint64(kDefaultRpcAddress)),
-		decoder: pw_hdlc.NewDecoder(netConn, uint64(kDefaultRpcAddress)),
-		ph:      ph,
+		pio: pio,
+		ph:  ph,
 	}
 }
 
@@ -58,40 +96,44 @@ func (c *conn) Recv(ctx context.Context) error {
 	}
 }
 
-func (c *conn) processFrame(ctx context.Context, frame *pw_hdlc.Frame) error {
-	switch frame.Address() {
-	case uint64(kDefaultRpcAddress):
-		packet := &pb.RpcPacket{}
-		err := proto.Unmarshal(frame.Payload(), packet)
-		if err != nil {
-			return err
-		}
+// capture shows a packet to the handler's Tap. HDLC connections show the
+// frames they read and write themselves; other framings have no HDLC frame
+// to show.
+func (c *conn) capture(direction Direction, packet *pb.RpcPacket) {
+	if _, ok := c.pio.(*hdlcIO); ok {
+		return
+	}
 
-		if c.ph == nil {
-			return fmt.Errorf("packet handler is nil")
-		}
+	if tap, ok := c.ph.(Tap); ok {
+		tap.Capture(time.Now(), direction, nil, packet)
+	}
+}
 
-		return c.ph.HandlePacket(ctx, c, packet)
-	case uint64(kDefaultLogAddress):
-		fmt.Fprintf(os.Stderr, "Pigweed Log: %s\n", string(frame.Payload()))
-	default:
-		return ErrBadAddress
+func (c *conn) processPacket(ctx context.Context, buf []byte) error {
+	packet := &pb.RpcPacket{}
+	if err := proto.Unmarshal(buf, packet); err != nil {
+		return err
 	}
+	c.capture(Inbound, packet)
 
-	return nil
+	if c.ph == nil {
+		return fmt.Errorf("packet handler is nil")
+	}
+
+	return c.ph.HandlePacket(ctx, c, packet)
 }
 
+// recv handles the next packet. Only transport errors end the connection: a
+// packet that cannot be handled is dropped.
 func (c *conn) recv(ctx context.Context) error {
-	frame, err := c.decoder.Decode(ctx)
+	buf, err := c.pio.ReadPacket(ctx)
 	if err != nil {
 		return err
 	}
 
-	if frame != nil {
-		return c.processFrame(ctx, frame)
-	}
+	c.processPacket(ctx, buf)
 
-	return fmt.Errorf("no frame")
+	return nil
 }
 
 func (c *conn) Send(ctx context.Context, packet *pb.RpcPacket) error {
@@ -100,12 +142,15 @@ func (c *conn) Send(ctx context.Context, packet *pb.RpcPacket) error {
 		return err
 	}
 
-	return c.encoder.Encode(buf)
+	c.capture(Outbound, packet)
+
+	return c.pio.WritePacket(ctx, buf)
 }
 
 func (c *conn) Close() {
-	if c != nil && c.conn != nil {
-		c.conn.Close()
-		c.conn = nil
+	if c == nil {
+		return
 	}
+
+	c.once.Do(func() { c.pio.Close() })
 }
@@ -2,16 +2,18 @@ package pw_rpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"reflect"
 	"sync"
+	"time"
 
 	"google.golang.org/grpc"
-	"google.golang.org/protobuf/proto"
-	"google.golang.org/protobuf/reflect/protoreflect"
 
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_hdlc"
 	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
 )
 
@@ -19,7 +21,24 @@ type Server interface {
 	PacketHandler
 
 	RegisterService(desc *grpc.ServiceDesc, impl any)
+	// Register is RegisterService returning an error, rather than exiting,
+	// when the service cannot be registered.
+	Register(desc *grpc.ServiceDesc, impl any) error
+	// Registry returns the names of the registered services.
+	Registry() Registry
+	// ServiceName returns the name of a registered service id.
+	ServiceName(serviceId Key) (string, bool)
+	// MethodName returns the name of a method id of a registered service.
+	MethodName(serviceId Key, methodId Key) (string, bool)
+	// SetTap shows every frame sent and received by the server to tap.
+	SetTap(Tap)
+	// SetFraming frames the packets of the connections the server serves
+	// from now on. By default UDP sockets carry one packet per datagram and
+	// other connections use HDLC.
+	SetFraming(FrameTransport)
 	Listen(ctx context.Context) error
+	// Serve handles the packets read from rwc until it fails or ctx is done.
+	Serve(ctx context.Context, rwc io.ReadWriteCloser) error
 	GetConn() Conn
 	Close()
 }
@@ -37,85 +56,157 @@ type serviceInfo struct {
 
 type server struct {
 	endpoint      string
-	lis           net.Listener
-	services      map[Key]*serviceInfo // service name -> service info
+	lis           Listener
+	services      map[Key]*serviceInfo // service id -> service info
 	streamManager StreamManager
+	registry      Registry
+	tap           Tap
+	framing       FrameTransport
 	conn          Conn
 	mu            sync.Mutex
 }
 
+// NewServer creates a server that listens on endpoint, a URI of a registered
+// transport scheme or a TCP host:port. See ParseEndpoint.
 func NewServer(endpoint string) Server {
 	return &server{
 		endpoint:      endpoint,
 		services:      make(map[Key]*serviceInfo),
 		streamManager: NewStreamManager(),
+		registry:      NewRegistry(DefaultRegistry),
 	}
 }
 
 func (s *server) GetConn() Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	return s.conn
 }
 
+func (s *server) SetTap(tap Tap) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tap = tap
+}
+
+func (s *server) SetFraming(framing FrameTransport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.framing = framing
+}
+
+func (s *server) Capture(at time.Time, direction Direction, frame *pw_hdlc.Frame, packet *pb.RpcPacket) {
+	s.mu.Lock()
+	tap := s.tap
+	s.mu.Unlock()
+
+	if tap != nil {
+		tap.Capture(at, direction, frame, packet)
+	}
+}
+
+// Registry returns the names of the server's services. It falls back to the
+// DefaultRegistry for ids that are not registered on the server.
+func (s *server) Registry() Registry {
+	return s.registry
+}
+
+func (s *server) ServiceName(serviceId Key) (string, bool) {
+	return s.registry.ServiceName(serviceId)
+}
+
+func (s *server) MethodName(serviceId Key, methodId Key) (string, bool) {
+	return s.registry.MethodName(serviceId, methodId)
+}
+
+func (s *server) describe(serviceId Key, methodId Key) string {
+	return s.registry.Describe(serviceId, methodId)
+}
+
 func (s *server) HandleRequestPacket(ctx context.Context, conn Conn, packet *pb.RpcPacket) error {
 	service, ok := s.services[Key(packet.ServiceId)]
 	if !ok {
-		return fmt.Errorf("service not found: %d", packet.ServiceId)
+		sendServerError(ctx, conn, packet, pb.StatusCode_NOT_FOUND)
+		return fmt.Errorf("service not found: %s", s.describe(Key(packet.ServiceId), Key(packet.MethodId)))
 	}
 
 	method, ok := service.methods[Key(packet.MethodId)]
 	if ok {
 		res, err := method.Handler(service.serviceImpl, ctx, func(in interface{}) error {
-			payload, ok := in.(protoreflect.ProtoMessage)
-			if !ok {
-				return fmt.Errorf("invalid payload type: %T", in)
-			}
-
-			err := proto.Unmarshal(packet.Payload, payload)
-			if err != nil {
-				return err
-			}
-
-			return nil
+			return unmarshalPayload(packet.Payload, in)
 		}, nil)
-		if err != nil {
-			return err
-		}
 
-		payload, ok := res.(protoreflect.ProtoMessage)
-		if !ok {
-			return fmt.Errorf("invalid payload type: %T", res)
+		method := fmt.Sprintf("/%s/%s", service.name, method.MethodName)
+		stream, serr := NewStream(ctx, nil, conn, method, s.callOptions(packet)...)
+		if serr != nil {
+			return serr
 		}
 
-		method := fmt.Sprintf("/%s/%s", service.name, method.MethodName)
-		stream, err := NewStream(ctx, nil, conn, method, nil)
 		if err != nil {
-			return err
+			return stream.Send(nil, StatusCode(err), pb.PacketType_RESPONSE)
 		}
 
-		return stream.Send(payload, pb.StatusCode_OK, pb.PacketType_RESPONSE)
+		return stream.Send(res, pb.StatusCode_OK, pb.PacketType_RESPONSE)
 	}
 
 	desc, ok := service.streams[Key(packet.MethodId)]
 	if ok {
 		method := fmt.Sprintf("/%s/%s", service.name, desc.StreamName)
-		stream, err := NewServerStream(ctx, desc, s, method, nil)
+		stream, err := newServerStream(ctx, desc, conn, method, s.callOptions(packet)...)
 		if err != nil {
 			return err
 		}
 
 		s.streamManager.AddStream(stream.GetStream())
 
+		// Client and bidirectional streaming calls are opened by an empty
+		// REQUEST; otherwise it carries the request message.
+		if !desc.ClientStreams {
+			stream.GetStream().PacketReceived(packet)
+		}
+
 		go func() {
 			err := desc.Handler(service.serviceImpl, stream)
 			if err != nil {
 				fmt.Printf("Error handling stream: %s\n", err)
 			}
+
+			stream.Finish(err)
+			s.streamManager.RemoveStream(stream.GetStream())
 		}()
 
 		return nil
 	}
 
-	return fmt.Errorf("method and stream not found: %d", packet.MethodId)
+	sendServerError(ctx, conn, packet, pb.StatusCode_NOT_FOUND)
+
+	return fmt.Errorf("method and stream not found: %s", s.describe(Key(packet.ServiceId), Key(packet.MethodId)))
+}
+
+// callOptions addresses the responses to a request to the channel and call
+// it came from.
+func (s *server) callOptions(packet *pb.RpcPacket) []grpc.CallOption {
+	return []grpc.CallOption{
+		WithMethodIds(Key(packet.ServiceId), Key(packet.MethodId)),
+		WithChannelId(packet.ChannelId),
+		WithCallId(packet.CallId),
+		withRegistry(s.registry),
+	}
+}
+
+// sendServerError tells the client that the server could not process packet.
+func sendServerError(ctx context.Context, conn Conn, packet *pb.RpcPacket, statusCode pb.StatusCode) {
+	conn.Send(ctx, &pb.RpcPacket{
+		Type:      pb.PacketType_SERVER_ERROR,
+		ChannelId: packet.ChannelId,
+		ServiceId: packet.ServiceId,
+		MethodId:  packet.MethodId,
+		Status:    uint32(statusCode),
+		CallId:    packet.CallId,
+	})
 }
 
 func (s *server) HandlePacket(ctx context.Context, conn Conn, packet *pb.RpcPacket) error {
@@ -129,12 +220,12 @@ func (s *server) HandlePacket(ctx context.Context, conn Conn, packet *pb.RpcPack
 
 		return nil
 	case pb.PacketType_CLIENT_STREAM, pb.PacketType_CLIENT_REQUEST_COMPLETION, pb.PacketType_CLIENT_ERROR:
-		s := s.streamManager.GetStream(Key(packet.ServiceId), Key(packet.MethodId))
-		if s == nil {
-			return fmt.Errorf("stream not found: %d %d", packet.ServiceId, packet.MethodId)
+		stream := s.streamManager.GetStream(Key(packet.ServiceId), Key(packet.MethodId), packet.CallId)
+		if stream == nil {
+			return fmt.Errorf("stream not found: %s", s.describe(Key(packet.ServiceId), Key(packet.MethodId)))
 		}
 
-		s.PacketReceived(packet)
+		stream.PacketReceived(packet)
 
 		return nil
 	case pb.PacketType_RESPONSE:
@@ -147,25 +238,38 @@ func (s *server) HandlePacket(ctx context.Context, conn Conn, packet *pb.RpcPack
 }
 
 func (s *server) RegisterService(sd *grpc.ServiceDesc, ss any) {
+	if err := s.Register(sd, ss); err != nil {
+		log.Fatalf("grpc: Server.RegisterService: %s", err)
+	}
+}
+
+func (s *server) Register(sd *grpc.ServiceDesc, ss any) error {
 	if s != nil {
 		ht := reflect.TypeOf(sd.HandlerType).Elem()
 		st := reflect.TypeOf(ss)
 		if !st.Implements(ht) {
-			log.Fatalf("grpc: Server.RegisterService found the handler of type %v that does not satisfy %v", st, ht)
+			return fmt.Errorf("found the handler of type %v that does not satisfy %v", st, ht)
 		}
 	}
-	s.register(sd, ss)
+	return s.register(sd, ss)
 }
 
-func (s *server) register(sd *grpc.ServiceDesc, ss any) {
+func (s *server) register(sd *grpc.ServiceDesc, ss any) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	fmt.Printf("RegisterService(%q)", sd.ServiceName)
+	fmt.Printf("RegisterService(%q)\n", sd.ServiceName)
 	if s.lis != nil {
-		log.Fatalf("grpc: Server.RegisterService after Server.Serve for %q", sd.ServiceName)
+		return fmt.Errorf("register after Server.Serve for %q", sd.ServiceName)
+	}
+	serviceId := NewKey(sd.ServiceName)
+	if other, ok := s.services[serviceId]; ok {
+		if other.name == sd.ServiceName {
+			return fmt.Errorf("%w: %q", ErrDuplicateService, sd.ServiceName)
+		}
+		return fmt.Errorf("%w: services %q and %q have id 0x%08x", ErrIdCollision, other.name, sd.ServiceName, uint32(serviceId))
 	}
-	if _, ok := s.services[NewKey(sd.ServiceName)]; ok {
-		log.Fatalf("grpc: Server.RegisterService found duplicate service registration for %q", sd.ServiceName)
+	if err := s.registry.RegisterServiceDesc(sd); err != nil {
+		return err
 	}
 	info := &serviceInfo{
 		serviceImpl: ss,
@@ -182,55 +286,81 @@ func (s *server) register(sd *grpc.ServiceDesc, ss any) {
 		d := &sd.Streams[i]
 		info.streams[NewKey(d.StreamName)] = d
 	}
-	s.services[NewKey(sd.ServiceName)] = info
+	s.services[serviceId] = info
+	return nil
 }
 
-func (s *server) Listen(ctx context.Context) (err error) {
-	if s.lis == nil {
-		s.lis, err = net.Listen("tcp", s.endpoint)
-		if err != nil {
-			fmt.Println("Error listening:", err.Error())
-			return err
-		}
+// Listen serves the connections accepted on the server's endpoint until the
+// server is closed.
+func (s *server) Listen(ctx context.Context) error {
+	s.mu.Lock()
+	if s.lis != nil {
+		s.mu.Unlock()
+		return nil
+	}
+
+	lis, err := Listen(ctx, s.endpoint)
+	if err != nil {
+		s.mu.Unlock()
+		fmt.Println("Error listening:", err.Error())
+		return err
+	}
+	s.lis = lis
+	s.mu.Unlock()
 
-		defer func() {
-			s.lis.Close()
+	defer func() {
+		s.mu.Lock()
+		lis.Close()
+		if s.lis == lis {
 			s.lis = nil
-		}()
+		}
+		s.mu.Unlock()
+	}()
 
-		fmt.Printf("Server listening: %s\n", s.endpoint)
+	fmt.Printf("Server listening: %s\n", lis.Addr())
 
-		for {
-			if s.lis == nil {
-				break
+	for {
+		conn, err := lis.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			fmt.Println("Error accepting connection:", err.Error())
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second):
 			}
+			continue
+		}
 
-			// Accept incoming connections
-			conn, err := s.lis.Accept()
+		go func() {
+			err := s.Serve(ctx, conn)
 			if err != nil {
-				fmt.Println("Error accepting connection:", err.Error())
-				continue
+				fmt.Printf("Client Disconnect: %s\n", err)
 			}
+		}()
+	}
+}
 
-			s.conn = NewConn(conn, s)
-
-			go func() {
-				ctx, cancel := context.WithCancel(ctx)
-
-				err := s.conn.Recv(ctx)
-				if err != nil {
-					fmt.Printf("Client Disconnect: %s\n", err)
-				}
+func (s *server) Serve(ctx context.Context, rwc io.ReadWriteCloser) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-				cancel()
-			}()
-		}
-	}
+	s.mu.Lock()
+	conn := newConn(rwc, s.framing, s)
+	s.conn = conn
+	s.mu.Unlock()
 
-	return nil
+	return conn.Recv(ctx)
 }
 
 func (s *server) Close() {
-	s.lis.Close()
-	s.lis = nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lis != nil {
+		s.lis.Close()
+		s.lis = nil
+	}
 }
@@ -2,6 +2,8 @@ package pw_rpc
 
 import (
 	"context"
+	"io"
+	"sync"
 
 	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
 	"google.golang.org/grpc"
@@ -11,10 +13,15 @@ import (
 type ServerStream interface {
 	grpc.ServerStream
 	GetStream() Stream
+	// Finish completes the call with a RESPONSE carrying err's status.
+	Finish(err error)
 }
 
 type serverStream struct {
-	s Stream
+	s        Stream
+	desc     *grpc.StreamDesc
+	response any
+	mu       sync.Mutex
 }
 
 // Context implements grpc.ServerStream.
@@ -38,33 +45,71 @@ func (ss *serverStream) SetTrailer(metadata.MD) {
 }
 
 func (ss *serverStream) SendMsg(m any) error {
+	// A client streaming call has a single response, which is sent in the
+	// final RESPONSE packet.
+	if ss.desc != nil && !ss.desc.ServerStreams {
+		ss.mu.Lock()
+		ss.response = m
+		ss.mu.Unlock()
+
+		return nil
+	}
+
 	return ss.s.Send(m, pb.StatusCode_OK, pb.PacketType_SERVER_STREAM)
 }
 
 func (ss *serverStream) RecvMsg(m any) error {
-	_, _, err := ss.s.Recv(m)
+	pt, status, err := ss.s.Recv(m)
+	if err != nil {
+		return err
+	}
 
-	return err
+	switch pt {
+	case pb.PacketType_CLIENT_REQUEST_COMPLETION:
+		return io.EOF
+	case pb.PacketType_CLIENT_ERROR:
+		ss.s.Close()
+		return StatusError(status)
+	case pb.PacketType(-1):
+		return contextError(ss.s.Context())
+	}
+
+	return nil
 }
 
-func (ss *serverStream) Close() {
-	ss.s.Send([]byte{}, pb.StatusCode_OK, pb.PacketType_RESPONSE)
+func (ss *serverStream) Finish(err error) {
+	ss.mu.Lock()
+	response := ss.response
+	ss.mu.Unlock()
+
+	if ss.s.Context().Err() == nil {
+		ss.s.Send(response, StatusCode(err), pb.PacketType_RESPONSE)
+	}
 
 	ss.s.Close()
 }
 
+func (ss *serverStream) Close() {
+	ss.Finish(nil)
+}
+
 func (ss *serverStream) GetStream() Stream {
 	return ss.s
 }
 
 func NewServerStream(ctx context.Context, desc *grpc.StreamDesc, server Server, method string, opts ...grpc.CallOption) (ServerStream, error) {
-	stream, err := NewStream(ctx, desc, server.GetConn(), method, opts...)
+	return newServerStream(ctx, desc, server.GetConn(), method, opts...)
+}
+
+func newServerStream(ctx context.Context, desc *grpc.StreamDesc, conn Conn, method string, opts ...grpc.CallOption) (ServerStream, error) {
+	stream, err := NewStream(ctx, desc, conn, method, opts...)
 	if err != nil {
 		return nil, err
 	}
 
 	serverStream := &serverStream{
-		s: stream,
+		s:    stream,
+		desc: desc,
 	}
 
 	return serverStream, nil
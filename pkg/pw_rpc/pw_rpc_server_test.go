@@ -0,0 +1,181 @@
+package pw_rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// "byakmKet" and "HNHReqVH" have the same 65599 hash.
+const (
+	kCollidingName1 = "byakmKet"
+	kCollidingName2 = "HNHReqVH"
+)
+
+func TestRegisterDetectsCollisions(t *testing.T) {
+	if NewKey(kCollidingName1) != NewKey(kCollidingName2) {
+		t.Fatalf("%q and %q do not collide", kCollidingName1, kCollidingName2)
+	}
+
+	s := NewServer("")
+
+	err := s.Register(&grpc.ServiceDesc{
+		ServiceName: "pw.test.Methods",
+		HandlerType: (*any)(nil),
+		Methods:     []grpc.MethodDesc{{MethodName: kCollidingName1}},
+		Streams:     []grpc.StreamDesc{{StreamName: kCollidingName2}},
+	}, struct{}{})
+	if !errors.Is(err, ErrIdCollision) {
+		t.Fatalf("method collision: got %v, want %v", err, ErrIdCollision)
+	}
+
+	err = s.Register(&grpc.ServiceDesc{ServiceName: kCollidingName1, HandlerType: (*any)(nil)}, struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Register(&grpc.ServiceDesc{ServiceName: kCollidingName2, HandlerType: (*any)(nil)}, struct{}{})
+	if !errors.Is(err, ErrIdCollision) {
+		t.Fatalf("service collision: got %v, want %v", err, ErrIdCollision)
+	}
+
+	err = s.Register(&grpc.ServiceDesc{ServiceName: kCollidingName1, HandlerType: (*any)(nil)}, struct{}{})
+	if !errors.Is(err, ErrDuplicateService) {
+		t.Fatalf("duplicate service: got %v, want %v", err, ErrDuplicateService)
+	}
+}
+
+func TestServerNames(t *testing.T) {
+	s := NewServer("")
+
+	err := s.Register(&grpc.ServiceDesc{
+		ServiceName: "pw.test.Names",
+		HandlerType: (*any)(nil),
+		Methods:     []grpc.MethodDesc{{MethodName: "Unary"}},
+		Streams:     []grpc.StreamDesc{{StreamName: "Stream"}},
+	}, struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serviceId := NewKey("pw.test.Names")
+
+	if name, ok := s.ServiceName(serviceId); !ok || name != "pw.test.Names" {
+		t.Errorf("ServiceName = %q, %t", name, ok)
+	}
+
+	for _, want := range []string{"Unary", "Stream"} {
+		if name, ok := s.MethodName(serviceId, NewKey(want)); !ok || name != want {
+			t.Errorf("MethodName = %q, %t, want %q", name, ok, want)
+		}
+	}
+
+	if _, ok := s.MethodName(serviceId, NewKey("Missing")); ok {
+		t.Errorf("MethodName found an unregistered method")
+	}
+}
+
+type fuzzConn struct{}
+
+func (fuzzConn) Recv(context.Context) error {
+	return nil
+}
+
+func (fuzzConn) Send(context.Context, *pb.RpcPacket) error {
+	return nil
+}
+
+func (fuzzConn) Close() {}
+
+// fuzzStreamHandler echoes until the client stream ends.
+func fuzzStreamHandler(srv any, stream grpc.ServerStream) error {
+	for {
+		in := &pb.RpcPacket{}
+		if err := stream.RecvMsg(in); err != nil {
+			return err
+		}
+
+		if err := stream.SendMsg(in); err != nil {
+			return err
+		}
+	}
+}
+
+// kFuzzService has a method of each kind, whose messages are RpcPackets.
+var kFuzzService = grpc.ServiceDesc{
+	ServiceName: "pw.test.Fuzz",
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Unary",
+		Handler: func(srv any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+			in := &pb.RpcPacket{}
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			return in, nil
+		},
+	}},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "ServerStream", Handler: fuzzStreamHandler, ServerStreams: true},
+		{StreamName: "ClientStream", Handler: fuzzStreamHandler, ClientStreams: true},
+		{StreamName: "Bidi", Handler: fuzzStreamHandler, ServerStreams: true, ClientStreams: true},
+	},
+}
+
+// fuzzSeeds are packets of every type for every method of kFuzzService.
+func fuzzSeeds(f *testing.F) {
+	payload, err := proto.Marshal(&pb.RpcPacket{Payload: []byte("payload")})
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	methods := []string{"Unary", "ServerStream", "ClientStream", "Bidi", "Missing"}
+	for i, method := range methods {
+		for _, pt := range pb.PacketType_value {
+			seed, err := proto.Marshal(&pb.RpcPacket{
+				Type:      pb.PacketType(pt),
+				ChannelId: 1,
+				ServiceId: uint32(NewKey(kFuzzService.ServiceName)),
+				MethodId:  uint32(NewKey(method)),
+				Payload:   payload,
+				CallId:    uint32(i),
+			})
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(seed)
+		}
+	}
+
+	f.Add([]byte{})
+	f.Add([]byte{0x08, 0x7f})
+}
+
+func FuzzServerHandlePacket(f *testing.F) {
+	fuzzSeeds(f)
+
+	s := NewServer("")
+	if err := s.Register(&kFuzzService, struct{}{}); err != nil {
+		f.Fatal(err)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		packet := &pb.RpcPacket{}
+		if err := proto.Unmarshal(data, packet); err != nil {
+			return
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// Open the call first, so that client stream packets find it.
+		open := proto.Clone(packet).(*pb.RpcPacket)
+		open.Type = pb.PacketType_REQUEST
+		s.HandlePacket(ctx, fuzzConn{}, open)
+		s.HandlePacket(ctx, fuzzConn{}, packet)
+	})
+}
@@ -2,20 +2,24 @@ package pw_rpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
 	"google.golang.org/grpc"
-	"google.golang.org/protobuf/proto"
-	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 type Key uint32
 
+// errStreamClosed is the cause of the context of a stream that was closed.
+var errStreamClosed = errors.New("stream closed")
+
 type streamKey struct {
 	serviceId Key
 	methodId  Key
+	callId    uint32
 }
 
 func NewKey(name string) Key {
@@ -35,22 +39,32 @@ func NewStreamKey(serviceName string, methodName string) StreamKey {
 
 type Stream interface {
 	Key() StreamKey
+	// Conn is the connection the stream sends on.
+	Conn() Conn
+	ChannelId() uint32
+	CallId() uint32
 	Context() context.Context
 	Send(any, pb.StatusCode, pb.PacketType) error
 	Recv(any) (pb.PacketType, pb.StatusCode, error)
 	PacketReceived(*pb.RpcPacket)
 	Close()
+	// Abort ends the stream because its call cannot complete. The call fails
+	// with err, which should be a status error.
+	Abort(err error)
 }
 
 type stream struct {
-	conn   Conn
-	desc   *grpc.StreamDesc
-	method string
-	opts   []grpc.CallOption
-	key    StreamKey
-	ch     chan (*pb.RpcPacket)
-	ctx    context.Context
-	cancel context.CancelFunc
+	conn      Conn
+	desc      *grpc.StreamDesc
+	method    string
+	opts      []grpc.CallOption
+	key       StreamKey
+	channelId uint32
+	call      *Call
+	registry  Registry
+	ch        chan (*pb.RpcPacket)
+	ctx       context.Context
+	cancel    context.CancelCauseFunc
 }
 
 func (s *stream) Context() context.Context {
@@ -58,103 +72,151 @@ func (s *stream) Context() context.Context {
 }
 
 func (s *stream) Close() {
+	s.Abort(errStreamClosed)
+}
+
+func (s *stream) Abort(err error) {
 	if s.cancel != nil {
-		s.cancel()
+		s.cancel(err)
 	}
 }
 
+func (s *stream) Conn() Conn {
+	return s.conn
+}
+
 func (s *stream) Key() StreamKey {
 	return s.key
 }
 
+func (s *stream) ChannelId() uint32 {
+	return s.channelId
+}
+
+func (s *stream) CallId() uint32 {
+	return s.key.callId
+}
+
 func NewStream(ctx context.Context, desc *grpc.StreamDesc, conn Conn, method string, opts ...grpc.CallOption) (Stream, error) {
-	methodParts := strings.Split(method, "/")
-	if len(methodParts) != 3 {
-		return nil, fmt.Errorf("invalid full method name")
+	s := &stream{
+		conn:      conn,
+		desc:      desc,
+		method:    method,
+		opts:      opts,
+		channelId: kHDLCChannel,
+		registry:  DefaultRegistry,
+		ch:        make(chan *pb.RpcPacket, 2),
 	}
 
-	serviceName := methodParts[1]
-	methodName := methodParts[2]
-	ctx, cancel := context.WithCancel(ctx)
+	hasIds := false
+	for _, opt := range opts {
+		switch o := opt.(type) {
+		case methodIdsOption:
+			s.key.serviceId = o.serviceId
+			s.key.methodId = o.methodId
+			hasIds = true
+		case channelIdOption:
+			s.channelId = o.channelId
+		case callIdOption:
+			s.key.callId = o.callId
+		case callOption:
+			s.call = o.call
+		case registryOption:
+			s.registry = o.registry
+		}
+	}
 
-	return &stream{
-		conn:   conn,
-		desc:   desc,
-		method: method,
-		opts:   opts,
-		key:    NewStreamKey(serviceName, methodName),
-		ch:     make(chan *pb.RpcPacket, 2),
-		ctx:    ctx,
-		cancel: cancel,
-	}, nil
+	if !hasIds {
+		methodParts := strings.Split(method, "/")
+		if len(methodParts) != 3 {
+			return nil, fmt.Errorf("invalid full method name")
+		}
+
+		s.key.serviceId = NewKey(methodParts[1])
+		s.key.methodId = NewKey(methodParts[2])
+	}
+
+	if s.call != nil {
+		s.call.start(s.key, s.channelId)
+	}
+
+	s.ctx, s.cancel = context.WithCancelCause(ctx)
+
+	return s, nil
 }
 
 func (s *stream) Send(m any, statusCode pb.StatusCode, packetType pb.PacketType) (err error) {
-	payload := []byte{}
-
-	if m != nil {
-		pm, ok := m.(protoreflect.ProtoMessage)
-		if !ok {
-			return fmt.Errorf("message not a ProtoMessage")
-		}
-		payload, err = proto.Marshal(pm)
-		if err != nil {
-			return err
-		}
+	payload, err := marshalPayload(m)
+	if err != nil {
+		return err
 	}
 
 	packet := &pb.RpcPacket{
 		Type:      packetType,
-		ChannelId: kHDLCChannel,
+		ChannelId: s.channelId,
 		ServiceId: uint32(s.key.serviceId),
 		MethodId:  uint32(s.key.methodId),
 		Payload:   payload,
 		Status:    uint32(statusCode),
+		CallId:    s.key.callId,
 	}
 
 	return s.conn.Send(s.ctx, packet)
 }
 
 func (s *stream) Recv(m any) (pb.PacketType, pb.StatusCode, error) {
-	pm, ok := m.(protoreflect.ProtoMessage)
-	if !ok {
-		return pb.PacketType(-1), 0, fmt.Errorf("message not a ProtoMessage")
-	}
-
 	for {
 		select {
 		case <-s.ctx.Done():
 			return pb.PacketType(-1), 0, nil // Not an error to cancel the stream
 		case packet, ok := <-s.ch:
-			if !ok || packet.ChannelId != kHDLCChannel || Key(packet.ServiceId) != s.key.serviceId || Key(packet.MethodId) != s.key.methodId {
-				return pb.PacketType(-1), 0, fmt.Errorf("invalid packet received")
+			if !ok {
+				return pb.PacketType(-1), 0, fmt.Errorf("stream closed")
 			}
 
-			err := proto.Unmarshal(packet.Payload, pm)
+			if packet.ChannelId != s.channelId || Key(packet.ServiceId) != s.key.serviceId || Key(packet.MethodId) != s.key.methodId {
+				return pb.PacketType(-1), 0, fmt.Errorf("invalid packet received: %s", s.registry.FormatPacket(packet))
+			}
+
+			status := pb.StatusCode(packet.Status)
+
+			if s.call != nil && (packet.Type == pb.PacketType_RESPONSE || packet.Type == pb.PacketType_SERVER_ERROR) {
+				s.call.finish(status)
+			}
+
+			err := unmarshalPayload(packet.Payload, m)
 			if err != nil {
-				return packet.Type, 0, err
+				return packet.Type, status, err
 			}
 
-			return packet.Type, 0, nil
+			return packet.Type, status, nil
 		}
 	}
 }
 
+// PacketReceived queues a packet for Recv. Packets for a stream that has
+// ended are dropped, so that they do not hold up the connection.
 func (s *stream) PacketReceived(packet *pb.RpcPacket) {
-	s.ch <- packet
+	select {
+	case s.ch <- packet:
+	case <-s.ctx.Done():
+	}
 }
 
 type streamsMap map[StreamKey]Stream
 
 type StreamManager interface {
-	GetStream(serviceId Key, methodId Key) Stream
+	GetStream(serviceId Key, methodId Key, callId uint32) Stream
 	AddStream(Stream)
 	RemoveStream(Stream)
+	// Abort ends the streams on conn with err and removes them.
+	Abort(conn Conn, err error)
 	Reset()
 }
 
 type streamManager struct {
 	streams streamsMap
+	mu      sync.Mutex
 }
 
 func NewStreamManager() StreamManager {
@@ -163,36 +225,80 @@ func NewStreamManager() StreamManager {
 	}
 }
 
-func (sm *streamManager) GetStream(serviceId Key, methodId Key) Stream {
-	return sm.streams[StreamKey{
+// GetStream finds the stream of a call. Peers that predate call ids send a
+// call_id of zero, which matches any call of the method.
+func (sm *streamManager) GetStream(serviceId Key, methodId Key, callId uint32) Stream {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	s, ok := sm.streams[StreamKey{
 		serviceId: serviceId,
 		methodId:  methodId,
+		callId:    callId,
 	}]
+	if ok || callId != 0 {
+		return s
+	}
+
+	for key, s := range sm.streams {
+		if key.serviceId == serviceId && key.methodId == methodId {
+			return s
+		}
+	}
+
+	return nil
 }
 
 func (sm *streamManager) AddStream(s Stream) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
 	sm.streams[s.Key()] = s
 }
 
 func (sm *streamManager) RemoveStream(s Stream) {
 	s.Close()
 
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
 	delete(sm.streams, s.Key())
 }
 
-func (sm *streamManager) Reset() {
-	for key := range sm.streams {
-		s := sm.streams[key]
-		sm.RemoveStream(s)
+func (sm *streamManager) Abort(conn Conn, err error) {
+	sm.mu.Lock()
+	var aborted []Stream
+	for key, s := range sm.streams {
+		if s.Conn() == conn {
+			aborted = append(aborted, s)
+			delete(sm.streams, key)
+		}
+	}
+	sm.mu.Unlock()
+
+	for _, s := range aborted {
+		s.Abort(err)
 	}
+}
+
+func (sm *streamManager) Reset() {
+	sm.mu.Lock()
+	streams := sm.streams
 	sm.streams = make(streamsMap)
+	sm.mu.Unlock()
+
+	for _, s := range streams {
+		s.Close()
+	}
 }
 
+// hash is Pigweed's 65599 hash. It works on bytes, not runes, to match the
+// C++ and Python implementations for names that are not ASCII.
 func hash(s string) uint32 {
 	hash := uint32(len(s))
 	coefficient := k65599HashConstant
-	for _, ch := range s {
-		hash += coefficient * uint32(ch)
+	for i := 0; i < len(s); i++ {
+		hash += coefficient * uint32(s[i])
 		coefficient *= k65599HashConstant
 	}
 
@@ -0,0 +1,266 @@
+package pw_rpc
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Registry maps service and method ids back to their names, so that
+// diagnostics can show pkg.Service/Method instead of raw hashes.
+type Registry interface {
+	// RegisterServiceDesc adds the names of a gRPC service description.
+	RegisterServiceDesc(*grpc.ServiceDesc) error
+	// RegisterFile adds the services of a descriptor file. Their message
+	// types are used to decode packet payloads.
+	RegisterFile(protoreflect.FileDescriptor) error
+	ServiceName(serviceId Key) (string, bool)
+	MethodName(serviceId Key, methodId Key) (string, bool)
+	// Describe names a method as "pkg.Service/Method", using the raw ids for
+	// anything that is not registered.
+	Describe(serviceId Key, methodId Key) string
+	// FormatPacket returns a readable description of a packet.
+	FormatPacket(*pb.RpcPacket) string
+}
+
+// DefaultRegistry is the registry every client and server falls back to.
+var DefaultRegistry = NewRegistry(nil)
+
+type registeredService struct {
+	name    string
+	methods map[Key]string
+	desc    protoreflect.ServiceDescriptor
+}
+
+type registry struct {
+	parent   Registry
+	services map[Key]*registeredService
+	mu       sync.Mutex
+}
+
+// NewRegistry creates a registry that consults parent for ids it does not
+// know itself. parent may be nil.
+func NewRegistry(parent Registry) Registry {
+	return &registry{
+		parent:   parent,
+		services: make(map[Key]*registeredService),
+	}
+}
+
+// register adds a service and its methods. Nothing is added if any id
+// collides with a different name.
+func (r *registry) register(name string, methods []string, desc protoreflect.ServiceDescriptor) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	serviceId := NewKey(name)
+
+	service, ok := r.services[serviceId]
+	if ok && service.name != name {
+		return fmt.Errorf("%w: services %q and %q have id 0x%08x", ErrIdCollision, service.name, name, uint32(serviceId))
+	}
+
+	names := make(map[Key]string)
+	if ok {
+		for methodId, methodName := range service.methods {
+			names[methodId] = methodName
+		}
+	}
+
+	for _, methodName := range methods {
+		methodId := NewKey(methodName)
+		if other, ok := names[methodId]; ok && other != methodName {
+			return fmt.Errorf("%w: methods %q and %q of %q have id 0x%08x", ErrIdCollision, other, methodName, name, uint32(methodId))
+		}
+		names[methodId] = methodName
+	}
+
+	if !ok {
+		service = &registeredService{name: name}
+		r.services[serviceId] = service
+	}
+	service.methods = names
+	if desc != nil {
+		service.desc = desc
+	}
+
+	return nil
+}
+
+func (r *registry) RegisterServiceDesc(sd *grpc.ServiceDesc) error {
+	var methods []string
+
+	for _, m := range sd.Methods {
+		methods = append(methods, m.MethodName)
+	}
+	for _, s := range sd.Streams {
+		methods = append(methods, s.StreamName)
+	}
+
+	return r.register(sd.ServiceName, methods, nil)
+}
+
+func (r *registry) RegisterFile(fd protoreflect.FileDescriptor) error {
+	for i := 0; i < fd.Services().Len(); i++ {
+		sd := fd.Services().Get(i)
+
+		var methods []string
+		for j := 0; j < sd.Methods().Len(); j++ {
+			methods = append(methods, string(sd.Methods().Get(j).Name()))
+		}
+
+		if err := r.register(string(sd.FullName()), methods, sd); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *registry) ServiceName(serviceId Key) (string, bool) {
+	r.mu.Lock()
+	service, ok := r.services[serviceId]
+	r.mu.Unlock()
+
+	if ok {
+		return service.name, true
+	}
+
+	if r.parent != nil {
+		return r.parent.ServiceName(serviceId)
+	}
+
+	return "", false
+}
+
+func (r *registry) MethodName(serviceId Key, methodId Key) (string, bool) {
+	r.mu.Lock()
+	service, ok := r.services[serviceId]
+	if ok {
+		name, found := service.methods[methodId]
+		r.mu.Unlock()
+		if found {
+			return name, true
+		}
+	} else {
+		r.mu.Unlock()
+	}
+
+	if r.parent != nil {
+		return r.parent.MethodName(serviceId, methodId)
+	}
+
+	return "", false
+}
+
+func (r *registry) Describe(serviceId Key, methodId Key) string {
+	serviceName, ok := r.ServiceName(serviceId)
+	if !ok {
+		serviceName = fmt.Sprintf("0x%08x", uint32(serviceId))
+	}
+
+	methodName, ok := r.MethodName(serviceId, methodId)
+	if !ok {
+		methodName = fmt.Sprintf("0x%08x", uint32(methodId))
+	}
+
+	return serviceName + "/" + methodName
+}
+
+// methodDescriptor finds the descriptor of a method, from a registered file
+// or from the descriptors linked into the binary.
+func (r *registry) methodDescriptor(serviceId Key, methodId Key) protoreflect.MethodDescriptor {
+	r.mu.Lock()
+	service, ok := r.services[serviceId]
+	var sd protoreflect.ServiceDescriptor
+	if ok {
+		sd = service.desc
+	}
+	r.mu.Unlock()
+
+	if sd == nil {
+		name, ok := r.ServiceName(serviceId)
+		if !ok {
+			return nil
+		}
+
+		d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			return nil
+		}
+
+		sd, ok = d.(protoreflect.ServiceDescriptor)
+		if !ok {
+			return nil
+		}
+	}
+
+	name, ok := r.MethodName(serviceId, methodId)
+	if !ok {
+		return nil
+	}
+
+	return sd.Methods().ByName(protoreflect.Name(name))
+}
+
+// decodePayload renders a payload as text when its message type is known.
+func (r *registry) decodePayload(packet *pb.RpcPacket) (string, bool) {
+	md := r.methodDescriptor(Key(packet.ServiceId), Key(packet.MethodId))
+	if md == nil {
+		return "", false
+	}
+
+	var desc protoreflect.MessageDescriptor
+	switch packet.Type {
+	case pb.PacketType_REQUEST, pb.PacketType_CLIENT_STREAM:
+		desc = md.Input()
+	case pb.PacketType_RESPONSE, pb.PacketType_SERVER_STREAM:
+		desc = md.Output()
+	default:
+		return "", false
+	}
+
+	m := dynamicpb.NewMessage(desc)
+	if err := proto.Unmarshal(packet.Payload, m); err != nil {
+		return "", false
+	}
+
+	return prototext.MarshalOptions{}.Format(m), true
+}
+
+func (r *registry) FormatPacket(packet *pb.RpcPacket) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s %s channel=%d call_id=%d",
+		packet.Type,
+		r.Describe(Key(packet.ServiceId), Key(packet.MethodId)),
+		packet.ChannelId,
+		packet.CallId)
+
+	if packet.Status != 0 || packet.Type == pb.PacketType_RESPONSE || packet.Type == pb.PacketType_SERVER_ERROR {
+		fmt.Fprintf(&b, " status=%s", pb.StatusCode(packet.Status))
+	}
+
+	if len(packet.Payload) > 0 {
+		if text, ok := r.decodePayload(packet); ok {
+			fmt.Fprintf(&b, " {%s}", text)
+		} else {
+			fmt.Fprintf(&b, " payload=%x", packet.Payload)
+		}
+	}
+
+	return b.String()
+}
+
+// FormatPacket describes a packet using the DefaultRegistry.
+func FormatPacket(packet *pb.RpcPacket) string {
+	return DefaultRegistry.FormatPacket(packet)
+}
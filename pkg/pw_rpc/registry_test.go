@@ -0,0 +1,107 @@
+package pw_rpc_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	cmdpb "github.com/robertfarnum/go-pw-rpc/cmd/pb"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_hdlc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRegistryFormatPacket(t *testing.T) {
+	r := pw_rpc.NewRegistry(nil)
+	if err := r.RegisterFile(cmdpb.File_benchmark_proto); err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := proto.Marshal(&cmdpb.Payload{Payload: []byte("hi")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := r.FormatPacket(&pb.RpcPacket{
+		Type:      pb.PacketType_RESPONSE,
+		ChannelId: 1,
+		ServiceId: uint32(cmdpb.Benchmark_ServiceId),
+		MethodId:  uint32(cmdpb.Benchmark_UnaryEcho_MethodId),
+		CallId:    7,
+		Payload:   payload,
+	})
+
+	for _, want := range []string{"RESPONSE", "pw.rpc.Benchmark/UnaryEcho", "call_id=7", "status=OK", `payload:"hi"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("FormatPacket = %q, missing %q", got, want)
+		}
+	}
+}
+
+func TestRegistryFallback(t *testing.T) {
+	parent := pw_rpc.NewRegistry(nil)
+	if err := parent.RegisterServiceDesc(&cmdpb.Benchmark_ServiceDesc); err != nil {
+		t.Fatal(err)
+	}
+
+	r := pw_rpc.NewRegistry(parent)
+
+	got := r.Describe(cmdpb.Benchmark_ServiceId, cmdpb.Benchmark_BidirectionalEcho_MethodId)
+	if got != "pw.rpc.Benchmark/BidirectionalEcho" {
+		t.Errorf("Describe = %q", got)
+	}
+
+	got = r.Describe(pw_rpc.NewKey("pw.rpc.Unknown"), cmdpb.Benchmark_UnaryEcho_MethodId)
+	if got != "0x16a36ea8/0x024e8b55" {
+		t.Errorf("Describe = %q", got)
+	}
+}
+
+func TestStreamErrorsUseClientRegistry(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	host, device := net.Pipe()
+	defer device.Close()
+
+	c := pw_rpc.NewClientWithDialer(func(context.Context) (io.ReadWriteCloser, error) {
+		return host, nil
+	})
+	defer c.Close()
+
+	sd := &grpc.ServiceDesc{ServiceName: "pw.test.ClientOnly", Methods: []grpc.MethodDesc{{MethodName: "Unary"}}}
+	if err := c.Registry().RegisterServiceDesc(sd); err != nil {
+		t.Fatal(err)
+	}
+
+	// The device answers on the wrong channel.
+	go func() {
+		frame, err := pw_hdlc.NewDecoder(device, kRpcAddress).Decode(ctx)
+		if err != nil {
+			return
+		}
+		request := &pb.RpcPacket{}
+		if err := proto.Unmarshal(frame.Payload(), request); err != nil {
+			return
+		}
+		response, _ := proto.Marshal(&pb.RpcPacket{
+			Type:      pb.PacketType_RESPONSE,
+			ChannelId: request.ChannelId + 1,
+			ServiceId: request.ServiceId,
+			MethodId:  request.MethodId,
+			CallId:    request.CallId,
+		})
+		pw_hdlc.NewEncoder(device, kRpcAddress).Encode(response)
+	}()
+
+	reply := pw_rpc.RawMessage{}
+	err := c.Invoke(ctx, "/pw.test.ClientOnly/Unary", pw_rpc.RawMessage("ping"), &reply)
+	if err == nil || !strings.Contains(err.Error(), "pw.test.ClientOnly/Unary") {
+		t.Errorf("Invoke = %v, want an error naming pw.test.ClientOnly/Unary", err)
+	}
+}
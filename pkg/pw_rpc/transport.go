@@ -0,0 +1,272 @@
+package pw_rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_serial"
+)
+
+var (
+	ErrUnknownScheme   = errors.New("unknown endpoint scheme")
+	ErrDuplicateScheme = errors.New("duplicate endpoint scheme")
+	ErrBadEndpoint     = errors.New("bad endpoint")
+)
+
+// Transport connects clients and servers to the endpoints of a URI scheme.
+// Endpoints are parsed with ParseEndpoint.
+type Transport interface {
+	Dial(ctx context.Context, endpoint *url.URL) (io.ReadWriteCloser, error)
+	Listen(ctx context.Context, endpoint *url.URL) (Listener, error)
+}
+
+// Listener accepts the connections of a server. Accept fails with
+// net.ErrClosed once the listener is closed.
+type Listener interface {
+	Accept() (io.ReadWriteCloser, error)
+	Close() error
+	Addr() string
+}
+
+var transports = struct {
+	m  map[string]Transport
+	mu sync.RWMutex
+}{
+	m: map[string]Transport{
+		"tcp":    netTransport{network: "tcp"},
+		"unix":   netTransport{network: "unix"},
+		"serial": serialTransport{},
+		"udp":    udpTransport{},
+		"ws":     wsTransport{},
+		"wss":    wsTransport{},
+	},
+}
+
+// RegisterTransport adds the transport of an endpoint scheme, so that
+// NewClient and NewServer accept endpoints like scheme://...
+func RegisterTransport(scheme string, t Transport) error {
+	transports.mu.Lock()
+	defer transports.mu.Unlock()
+
+	if _, ok := transports.m[scheme]; ok {
+		return fmt.Errorf("%w: %q", ErrDuplicateScheme, scheme)
+	}
+
+	transports.m[scheme] = t
+
+	return nil
+}
+
+func lookupTransport(scheme string) (Transport, bool) {
+	transports.mu.RLock()
+	defer transports.mu.RUnlock()
+
+	t, ok := transports.m[scheme]
+
+	return t, ok
+}
+
+// ParseEndpoint parses an endpoint URI, such as tcp://host:port,
+// unix:///run/dev.sock, unix:@abstract, serial:///dev/ttyUSB0?baud=115200,
+// udp://host:port or ws://host:port/path.
+// An endpoint without a registered scheme is a TCP host:port.
+func ParseEndpoint(endpoint string) (*url.URL, Transport, error) {
+	u, err := url.Parse(endpoint)
+	if err == nil {
+		if t, ok := lookupTransport(u.Scheme); ok {
+			return u, t, nil
+		}
+	}
+
+	if strings.Contains(endpoint, "://") {
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrBadEndpoint, err)
+		}
+		return nil, nil, fmt.Errorf("%w: %q", ErrUnknownScheme, u.Scheme)
+	}
+
+	t, _ := lookupTransport("tcp")
+
+	return &url.URL{Scheme: "tcp", Host: endpoint}, t, nil
+}
+
+// Dial connects to endpoint with the transport of its scheme.
+func Dial(ctx context.Context, endpoint string) (io.ReadWriteCloser, error) {
+	u, t, err := ParseEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.Dial(ctx, u)
+}
+
+// Listen listens on endpoint with the transport of its scheme.
+func Listen(ctx context.Context, endpoint string) (Listener, error) {
+	u, t, err := ParseEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.Listen(ctx, u)
+}
+
+// netTransport is a stream socket transport of the net package.
+type netTransport struct {
+	network string
+}
+
+// address returns the net address of endpoint: host:port for TCP and the
+// path for Unix sockets, where a leading @ names an abstract socket.
+func (t netTransport) address(endpoint *url.URL) (string, error) {
+	address := endpoint.Host
+	if t.network == "unix" {
+		address = endpoint.Path
+		if endpoint.Opaque != "" {
+			address = endpoint.Opaque
+		}
+	}
+
+	if address == "" {
+		return "", fmt.Errorf("%w: no address in %q", ErrBadEndpoint, endpoint)
+	}
+
+	return address, nil
+}
+
+func (t netTransport) Dial(ctx context.Context, endpoint *url.URL) (io.ReadWriteCloser, error) {
+	address, err := t.address(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var d net.Dialer
+
+	return d.DialContext(ctx, t.network, address)
+}
+
+func (t netTransport) Listen(ctx context.Context, endpoint *url.URL) (Listener, error) {
+	address, err := t.address(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	// A socket file left by a server that exited is replaced.
+	if t.network == "unix" && !strings.HasPrefix(address, "@") {
+		if info, err := os.Stat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(address)
+		}
+	}
+
+	var lc net.ListenConfig
+
+	lis, err := lc.Listen(ctx, t.network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return netListener{lis}, nil
+}
+
+type netListener struct {
+	net.Listener
+}
+
+func (l netListener) Accept() (io.ReadWriteCloser, error) {
+	return l.Listener.Accept()
+}
+
+func (l netListener) Addr() string {
+	return l.Listener.Addr().String()
+}
+
+// serialTransport opens a serial port in raw mode, with the line settings
+// of the endpoint's query. See pw_serial.ParseConfig.
+type serialTransport struct{}
+
+func (serialTransport) Dial(ctx context.Context, endpoint *url.URL) (io.ReadWriteCloser, error) {
+	if endpoint.Path == "" {
+		return nil, fmt.Errorf("%w: no device in %q", ErrBadEndpoint, endpoint)
+	}
+
+	config, err := pw_serial.ParseConfig(endpoint.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	return pw_serial.Open(endpoint.Path, config)
+}
+
+// Listen serves the device as a single connection. Once that connection is
+// closed the next Accept opens the device again, e.g. after it was replugged.
+func (t serialTransport) Listen(ctx context.Context, endpoint *url.URL) (Listener, error) {
+	return newDeviceListener(ctx, endpoint, t.Dial), nil
+}
+
+// deviceListener accepts one connection at a time to a device.
+type deviceListener struct {
+	ctx      context.Context
+	endpoint *url.URL
+	open     func(context.Context, *url.URL) (io.ReadWriteCloser, error)
+	idle     chan struct{}
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func newDeviceListener(ctx context.Context, endpoint *url.URL, open func(context.Context, *url.URL) (io.ReadWriteCloser, error)) Listener {
+	l := &deviceListener{
+		ctx:      ctx,
+		endpoint: endpoint,
+		open:     open,
+		idle:     make(chan struct{}, 1),
+		closed:   make(chan struct{}),
+	}
+	l.idle <- struct{}{}
+
+	return l
+}
+
+func (l *deviceListener) Accept() (io.ReadWriteCloser, error) {
+	select {
+	case <-l.idle:
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+
+	rwc, err := l.open(l.ctx, l.endpoint)
+	if err != nil {
+		l.idle <- struct{}{}
+		return nil, err
+	}
+
+	return &deviceConn{ReadWriteCloser: rwc, idle: l.idle}, nil
+}
+
+func (l *deviceListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *deviceListener) Addr() string {
+	return l.endpoint.String()
+}
+
+// deviceConn lets its listener accept again when it is closed.
+type deviceConn struct {
+	io.ReadWriteCloser
+	idle chan struct{}
+	once sync.Once
+}
+
+func (c *deviceConn) Close() error {
+	err := c.ReadWriteCloser.Close()
+	c.once.Do(func() { c.idle <- struct{}{} })
+
+	return err
+}
@@ -0,0 +1,186 @@
+package pw_rpc_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpctest"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_serial"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pwsim"
+)
+
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     url.URL
+	}{
+		{"localhost:8111", url.URL{Scheme: "tcp", Host: "localhost:8111"}},
+		{"127.0.0.1:8111", url.URL{Scheme: "tcp", Host: "127.0.0.1:8111"}},
+		{"[::1]:8111", url.URL{Scheme: "tcp", Host: "[::1]:8111"}},
+		{"tcp://device:33000", url.URL{Scheme: "tcp", Host: "device:33000"}},
+		{"unix:///run/dev.sock", url.URL{Scheme: "unix", Path: "/run/dev.sock"}},
+		{"unix:@pw_rpc", url.URL{Scheme: "unix", Opaque: "@pw_rpc"}},
+		{"serial:///dev/ttyUSB0?baud=115200", url.URL{Scheme: "serial", Path: "/dev/ttyUSB0", RawQuery: "baud=115200"}},
+	}
+
+	for _, test := range tests {
+		got, _, err := pw_rpc.ParseEndpoint(test.endpoint)
+		if err != nil {
+			t.Errorf("ParseEndpoint(%q): %s", test.endpoint, err)
+			continue
+		}
+		if *got != test.want {
+			t.Errorf("ParseEndpoint(%q) = %#v, want %#v", test.endpoint, *got, test.want)
+		}
+	}
+
+	if _, _, err := pw_rpc.ParseEndpoint("bogus://x"); !errors.Is(err, pw_rpc.ErrUnknownScheme) {
+		t.Errorf("ParseEndpoint(bogus) = %v, want %v", err, pw_rpc.ErrUnknownScheme)
+	}
+}
+
+// echo serves an echo server on endpoint and makes a unary call to it from a
+// client of the same endpoint.
+func echo(t *testing.T, endpoint string) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s, err := pw_rpctest.NewEchoServer(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.Listen(ctx) }()
+
+	// The client waits a second between attempts, so wait for the listener.
+	for {
+		rwc, err := pw_rpc.Dial(ctx, endpoint)
+		if err == nil {
+			rwc.Close()
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	c := pw_rpc.NewClient(endpoint)
+	defer c.Close()
+
+	if err := pw_rpctest.Echo(ctx, c, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Close()
+	if err := <-done; err != nil {
+		t.Errorf("Listen: %s", err)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	echo(t, "unix://"+filepath.Join(t.TempDir(), "dev.sock"))
+}
+
+func TestAbstractUnixSocket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract sockets are Linux only")
+	}
+
+	echo(t, fmt.Sprintf("unix:@pw_rpc_test_%d", time.Now().UnixNano()))
+}
+
+func TestSerialEndpoint(t *testing.T) {
+	s := pw_rpc.NewServer("")
+	if err := s.Register(&kConformanceServiceDesc, struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	sim, err := pwsim.NewPty(s, pwsim.Impairments{})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer sim.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go sim.Serve(ctx)
+
+	bad := []struct {
+		query string
+		err   error
+	}{
+		{"bogus=1", pw_serial.ErrBadConfig},
+		{"baud=fast", pw_serial.ErrBadConfig},
+		{"baud=12345", pw_serial.ErrUnsupportedBaud},
+	}
+	for _, test := range bad {
+		if _, err := pw_rpc.Dial(ctx, "serial://"+sim.Path()+"?"+test.query); !errors.Is(err, test.err) {
+			t.Errorf("Dial(?%s) = %v, want %v", test.query, err, test.err)
+		}
+	}
+
+	c := pw_rpc.NewClient("serial://" + sim.Path() + "?baud=115200")
+	defer c.Close()
+
+	reply := pw_rpc.RawMessage{}
+	if err := c.Invoke(ctx, method("Unary"), pw_rpc.RawMessage("ping"), &reply); err != nil || string(reply) != "ping" {
+		t.Errorf("Invoke = %q, %v", reply, err)
+	}
+}
+
+// pipeTransport connects to the server passed in the endpoint's host.
+type pipeTransport struct {
+	servers map[string]pw_rpc.Server
+}
+
+func (p pipeTransport) Dial(ctx context.Context, endpoint *url.URL) (io.ReadWriteCloser, error) {
+	host, device := net.Pipe()
+	go p.servers[endpoint.Host].Serve(context.WithoutCancel(ctx), device)
+
+	return host, nil
+}
+
+func (p pipeTransport) Listen(ctx context.Context, endpoint *url.URL) (pw_rpc.Listener, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func TestRegisterTransport(t *testing.T) {
+	s := pw_rpc.NewServer("")
+	if err := s.Register(&kConformanceServiceDesc, struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Transports stay registered, so each run needs its own scheme.
+	scheme := fmt.Sprintf("test-pipe-%d", time.Now().UnixNano())
+
+	transport := pipeTransport{servers: map[string]pw_rpc.Server{"echo": s}}
+	if err := pw_rpc.RegisterTransport(scheme, transport); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pw_rpc.RegisterTransport(scheme, transport); !errors.Is(err, pw_rpc.ErrDuplicateScheme) {
+		t.Errorf("second RegisterTransport = %v, want %v", err, pw_rpc.ErrDuplicateScheme)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c := pw_rpc.NewClient(scheme + "://echo")
+	defer c.Close()
+
+	reply := pw_rpc.RawMessage{}
+	if err := c.Invoke(ctx, method("Unary"), pw_rpc.RawMessage("ping"), &reply); err != nil || string(reply) != "ping" {
+		t.Errorf("Invoke = %q, %v", reply, err)
+	}
+}
@@ -0,0 +1,157 @@
+package pw_rpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// NewWebSocketHandler upgrades HTTP requests to WebSocket connections and
+// serves each with serve, e.g. a Server's Serve. Binary messages carry the
+// same HDLC byte stream as the other transports, so message boundaries do
+// not matter.
+//
+// Connections are accepted from any origin; wrap the handler to check the
+// request before it is upgraded.
+func NewWebSocketHandler(serve func(ctx context.Context, rwc io.ReadWriteCloser) error) http.Handler {
+	return websocket.Server{
+		Handler: func(ws *websocket.Conn) {
+			ws.PayloadType = websocket.BinaryFrame
+
+			// The client sees the connection close when serve fails.
+			serve(ws.Request().Context(), ws)
+		},
+	}
+}
+
+// wsTransport connects to ws:// and wss:// endpoints. Servers listen on
+// ws:// endpoints only; serve wss:// with NewWebSocketHandler behind an
+// HTTPS server.
+type wsTransport struct{}
+
+func (wsTransport) Dial(ctx context.Context, endpoint *url.URL) (io.ReadWriteCloser, error) {
+	if endpoint.Host == "" {
+		return nil, fmt.Errorf("%w: no address in %q", ErrBadEndpoint, endpoint)
+	}
+
+	origin := &url.URL{Scheme: "http", Host: endpoint.Host}
+	if endpoint.Scheme == "wss" {
+		origin.Scheme = "https"
+	}
+
+	config, err := websocket.NewConfig(endpoint.String(), origin.String())
+	if err != nil {
+		return nil, err
+	}
+
+	ws, err := config.DialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ws.PayloadType = websocket.BinaryFrame
+
+	return ws, nil
+}
+
+func (wsTransport) Listen(ctx context.Context, endpoint *url.URL) (Listener, error) {
+	if endpoint.Scheme != "ws" {
+		return nil, fmt.Errorf("%w: cannot listen on %q", ErrBadEndpoint, endpoint)
+	}
+	if endpoint.Host == "" {
+		return nil, fmt.Errorf("%w: no address in %q", ErrBadEndpoint, endpoint)
+	}
+
+	var lc net.ListenConfig
+
+	lis, err := lc.Listen(ctx, "tcp", endpoint.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	path := endpoint.Path
+	if path == "" {
+		path = "/"
+	}
+
+	l := &wsListener{
+		lis:    lis,
+		conns:  make(chan *wsConn),
+		closed: make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, NewWebSocketHandler(l.handoff))
+	l.server = &http.Server{Handler: mux}
+
+	go l.server.Serve(lis)
+
+	return l, nil
+}
+
+// wsListener accepts the WebSocket connections of an HTTP server.
+type wsListener struct {
+	lis    net.Listener
+	server *http.Server
+	conns  chan *wsConn
+	closed chan struct{}
+	once   sync.Once
+}
+
+// handoff passes a connection to Accept and holds its handler until the
+// connection is closed, as the WebSocket ends when its handler returns.
+func (l *wsListener) handoff(ctx context.Context, rwc io.ReadWriteCloser) error {
+	conn := &wsConn{ReadWriteCloser: rwc, done: make(chan struct{})}
+
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		return net.ErrClosed
+	}
+
+	<-conn.done
+
+	return nil
+}
+
+func (l *wsListener) Accept() (io.ReadWriteCloser, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting connections. Accepted connections stay open.
+func (l *wsListener) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.closed)
+		err = l.server.Close()
+	})
+
+	return err
+}
+
+func (l *wsListener) Addr() string {
+	return l.lis.Addr().String()
+}
+
+type wsConn struct {
+	io.ReadWriteCloser
+	done chan struct{}
+	once sync.Once
+}
+
+func (c *wsConn) Close() error {
+	err := c.ReadWriteCloser.Close()
+	c.once.Do(func() { close(c.done) })
+
+	return err
+}
@@ -0,0 +1,87 @@
+package pw_rpc_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+)
+
+func TestWebSocketHandler(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s := pw_rpc.NewServer("")
+	if err := s.Register(&kConformanceServiceDesc, struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(pw_rpc.NewWebSocketHandler(s.Serve))
+	defer ts.Close()
+
+	c := pw_rpc.NewClient("ws" + strings.TrimPrefix(ts.URL, "http") + "/rpc")
+	defer c.Close()
+
+	// Payloads with HDLC flag and escape bytes, large enough to span
+	// several WebSocket messages.
+	payload := pw_rpc.RawMessage(strings.Repeat("~}ping", 1000))
+
+	reply := pw_rpc.RawMessage{}
+	if err := c.Invoke(ctx, method("Unary"), payload, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != string(payload) {
+		t.Errorf("reply of %d bytes, want %d", len(reply), len(payload))
+	}
+
+	stream, err := c.NewStream(ctx, kServerStreamDesc, method("ServerStream"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.SendMsg(pw_rpc.RawMessage("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for {
+		msg := pw_rpc.RawMessage{}
+		if err := stream.RecvMsg(&msg); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(msg))
+	}
+	if strings.Join(got, ",") != "a,b,c" {
+		t.Errorf("server stream = %q", got)
+	}
+}
+
+func TestWebSocketListen(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := lis.Addr().String()
+	lis.Close()
+
+	// Run twice, so that the port is released when the server closes.
+	echo(t, "ws://"+address+"/rpc")
+	echo(t, "ws://"+address+"/rpc")
+}
+
+func TestWebSocketListenRejectsWss(t *testing.T) {
+	_, err := pw_rpc.Listen(context.Background(), "wss://localhost:0/rpc")
+	if !errors.Is(err, pw_rpc.ErrBadEndpoint) {
+		t.Errorf("Listen(wss) = %v, want %v", err, pw_rpc.ErrBadEndpoint)
+	}
+}
@@ -0,0 +1,67 @@
+package pw_rpctest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	cmdpb "github.com/robertfarnum/go-pw-rpc/cmd/pb"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"google.golang.org/grpc"
+)
+
+var (
+	ErrEchoMismatch = errors.New("echoed payload differs")
+)
+
+// EchoServer serves the pw.rpc.Benchmark service by sending each request
+// back as its response.
+type EchoServer struct {
+	cmdpb.UnimplementedBenchmarkServer
+}
+
+func (EchoServer) UnaryEcho(ctx context.Context, in *cmdpb.Payload) (*cmdpb.Payload, error) {
+	return in, nil
+}
+
+func (EchoServer) BidirectionalEcho(stream cmdpb.Benchmark_BidirectionalEchoServer) error {
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := stream.Send(in); err != nil {
+			return err
+		}
+	}
+}
+
+// NewEchoServer returns a server for endpoint with an EchoServer registered.
+func NewEchoServer(endpoint string) (pw_rpc.Server, error) {
+	server := pw_rpc.NewServer(endpoint)
+	if err := server.Register(&cmdpb.Benchmark_ServiceDesc, EchoServer{}); err != nil {
+		return nil, err
+	}
+
+	return server, nil
+}
+
+// Echo makes a UnaryEcho call with payload on cc. A response other than the
+// payload fails with ErrEchoMismatch.
+func Echo(ctx context.Context, cc grpc.ClientConnInterface, payload []byte) error {
+	res, err := cmdpb.NewBenchmarkClient(cc).UnaryEcho(ctx, &cmdpb.Payload{Payload: payload})
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(res.Payload, payload) {
+		return fmt.Errorf("%w: sent %q, received %q", ErrEchoMismatch, payload, res.Payload)
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+// Package pw_rpctest connects pw_rpc clients to servers and fake devices in
+// the same process, for tests that should not need a device or a socket.
+package pw_rpctest
+
+import (
+	"context"
+	"io"
+	"net"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+)
+
+// ServeFunc handles the device side of a connection until it fails or ctx is
+// done. Server.Serve is a ServeFunc.
+type ServeFunc func(ctx context.Context, rwc io.ReadWriteCloser) error
+
+// Dialer returns a pw_rpc.Dialer that connects to serve over an in-memory
+// pipe. Each dial starts serve on a new pipe, which it runs until ctx is done.
+// If serve fails, the pipe is closed and the client sees the connection drop.
+func Dialer(ctx context.Context, serve ServeFunc) pw_rpc.Dialer {
+	return func(context.Context) (io.ReadWriteCloser, error) {
+		host, device := net.Pipe()
+
+		go func() {
+			defer device.Close()
+
+			serve(ctx, device)
+		}()
+
+		return host, nil
+	}
+}
+
+// NewClient returns a client connected to serve.
+func NewClient(ctx context.Context, serve ServeFunc) (pw_rpc.Client, error) {
+	c := pw_rpc.NewClientWithDialer(Dialer(ctx, serve))
+
+	if err := c.Connect(ctx); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// NewServerClient returns a client connected to server.
+func NewServerClient(ctx context.Context, server pw_rpc.Server) (pw_rpc.Client, error) {
+	return NewClient(ctx, server.Serve)
+}
@@ -0,0 +1,49 @@
+package pw_serial
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		config Config
+		mask   uint32
+		want   uint32
+	}{
+		{Config{}, unix.CSIZE | unix.PARENB | unix.CSTOPB | unix.CRTSCTS, unix.CS8},
+		{Config{DataBits: 7, Parity: ParityEven}, unix.CSIZE | unix.PARENB | unix.PARODD, unix.CS7 | unix.PARENB},
+		{Config{DataBits: 5, Parity: ParityOdd}, unix.CSIZE | unix.PARENB | unix.PARODD, unix.CS5 | unix.PARENB | unix.PARODD},
+		{Config{StopBits: 2, FlowControl: true}, unix.CSTOPB | unix.CRTSCTS, unix.CSTOPB | unix.CRTSCTS},
+		{Config{BaudRate: 921600}, unix.CBAUD, unix.B921600},
+	}
+
+	for _, test := range tests {
+		// Start from a cooked 7E1 terminal with flow control.
+		termios := &unix.Termios{
+			Iflag: unix.ICRNL | unix.IXON,
+			Oflag: unix.OPOST,
+			Lflag: unix.ICANON | unix.ECHO | unix.ISIG,
+			Cflag: unix.B9600 | unix.CS7 | unix.PARENB | unix.CRTSCTS,
+		}
+
+		if err := apply(termios, test.config); err != nil {
+			t.Errorf("apply(%+v): %s", test.config, err)
+			continue
+		}
+
+		if termios.Cflag&test.mask != test.want {
+			t.Errorf("apply(%+v): Cflag&%#x = %#x, want %#x", test.config, test.mask, termios.Cflag&test.mask, test.want)
+		}
+
+		wantInpck := test.config.Parity != ParityNone
+		if termios.Iflag&unix.INPCK != 0 != wantInpck {
+			t.Errorf("apply(%+v): INPCK = %t, want %t", test.config, !wantInpck, wantInpck)
+		}
+
+		if termios.Lflag&(unix.ICANON|unix.ECHO|unix.ISIG) != 0 || termios.Oflag&unix.OPOST != 0 || termios.Iflag&(unix.ICRNL|unix.IXON) != 0 {
+			t.Errorf("apply(%+v): not raw: %+v", test.config, termios)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Package pw_serial opens serial ports for HDLC framed pw_rpc. Ports are put
+// in raw mode, so that frames pass unchanged, with the line settings of a
+// Config.
+package pw_serial
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+var (
+	ErrBadConfig       = errors.New("bad serial port configuration")
+	ErrUnsupportedBaud = errors.New("unsupported baud rate")
+	ErrUnsupported     = errors.New("serial ports are not supported on this platform")
+)
+
+// Parity is the parity bit of each character.
+type Parity int
+
+const (
+	ParityNone Parity = iota
+	ParityOdd
+	ParityEven
+)
+
+func (p Parity) String() string {
+	switch p {
+	case ParityNone:
+		return "none"
+	case ParityOdd:
+		return "odd"
+	case ParityEven:
+		return "even"
+	}
+
+	return fmt.Sprintf("Parity(%d)", int(p))
+}
+
+// Config is the line settings of a serial port. The zero Config keeps the
+// port's speed and uses 8N1 without flow control.
+type Config struct {
+	// BaudRate is the line speed; 0 keeps the current speed.
+	BaudRate int
+	// DataBits is 5 to 8; 0 means 8.
+	DataBits int
+	Parity   Parity
+	// StopBits is 1 or 2; 0 means 1.
+	StopBits int
+	// FlowControl enables RTS/CTS hardware flow control.
+	FlowControl bool
+}
+
+func (c Config) validate() error {
+	if c.BaudRate < 0 {
+		return fmt.Errorf("%w: baud rate %d", ErrBadConfig, c.BaudRate)
+	}
+
+	if c.DataBits != 0 && (c.DataBits < 5 || c.DataBits > 8) {
+		return fmt.Errorf("%w: %d data bits", ErrBadConfig, c.DataBits)
+	}
+
+	if c.Parity < ParityNone || c.Parity > ParityEven {
+		return fmt.Errorf("%w: %s", ErrBadConfig, c.Parity)
+	}
+
+	if c.StopBits != 0 && c.StopBits != 1 && c.StopBits != 2 {
+		return fmt.Errorf("%w: %d stop bits", ErrBadConfig, c.StopBits)
+	}
+
+	return nil
+}
+
+// ParseConfig reads a Config from the query of a serial endpoint, e.g.
+// serial:///dev/ttyUSB0?baud=115200&parity=even&stopbits=2&flow=rtscts.
+func ParseConfig(query url.Values) (Config, error) {
+	var c Config
+
+	ints := map[string]*int{
+		"baud":     &c.BaudRate,
+		"databits": &c.DataBits,
+		"stopbits": &c.StopBits,
+	}
+
+	for key, values := range query {
+		value := values[len(values)-1]
+
+		if n, ok := ints[key]; ok {
+			i, err := strconv.Atoi(value)
+			if err != nil {
+				return c, fmt.Errorf("%w: %s=%q", ErrBadConfig, key, value)
+			}
+			*n = i
+			continue
+		}
+
+		switch key {
+		case "parity":
+			switch value {
+			case "none":
+				c.Parity = ParityNone
+			case "odd":
+				c.Parity = ParityOdd
+			case "even":
+				c.Parity = ParityEven
+			default:
+				return c, fmt.Errorf("%w: parity=%q", ErrBadConfig, value)
+			}
+		case "flow":
+			switch value {
+			case "none":
+				c.FlowControl = false
+			case "rtscts":
+				c.FlowControl = true
+			default:
+				return c, fmt.Errorf("%w: flow=%q", ErrBadConfig, value)
+			}
+		default:
+			return c, fmt.Errorf("%w: unknown option %q", ErrBadConfig, key)
+		}
+	}
+
+	return c, c.validate()
+}
@@ -0,0 +1,141 @@
+package pw_serial
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+var kBaudRates = map[int]uint32{
+	50:      unix.B50,
+	75:      unix.B75,
+	110:     unix.B110,
+	134:     unix.B134,
+	150:     unix.B150,
+	200:     unix.B200,
+	300:     unix.B300,
+	600:     unix.B600,
+	1200:    unix.B1200,
+	1800:    unix.B1800,
+	2400:    unix.B2400,
+	4800:    unix.B4800,
+	9600:    unix.B9600,
+	19200:   unix.B19200,
+	38400:   unix.B38400,
+	57600:   unix.B57600,
+	115200:  unix.B115200,
+	230400:  unix.B230400,
+	460800:  unix.B460800,
+	500000:  unix.B500000,
+	576000:  unix.B576000,
+	921600:  unix.B921600,
+	1000000: unix.B1000000,
+	1152000: unix.B1152000,
+	1500000: unix.B1500000,
+	2000000: unix.B2000000,
+	2500000: unix.B2500000,
+	3000000: unix.B3000000,
+	3500000: unix.B3500000,
+	4000000: unix.B4000000,
+}
+
+var kDataBits = map[int]uint32{
+	5: unix.CS5,
+	6: unix.CS6,
+	7: unix.CS7,
+	8: unix.CS8,
+}
+
+// Open opens the serial port at path and configures it. The port is opened
+// without waiting for a carrier and does not become the controlling
+// terminal.
+func Open(path string, config Config) (io.ReadWriteCloser, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	// Non-blocking descriptors use the runtime poller, so that Close ends a
+	// pending Read.
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := configure(f, config); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return f, nil
+}
+
+func configure(f *os.File, config Config) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	cerr := rc.Control(func(fd uintptr) {
+		var t *unix.Termios
+		if t, err = unix.IoctlGetTermios(int(fd), unix.TCGETS); err != nil {
+			return
+		}
+		if err = apply(t, config); err != nil {
+			return
+		}
+		err = unix.IoctlSetTermios(int(fd), unix.TCSETS, t)
+	})
+	if cerr != nil {
+		return cerr
+	}
+
+	return err
+}
+
+// apply sets raw mode, as cfmakeraw(3), and the line settings of config.
+func apply(t *unix.Termios, config Config) error {
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON | unix.IXOFF | unix.IXANY | unix.INPCK
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB | unix.PARODD | unix.CSTOPB | unix.CRTSCTS
+	t.Cflag |= unix.CLOCAL | unix.CREAD
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+
+	if config.BaudRate != 0 {
+		speed, ok := kBaudRates[config.BaudRate]
+		if !ok {
+			return fmt.Errorf("%w: %d", ErrUnsupportedBaud, config.BaudRate)
+		}
+		t.Cflag &^= unix.CBAUD
+		t.Cflag |= speed
+	}
+
+	dataBits := config.DataBits
+	if dataBits == 0 {
+		dataBits = 8
+	}
+	t.Cflag |= kDataBits[dataBits]
+
+	switch config.Parity {
+	case ParityOdd:
+		t.Cflag |= unix.PARENB | unix.PARODD
+		t.Iflag |= unix.INPCK
+	case ParityEven:
+		t.Cflag |= unix.PARENB
+		t.Iflag |= unix.INPCK
+	}
+
+	if config.StopBits == 2 {
+		t.Cflag |= unix.CSTOPB
+	}
+
+	if config.FlowControl {
+		t.Cflag |= unix.CRTSCTS
+	}
+
+	return nil
+}
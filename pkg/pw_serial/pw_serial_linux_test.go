@@ -0,0 +1,173 @@
+package pw_serial_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpctest"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_serial"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pwsim"
+	"golang.org/x/sys/unix"
+)
+
+// openPty returns the master of a new pseudo terminal and the path of its
+// terminal, which stands in for a serial port.
+func openPty(t *testing.T) (*os.File, string) {
+	t.Helper()
+
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { master.Close() })
+
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := unix.IoctlGetUint32(fd, unix.TIOCGPTN)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func termios(t *testing.T, path string) *unix.Termios {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	termios, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return termios
+}
+
+func TestOpen(t *testing.T) {
+	master, path := openPty(t)
+
+	// Pseudo terminals are always 8 bit without parity; see TestApply.
+	port, err := pw_serial.Open(path, pw_serial.Config{
+		BaudRate:    9600,
+		StopBits:    2,
+		FlowControl: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer port.Close()
+
+	got := termios(t, path)
+
+	cflag := []struct {
+		name string
+		mask uint32
+		want uint32
+	}{
+		{"speed", unix.CBAUD, unix.B9600},
+		{"stop bits", unix.CSTOPB, unix.CSTOPB},
+		{"flow control", unix.CRTSCTS, unix.CRTSCTS},
+		{"local", unix.CLOCAL | unix.CREAD, unix.CLOCAL | unix.CREAD},
+	}
+	for _, c := range cflag {
+		if got.Cflag&c.mask != c.want {
+			t.Errorf("%s: Cflag&%#x = %#x, want %#x", c.name, c.mask, got.Cflag&c.mask, c.want)
+		}
+	}
+
+	if got.Lflag&(unix.ICANON|unix.ECHO|unix.ISIG) != 0 || got.Oflag&unix.OPOST != 0 || got.Iflag&(unix.ICRNL|unix.IXON) != 0 {
+		t.Errorf("port is not raw: %+v", got)
+	}
+
+	// Bytes that a cooked terminal would translate or act on.
+	frame := []byte{0x7e, 0x0a, 0x0d, 0x03, 0x11, 0x13, 0x7f, 0x7e}
+
+	if _, err := port.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, len(frame))
+	if _, err := io.ReadFull(master, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, frame) {
+		t.Errorf("read % x, want % x", buf, frame)
+	}
+}
+
+func TestOpenUnsupportedBaud(t *testing.T) {
+	_, path := openPty(t)
+
+	if _, err := pw_serial.Open(path, pw_serial.Config{BaudRate: 12345}); !errors.Is(err, pw_serial.ErrUnsupportedBaud) {
+		t.Errorf("Open = %v, want %v", err, pw_serial.ErrUnsupportedBaud)
+	}
+}
+
+func TestCloseEndsRead(t *testing.T) {
+	_, path := openPty(t)
+
+	port, err := pw_serial.Open(path, pw_serial.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := port.Read(make([]byte, 1))
+		done <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	port.Close()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrClosed) {
+			t.Errorf("Read = %v, want %v", err, os.ErrClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not end Read")
+	}
+}
+
+func TestSerialEndpoint(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server, err := pw_rpctest.NewEchoServer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sim, err := pwsim.NewPty(server, pwsim.Impairments{})
+	if errors.Is(err, pwsim.ErrUnsupported) {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	go sim.Serve(ctx)
+	defer sim.Close()
+
+	c := pw_rpc.NewClient("serial://" + sim.Path() + "?baud=115200&parity=none&flow=none")
+	defer c.Close()
+
+	if err := pw_rpctest.Echo(ctx, c, bytes.Repeat([]byte{0x7e, 0x7d, 0x0a, 0x0d}, 64)); err != nil {
+		t.Fatal(err)
+	}
+}
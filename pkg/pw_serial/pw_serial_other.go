@@ -0,0 +1,10 @@
+//go:build !linux
+
+package pw_serial
+
+import "io"
+
+// Open opens and configures a serial port. It is only supported on Linux.
+func Open(path string, config Config) (io.ReadWriteCloser, error) {
+	return nil, ErrUnsupported
+}
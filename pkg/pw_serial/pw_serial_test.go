@@ -0,0 +1,59 @@
+package pw_serial_test
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_serial"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		query string
+		want  pw_serial.Config
+	}{
+		{"", pw_serial.Config{}},
+		{"baud=115200", pw_serial.Config{BaudRate: 115200}},
+		{"baud=9600&databits=7&parity=even&stopbits=2", pw_serial.Config{BaudRate: 9600, DataBits: 7, Parity: pw_serial.ParityEven, StopBits: 2}},
+		{"parity=odd&flow=rtscts", pw_serial.Config{Parity: pw_serial.ParityOdd, FlowControl: true}},
+		{"flow=rtscts&flow=none", pw_serial.Config{}},
+	}
+
+	for _, test := range tests {
+		query, err := url.ParseQuery(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := pw_serial.ParseConfig(query)
+		if err != nil {
+			t.Errorf("ParseConfig(%q): %s", test.query, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseConfig(%q) = %+v, want %+v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	for _, bad := range []string{
+		"baud=fast",
+		"baud=-1",
+		"databits=9",
+		"stopbits=3",
+		"parity=mark",
+		"flow=xonxoff",
+		"speed=115200",
+	} {
+		query, err := url.ParseQuery(bad)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := pw_serial.ParseConfig(query); !errors.Is(err, pw_serial.ErrBadConfig) {
+			t.Errorf("ParseConfig(%q) = %v, want %v", bad, err, pw_serial.ErrBadConfig)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package pw_tokenizer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrBadDatabase  = errors.New("bad token database")
+	ErrUnknownToken = errors.New("unknown token")
+)
+
+// Database maps tokens to the format strings they were generated from.
+type Database interface {
+	// Lookup returns the format strings for a token. More than one string is
+	// returned when tokens collide.
+	Lookup(token uint32) []string
+	Len() int
+}
+
+type database struct {
+	entries map[uint32][]string
+	count   int
+}
+
+// Load reads a token database file. See ReadCSV for the format.
+func Load(path string) (Database, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	db, err := ReadCSV(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return db, nil
+}
+
+// ReadCSV reads a CSV token database, as written by Pigweed's database.py.
+// Each row is `token,removal date,string` or, in newer databases,
+// `token,removal date,domain,string`, with the token in hex.
+func ReadCSV(r io.Reader) (Database, error) {
+	db := &database{
+		entries: make(map[uint32][]string),
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrBadDatabase, err)
+		}
+
+		if len(record) < 3 || len(record) > 4 {
+			line, _ := reader.FieldPos(0)
+			return nil, fmt.Errorf("%w: line %d has %d fields", ErrBadDatabase, line, len(record))
+		}
+
+		token, err := strconv.ParseUint(strings.TrimSpace(record[0]), 16, 32)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			return nil, fmt.Errorf("%w: line %d: bad token %q", ErrBadDatabase, line, record[0])
+		}
+
+		db.add(uint32(token), record[len(record)-1])
+	}
+
+	return db, nil
+}
+
+func (db *database) add(token uint32, format string) {
+	for _, existing := range db.entries[token] {
+		if existing == format {
+			return
+		}
+	}
+
+	db.entries[token] = append(db.entries[token], format)
+	db.count++
+}
+
+func (db *database) Lookup(token uint32) []string {
+	return db.entries[token]
+}
+
+func (db *database) Len() int {
+	return db.count
+}
@@ -0,0 +1,241 @@
+package pw_tokenizer
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_varint"
+)
+
+const (
+	kTokenSize = 4
+	// kBase64Prefix starts a Base64 encoded tokenized message.
+	kBase64Prefix = '$'
+)
+
+var (
+	ErrTruncated = errors.New("message truncated")
+	ErrBadArgs   = errors.New("arguments do not match the format string")
+)
+
+// Detokenizer turns tokenized messages back into text.
+type Detokenizer interface {
+	// Detokenize decodes a binary tokenized message: a little-endian token
+	// followed by the encoded arguments.
+	Detokenize(message []byte) (string, error)
+	// DetokenizeText decodes a message that is either binary or prefixed
+	// Base64 ("$" followed by the Base64 of the binary message).
+	DetokenizeText(message []byte) (string, error)
+}
+
+type detokenizer struct {
+	db Database
+}
+
+func NewDetokenizer(db Database) Detokenizer {
+	return &detokenizer{
+		db: db,
+	}
+}
+
+func (d *detokenizer) DetokenizeText(message []byte) (string, error) {
+	if len(message) > 0 && message[0] == kBase64Prefix {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(message[1:])))
+		if err == nil {
+			message = decoded
+		}
+	}
+
+	return d.Detokenize(message)
+}
+
+func (d *detokenizer) Detokenize(message []byte) (string, error) {
+	if len(message) < kTokenSize {
+		return "", ErrTruncated
+	}
+
+	token := binary.LittleEndian.Uint32(message)
+	formats := d.db.Lookup(token)
+	if len(formats) == 0 {
+		return "", fmt.Errorf("%w: %08x", ErrUnknownToken, token)
+	}
+
+	// On collisions, prefer the format string that uses the arguments exactly.
+	var firstErr error
+	for _, format := range formats {
+		text, err := Format(format, message[kTokenSize:])
+		if err == nil {
+			return text, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return "", fmt.Errorf("token %08x: %w", token, firstErr)
+}
+
+// Format decodes the encoded arguments of a tokenized message and formats
+// them with the printf-style format string.
+func Format(format string, args []byte) (string, error) {
+	var out strings.Builder
+
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			out.WriteByte(format[i])
+			continue
+		}
+
+		spec, n := parseSpec(format[i:])
+		i += n - 1
+
+		if spec.verb == '%' {
+			out.WriteByte('%')
+			continue
+		}
+		if spec.verb == 0 {
+			return "", fmt.Errorf("%w: bad conversion %q", ErrBadArgs, format[i-n+1:i+1])
+		}
+
+		text, used, err := spec.format(args)
+		if err != nil {
+			return "", err
+		}
+
+		out.WriteString(text)
+		args = args[used:]
+	}
+
+	if len(args) != 0 {
+		return "", fmt.Errorf("%w: %d unused bytes", ErrBadArgs, len(args))
+	}
+
+	return out.String(), nil
+}
+
+// spec is a printf conversion specification.
+type spec struct {
+	flags     string
+	width     string
+	precision string
+	verb      byte
+}
+
+// parseSpec parses the conversion at the start of s and returns its length.
+// The length modifiers are dropped, since every integer is encoded the same.
+func parseSpec(s string) (spec, int) {
+	var sp spec
+	i := 1
+
+	start := i
+	for i < len(s) && strings.IndexByte("-+ #0", s[i]) >= 0 {
+		i++
+	}
+	sp.flags = s[start:i]
+
+	start = i
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '*') {
+		i++
+	}
+	sp.width = s[start:i]
+
+	if i < len(s) && s[i] == '.' {
+		start = i
+		i++
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		sp.precision = s[start:i]
+	}
+
+	for i < len(s) && strings.IndexByte("hljztL", s[i]) >= 0 {
+		i++
+	}
+
+	if i < len(s) && strings.IndexByte("%diuoxXcsfFeEgGaAp", s[i]) >= 0 {
+		sp.verb = s[i]
+		i++
+	}
+
+	return sp, i
+}
+
+func (sp spec) goFormat(verb byte) string {
+	return "%" + sp.flags + sp.width + sp.precision + string(verb)
+}
+
+// format decodes one argument and returns it formatted along with the number
+// of argument bytes it used.
+func (sp spec) format(args []byte) (string, int, error) {
+	if strings.Contains(sp.width, "*") {
+		return "", 0, fmt.Errorf("%w: '*' width is not supported", ErrBadArgs)
+	}
+
+	switch sp.verb {
+	case 'd', 'i', 'u', 'o', 'x', 'X', 'c', 'p':
+		value, n := pw_varint.Decode(args, pw_varint.ZeroTerminatedMostSignificant)
+		if n == 0 {
+			return "", 0, ErrTruncated
+		}
+
+		signed := zigzagDecode(value)
+
+		switch sp.verb {
+		case 'd', 'i':
+			return fmt.Sprintf(sp.goFormat('d'), signed), n, nil
+		case 'u':
+			return fmt.Sprintf(sp.goFormat('d'), uint32(signed)), n, nil
+		case 'c':
+			return fmt.Sprintf(sp.goFormat('c'), rune(signed)), n, nil
+		case 'p':
+			return fmt.Sprintf(sp.goFormat('s'), fmt.Sprintf("0x%08X", uint32(signed))), n, nil
+		default:
+			return fmt.Sprintf(sp.goFormat(sp.verb), uint32(signed)), n, nil
+		}
+	case 'f', 'F', 'e', 'E', 'g', 'G', 'a', 'A':
+		if len(args) < 4 {
+			return "", 0, ErrTruncated
+		}
+
+		value := math.Float32frombits(binary.LittleEndian.Uint32(args))
+		verb := sp.verb
+		switch verb {
+		case 'F':
+			verb = 'f'
+		case 'a':
+			verb = 'x'
+		case 'A':
+			verb = 'X'
+		}
+
+		return fmt.Sprintf(sp.goFormat(verb), value), 4, nil
+	case 's':
+		// A string is a length byte, whose high bit marks truncation,
+		// followed by the string.
+		if len(args) < 1 {
+			return "", 0, ErrTruncated
+		}
+
+		size := int(args[0] & 0x7f)
+		if len(args) < 1+size {
+			return "", 0, ErrTruncated
+		}
+
+		text := string(args[1 : 1+size])
+		if args[0]&0x80 != 0 {
+			text += "[...]"
+		}
+
+		return fmt.Sprintf(sp.goFormat('s'), text), 1 + size, nil
+	}
+
+	return "", 0, fmt.Errorf("%w: %%%c", ErrBadArgs, sp.verb)
+}
+
+func zigzagDecode(value uint64) int64 {
+	return int64(value>>1) ^ -int64(value&1)
+}
@@ -0,0 +1,49 @@
+package pw_tokenizer
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+const kTestDatabase = `12345678,          ,"Battery %d%% %s at %.1fV"
+0000abcd,2024-01-02,,"removed %u"
+`
+
+func TestDetokenize(t *testing.T) {
+	db, err := ReadCSV(strings.NewReader(kTestDatabase))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", db.Len())
+	}
+
+	d := NewDetokenizer(db)
+
+	message := []byte{0x78, 0x56, 0x34, 0x12, 0x09, 0x02, 'h', 'i', 0x00, 0x00, 0x60, 0x40}
+	want := "Battery -5% hi at 3.5V"
+
+	got, err := d.Detokenize(message)
+	if err != nil || got != want {
+		t.Errorf("Detokenize() = %q, %v; want %q", got, err, want)
+	}
+
+	got, err = d.DetokenizeText([]byte("$" + base64.StdEncoding.EncodeToString(message)))
+	if err != nil || got != want {
+		t.Errorf("DetokenizeText() = %q, %v; want %q", got, err, want)
+	}
+
+	got, err = d.Detokenize([]byte{0xcd, 0xab, 0x00, 0x00, 0x80, 0x01})
+	if err != nil || got != "removed 64" {
+		t.Errorf("Detokenize() = %q, %v; want %q", got, err, "removed 64")
+	}
+
+	if _, err := d.Detokenize([]byte{0x78, 0x56, 0x34, 0x12, 0x09}); err == nil {
+		t.Errorf("Detokenize() of truncated arguments succeeded")
+	}
+
+	if _, err := d.Detokenize([]byte{1, 2, 3, 4}); err == nil {
+		t.Errorf("Detokenize() of unknown token succeeded")
+	}
+}
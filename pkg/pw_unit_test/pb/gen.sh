@@ -0,0 +1,8 @@
+protoc \
+    --go_out=. \
+    --go_opt=Munit_test.proto=../pb \
+    --go-grpc_out=. \
+    --go-grpc_opt=Munit_test.proto=../pb \
+    --go-pwrpc_out=. \
+    --go-pwrpc_opt=Munit_test.proto=../pb \
+    ./unit_test.proto
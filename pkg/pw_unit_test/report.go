@@ -0,0 +1,229 @@
+package pw_unit_test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// suites groups test cases by suite, in the order the suites first ran.
+func (r *Results) suites() (names []string, cases map[string][]*TestCase) {
+	cases = map[string][]*TestCase{}
+	for _, tc := range r.Cases {
+		if _, ok := cases[tc.Suite]; !ok {
+			names = append(names, tc.Suite)
+		}
+		cases[tc.Suite] = append(cases[tc.Suite], tc)
+	}
+
+	return names, cases
+}
+
+// failures describes why a test case failed, one line per failed
+// expectation.
+func (tc *TestCase) failures() []string {
+	var lines []string
+	for _, e := range tc.Expectations {
+		if !e.Success {
+			lines = append(lines, fmt.Sprintf("%s:%d: %s", tc.File, e.Line, e))
+		}
+	}
+
+	if tc.Outcome == Incomplete {
+		lines = append(lines, "test case did not finish")
+	}
+
+	return lines
+}
+
+// WriteSummary writes a line for each test case, the failed expectations and
+// the totals.
+func WriteSummary(w io.Writer, r *Results) error {
+	for _, tc := range r.Cases {
+		fmt.Fprintf(w, "%-10s %s (%s)\n", tc.Outcome, tc.FullName(), tc.Duration.Round(time.Microsecond))
+		for _, line := range tc.failures() {
+			fmt.Fprintf(w, "    %s\n", line)
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "%d passed, %d failed, %d skipped, %d disabled", r.Count(Passed), r.Count(Failed)+r.Count(Incomplete), r.Count(Skipped), r.Count(Disabled))
+	if err != nil {
+		return err
+	}
+
+	if !r.Complete {
+		_, err = fmt.Fprintf(w, "; the run did not finish\n")
+	} else {
+		_, err = fmt.Fprintf(w, " in %s\n", r.Duration.Round(time.Millisecond))
+	}
+
+	return err
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	File      string        `xml:"file,attr,omitempty"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// WriteJUnit writes the results as JUnit XML, with a testsuite for each
+// suite.
+func WriteJUnit(w io.Writer, r *Results) error {
+	report := junitTestSuites{Time: seconds(r.Duration)}
+
+	names, cases := r.suites()
+	for _, name := range names {
+		suite := junitTestSuite{Name: name}
+		var elapsed time.Duration
+
+		for _, tc := range cases[name] {
+			jtc := junitTestCase{
+				Name:      tc.Name,
+				ClassName: tc.Suite,
+				File:      tc.File,
+				Time:      seconds(tc.Duration),
+			}
+
+			switch tc.Outcome {
+			case Failed, Incomplete:
+				failures := tc.failures()
+				jtc.Failure = &junitMessage{
+					Message: fmt.Sprintf("%d failed expectations", len(failures)),
+					Text:    strings.Join(failures, "\n"),
+				}
+				if tc.Outcome == Incomplete {
+					jtc.Failure.Message = "test case did not finish"
+				}
+				suite.Failures++
+			case Skipped, Disabled:
+				jtc.Skipped = &junitMessage{Message: strings.ToLower(tc.Outcome.String())}
+				suite.Skipped++
+			}
+
+			suite.Tests++
+			elapsed += tc.Duration
+			suite.Cases = append(suite.Cases, jtc)
+		}
+
+		suite.Time = seconds(elapsed)
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Skipped += suite.Skipped
+		report.Suites = append(report.Suites, suite)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// testEvent is an event of `go test -json`, as documented by test2json.
+type testEvent struct {
+	Time    time.Time `json:"Time"`
+	Action  string    `json:"Action"`
+	Package string    `json:"Package"`
+	Test    string    `json:"Test,omitempty"`
+	Elapsed *float64  `json:"Elapsed,omitempty"`
+	Output  string    `json:"Output,omitempty"`
+}
+
+func elapsed(d time.Duration) *float64 {
+	s := d.Seconds()
+	return &s
+}
+
+// WriteTestJSON writes the results as `go test -json` events, with each suite
+// as a package, so that tools for Go test output can read device test runs.
+func WriteTestJSON(w io.Writer, r *Results) error {
+	enc := json.NewEncoder(w)
+
+	names, cases := r.suites()
+	for _, name := range names {
+		events := []testEvent{{Time: cases[name][0].Start, Action: "start", Package: name}}
+		var suiteElapsed time.Duration
+		suitePassed := true
+
+		for _, tc := range cases[name] {
+			end := tc.Start.Add(tc.Duration)
+			events = append(events,
+				testEvent{Time: tc.Start, Action: "run", Package: name, Test: tc.Name},
+				testEvent{Time: tc.Start, Action: "output", Package: name, Test: tc.Name, Output: fmt.Sprintf("=== RUN   %s\n", tc.Name)})
+
+			for _, line := range tc.failures() {
+				events = append(events, testEvent{Time: end, Action: "output", Package: name, Test: tc.Name, Output: fmt.Sprintf("    %s\n", line)})
+			}
+
+			action := "pass"
+			switch tc.Outcome {
+			case Failed, Incomplete:
+				action = "fail"
+				suitePassed = false
+			case Skipped, Disabled:
+				action = "skip"
+			}
+
+			events = append(events,
+				testEvent{Time: end, Action: "output", Package: name, Test: tc.Name, Output: fmt.Sprintf("--- %s: %s (%.2fs)\n", strings.ToUpper(action), tc.Name, tc.Duration.Seconds())},
+				testEvent{Time: end, Action: action, Package: name, Test: tc.Name, Elapsed: elapsed(tc.Duration)})
+
+			suiteElapsed += tc.Duration
+		}
+
+		action := "pass"
+		if !suitePassed || !r.Complete {
+			action = "fail"
+		}
+		last := cases[name][len(cases[name])-1]
+		events = append(events, testEvent{Time: last.Start.Add(last.Duration), Action: action, Package: name, Elapsed: elapsed(suiteElapsed)})
+
+		for _, event := range events {
+			if err := enc.Encode(&event); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
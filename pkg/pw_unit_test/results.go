@@ -0,0 +1,237 @@
+// Package pw_unit_test runs tests on a device through the
+// pw.unit_test.UnitTest service. A Recorder gathers the events of a run into
+// Results, which are reported as text, JUnit XML or `go test -json` events.
+// A Server is the device side: it runs Go test functions for Run requests.
+package pw_unit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_unit_test/pb"
+)
+
+var (
+	ErrUnexpectedEvent = errors.New("unexpected test event")
+	ErrIncomplete      = errors.New("test run did not finish")
+)
+
+// Outcome is the result of a test case.
+type Outcome int
+
+const (
+	Passed Outcome = iota
+	Failed
+	Skipped
+	Disabled
+	// Incomplete is a test case that started but never ended, because the
+	// run was cut short.
+	Incomplete
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Passed:
+		return "PASS"
+	case Failed:
+		return "FAIL"
+	case Skipped:
+		return "SKIP"
+	case Disabled:
+		return "DISABLED"
+	case Incomplete:
+		return "INCOMPLETE"
+	}
+
+	return fmt.Sprintf("Outcome(%d)", int(o))
+}
+
+// Expectation is a check made by a test case.
+type Expectation struct {
+	Expression string
+	Evaluated  string
+	Line       uint32
+	Success    bool
+}
+
+func (e *Expectation) String() string {
+	if e.Evaluated != "" && e.Evaluated != e.Expression {
+		return fmt.Sprintf("%s (%s)", e.Expression, e.Evaluated)
+	}
+
+	return e.Expression
+}
+
+// TestCase is the result of a test case.
+type TestCase struct {
+	Suite        string
+	Name         string
+	File         string
+	Outcome      Outcome
+	Expectations []*Expectation
+	Start        time.Time
+	Duration     time.Duration
+}
+
+// FullName is the suite and name of the test case, as Suite.Name.
+func (tc *TestCase) FullName() string {
+	return tc.Suite + "." + tc.Name
+}
+
+// Results is the result of a test run.
+type Results struct {
+	Cases    []*TestCase
+	Start    time.Time
+	Duration time.Duration
+	// Complete is set when the device ended the run. Its counts are in
+	// Summary.
+	Complete bool
+	Summary  *pb.TestRunEnd
+}
+
+// Count returns the number of test cases with an outcome.
+func (r *Results) Count(outcome Outcome) int {
+	count := 0
+	for _, tc := range r.Cases {
+		if tc.Outcome == outcome {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Passed reports whether the run finished without failures.
+func (r *Results) Passed() bool {
+	return r.Complete && r.Count(Failed) == 0 && r.Count(Incomplete) == 0 && r.Summary.GetFailed() == 0
+}
+
+// Recorder gathers the events of a test run.
+type Recorder interface {
+	// Record adds an event received at a time.
+	Record(at time.Time, event *pb.Event) error
+	// Results returns the results so far. A test case still running is
+	// Incomplete.
+	Results() *Results
+}
+
+type recorder struct {
+	results *Results
+	current *TestCase
+}
+
+func NewRecorder() Recorder {
+	return &recorder{
+		results: &Results{},
+	}
+}
+
+func descriptorCase(d *pb.TestCaseDescriptor) *TestCase {
+	return &TestCase{
+		Suite: d.GetSuiteName(),
+		Name:  d.GetTestName(),
+		File:  d.GetFileName(),
+	}
+}
+
+func (r *recorder) Record(at time.Time, event *pb.Event) error {
+	switch e := event.GetType().(type) {
+	case *pb.Event_TestRunStart:
+		r.results.Start = at
+	case *pb.Event_TestRunEnd:
+		if r.current != nil {
+			return fmt.Errorf("%w: run ended during %s", ErrUnexpectedEvent, r.current.FullName())
+		}
+		r.results.Complete = true
+		r.results.Summary = e.TestRunEnd
+		r.results.Duration = at.Sub(r.results.Start)
+	case *pb.Event_TestCaseStart:
+		if r.current != nil {
+			return fmt.Errorf("%w: %s started during %s", ErrUnexpectedEvent, descriptorCase(e.TestCaseStart).FullName(), r.current.FullName())
+		}
+		r.current = descriptorCase(e.TestCaseStart)
+		r.current.Start = at
+		r.current.Outcome = Incomplete
+		r.results.Cases = append(r.results.Cases, r.current)
+	case *pb.Event_TestCaseEnd:
+		if r.current == nil {
+			return fmt.Errorf("%w: test case ended before it started", ErrUnexpectedEvent)
+		}
+		switch e.TestCaseEnd {
+		case pb.TestCaseResult_SUCCESS:
+			r.current.Outcome = Passed
+		case pb.TestCaseResult_SKIPPED:
+			r.current.Outcome = Skipped
+		default:
+			r.current.Outcome = Failed
+		}
+		r.current.Duration = at.Sub(r.current.Start)
+		r.current = nil
+	case *pb.Event_TestCaseDisabled:
+		tc := descriptorCase(e.TestCaseDisabled)
+		tc.Start = at
+		tc.Outcome = Disabled
+		r.results.Cases = append(r.results.Cases, tc)
+	case *pb.Event_TestCaseExpectation:
+		if r.current == nil {
+			return fmt.Errorf("%w: expectation outside of a test case", ErrUnexpectedEvent)
+		}
+		r.current.Expectations = append(r.current.Expectations, &Expectation{
+			Expression: e.TestCaseExpectation.GetExpression(),
+			Evaluated:  e.TestCaseExpectation.GetEvaluatedExpression(),
+			Line:       e.TestCaseExpectation.GetLineNumber(),
+			Success:    e.TestCaseExpectation.GetSuccess(),
+		})
+	default:
+		return fmt.Errorf("%w: %T", ErrUnexpectedEvent, e)
+	}
+
+	return nil
+}
+
+func (r *recorder) Results() *Results {
+	return r.results
+}
+
+// Run runs the tests selected by request and records their events until the
+// device ends the run or ctx is done. The results are returned even when the
+// run fails part way, with ErrIncomplete if the run did not end.
+func Run(ctx context.Context, client pb.UnitTestClient, request *pb.TestRunRequest) (*Results, error) {
+	rec := NewRecorder()
+	results := rec.Results()
+	results.Start = time.Now()
+
+	err := record(ctx, client, request, rec)
+	if !results.Complete {
+		results.Duration = time.Since(results.Start)
+	}
+
+	if err == nil && !results.Complete {
+		err = ErrIncomplete
+	}
+
+	return results, err
+}
+
+func record(ctx context.Context, client pb.UnitTestClient, request *pb.TestRunRequest, rec Recorder) error {
+	stream, err := client.Run(ctx, request)
+	if err != nil {
+		return err
+	}
+
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := rec.Record(time.Now(), event); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package pw_unit_test_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpctest"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_unit_test"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_unit_test/pb"
+	"google.golang.org/grpc"
+)
+
+// scriptedDevice sends a fixed sequence of events, then blocks until the call
+// ends if hang is set.
+type scriptedDevice struct {
+	pb.UnsafeUnitTestServer
+	events []*pb.Event
+	hang   bool
+}
+
+func (d *scriptedDevice) Run(request *pb.TestRunRequest, stream grpc.ServerStreamingServer[pb.Event]) error {
+	for _, event := range d.events {
+		if err := stream.Send(event); err != nil {
+			return err
+		}
+	}
+
+	if d.hang {
+		<-stream.Context().Done()
+		return stream.Context().Err()
+	}
+
+	return nil
+}
+
+func caseStart(suite string, name string) *pb.Event {
+	return &pb.Event{Type: &pb.Event_TestCaseStart{TestCaseStart: &pb.TestCaseDescriptor{SuiteName: suite, TestName: name, FileName: "test.cc"}}}
+}
+
+func caseEnd(result pb.TestCaseResult) *pb.Event {
+	return &pb.Event{Type: &pb.Event_TestCaseEnd{TestCaseEnd: result}}
+}
+
+func expectation(expression string, evaluated string, line uint32, success bool) *pb.Event {
+	return &pb.Event{Type: &pb.Event_TestCaseExpectation{TestCaseExpectation: &pb.TestCaseExpectation{
+		Expression:          expression,
+		EvaluatedExpression: evaluated,
+		LineNumber:          line,
+		Success:             success,
+	}}}
+}
+
+var kRunEvents = []*pb.Event{
+	{Type: &pb.Event_TestRunStart{TestRunStart: &pb.TestRunStart{}}},
+	caseStart("Math", "Adds"),
+	expectation("1 + 1 == 2", "2 == 2", 5, true),
+	caseEnd(pb.TestCaseResult_SUCCESS),
+	caseStart("Math", "Divides"),
+	expectation("x / 2 == 1", "3 == 1", 10, false),
+	caseEnd(pb.TestCaseResult_FAILURE),
+	{Type: &pb.Event_TestCaseDisabled{TestCaseDisabled: &pb.TestCaseDescriptor{SuiteName: "Io", TestName: "Flaky", FileName: "io.cc"}}},
+	caseStart("Io", "Reads"),
+	caseEnd(pb.TestCaseResult_SKIPPED),
+	{Type: &pb.Event_TestRunEnd{TestRunEnd: &pb.TestRunEnd{Passed: 1, Failed: 1, Skipped: 1, Disabled: 1}}},
+}
+
+func run(t *testing.T, ctx context.Context, device *scriptedDevice) (*pw_unit_test.Results, error) {
+	t.Helper()
+
+	s := pw_rpc.NewServer("")
+	pb.RegisterUnitTestServer(s, device)
+
+	c, err := pw_rpctest.NewServerClient(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+
+	return pw_unit_test.Run(ctx, pb.NewUnitTestClient(c), &pb.TestRunRequest{})
+}
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	results, err := run(t, ctx, &scriptedDevice{events: kRunEvents})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		name    string
+		outcome pw_unit_test.Outcome
+	}{
+		{"Math.Adds", pw_unit_test.Passed},
+		{"Math.Divides", pw_unit_test.Failed},
+		{"Io.Flaky", pw_unit_test.Disabled},
+		{"Io.Reads", pw_unit_test.Skipped},
+	}
+	if len(results.Cases) != len(want) {
+		t.Fatalf("got %d test cases, want %d", len(results.Cases), len(want))
+	}
+	for i, tc := range results.Cases {
+		if tc.FullName() != want[i].name || tc.Outcome != want[i].outcome {
+			t.Errorf("case %d = %s %s, want %s %s", i, tc.FullName(), tc.Outcome, want[i].name, want[i].outcome)
+		}
+	}
+
+	if !results.Complete || results.Passed() {
+		t.Errorf("Complete = %t, Passed = %t", results.Complete, results.Passed())
+	}
+
+	var summary bytes.Buffer
+	if err := pw_unit_test.WriteSummary(&summary, results); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"FAIL       Math.Divides", "    test.cc:10: x / 2 == 1 (3 == 1)", "1 passed, 1 failed, 1 skipped, 1 disabled"} {
+		if !strings.Contains(summary.String(), line) {
+			t.Errorf("summary missing %q:\n%s", line, summary.String())
+		}
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	results, err := run(t, ctx, &scriptedDevice{events: kRunEvents})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := pw_unit_test.WriteJUnit(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+
+	var report struct {
+		Tests    int `xml:"tests,attr"`
+		Failures int `xml:"failures,attr"`
+		Skipped  int `xml:"skipped,attr"`
+		Suites   []struct {
+			Name  string `xml:"name,attr"`
+			Cases []struct {
+				Name    string  `xml:"name,attr"`
+				Failure *string `xml:"failure"`
+			} `xml:"testcase"`
+		} `xml:"testsuite"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("%s\n%s", err, buf.String())
+	}
+
+	if report.Tests != 4 || report.Failures != 1 || report.Skipped != 2 || len(report.Suites) != 2 {
+		t.Fatalf("report = %+v", report)
+	}
+
+	failure := report.Suites[0].Cases[1].Failure
+	if report.Suites[0].Name != "Math" || failure == nil || *failure != "test.cc:10: x / 2 == 1 (3 == 1)" {
+		t.Errorf("Math suite = %+v", report.Suites[0])
+	}
+}
+
+func TestWriteTestJSON(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	results, err := run(t, ctx, &scriptedDevice{events: kRunEvents})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := pw_unit_test.WriteTestJSON(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+
+	// The final action of each test and package.
+	actions := map[string]string{}
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var event struct {
+			Action  string
+			Package string
+			Test    string
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Action != "output" {
+			actions[event.Package+"/"+event.Test] = event.Action
+		}
+	}
+
+	want := map[string]string{
+		"Math/Adds":    "pass",
+		"Math/Divides": "fail",
+		"Math/":        "fail",
+		"Io/Flaky":     "skip",
+		"Io/Reads":     "skip",
+		"Io/":          "pass",
+	}
+	for test, action := range want {
+		if actions[test] != action {
+			t.Errorf("%s: action %q, want %q", test, actions[test], action)
+		}
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	results, err := run(t, ctx, &scriptedDevice{events: kRunEvents[:3], hang: true})
+	if err == nil {
+		t.Fatal("run did not time out")
+	}
+
+	if results.Complete || results.Passed() || len(results.Cases) != 1 || results.Cases[0].Outcome != pw_unit_test.Incomplete {
+		t.Errorf("results = %+v", results)
+	}
+}
+
+func TestRecorderRejectsNesting(t *testing.T) {
+	rec := pw_unit_test.NewRecorder()
+
+	if err := rec.Record(time.Now(), caseStart("A", "One")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rec.Record(time.Now(), caseStart("A", "Two")); err == nil {
+		t.Error("nested test case accepted")
+	}
+}
@@ -0,0 +1,295 @@
+package pw_unit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_unit_test/pb"
+	"google.golang.org/grpc"
+)
+
+const (
+	// kDisabledPrefix disables a test case, as in pw_unit_test and
+	// GoogleTest.
+	kDisabledPrefix = "DISABLED_"
+)
+
+var (
+	ErrDuplicateTest = errors.New("duplicate test case")
+)
+
+// TestFunc is the body of a test case.
+type TestFunc func(t *T)
+
+// Test is a test case of a suite.
+type Test struct {
+	Name string
+	Func TestFunc
+	// Disabled tests are reported but not run. A name starting with
+	// DISABLED_ also disables a test.
+	Disabled bool
+}
+
+func (test *Test) disabled() bool {
+	return test.Disabled || strings.HasPrefix(test.Name, kDisabledPrefix)
+}
+
+// file returns the source file of the test function.
+func (test *Test) file() string {
+	if test.Func == nil {
+		return ""
+	}
+
+	file, _ := runtime.FuncForPC(reflect.ValueOf(test.Func).Pointer()).FileLine(0)
+
+	return file
+}
+
+// T reports the expectations of a running test case, like testing.T.
+type T struct {
+	ctx     context.Context
+	report  func(*pb.TestCaseExpectation) error
+	mu      sync.Mutex
+	failed  bool
+	skipped bool
+	err     error
+}
+
+// Context is done when the test run is cancelled.
+func (t *T) Context() context.Context {
+	return t.ctx
+}
+
+// callerLine is the line of the test code that called a T method.
+func callerLine() int {
+	_, _, line, _ := runtime.Caller(2)
+	return line
+}
+
+func (t *T) expect(line int, success bool, expression string, evaluated string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !success {
+		t.failed = true
+	}
+
+	if t.err == nil {
+		t.err = t.report(&pb.TestCaseExpectation{
+			Expression:          expression,
+			EvaluatedExpression: evaluated,
+			LineNumber:          uint32(line),
+			Success:             success,
+		})
+	}
+
+	return success
+}
+
+// Expect records whether expression held. It returns success.
+func (t *T) Expect(success bool, expression string) bool {
+	return t.expect(callerLine(), success, expression, "")
+}
+
+// ExpectEqual records whether got equals want, as by reflect.DeepEqual.
+func (t *T) ExpectEqual(got any, want any) bool {
+	return t.expect(callerLine(), reflect.DeepEqual(got, want), "got == want", fmt.Sprintf("%v == %v", got, want))
+}
+
+// Errorf records a failed expectation with a formatted message.
+func (t *T) Errorf(format string, args ...any) {
+	t.expect(callerLine(), false, fmt.Sprintf(format, args...), "")
+}
+
+// Fatalf is Errorf followed by FailNow.
+func (t *T) Fatalf(format string, args ...any) {
+	t.expect(callerLine(), false, fmt.Sprintf(format, args...), "")
+	t.FailNow()
+}
+
+// FailNow marks the test case failed and stops it. It must be called from
+// the test function's goroutine.
+func (t *T) FailNow() {
+	t.mu.Lock()
+	t.failed = true
+	t.mu.Unlock()
+
+	runtime.Goexit()
+}
+
+// Failed reports whether the test case has failed.
+func (t *T) Failed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.failed
+}
+
+// Skip stops the test case and reports it skipped, unless it already failed.
+// It must be called from the test function's goroutine.
+func (t *T) Skip() {
+	t.mu.Lock()
+	t.skipped = true
+	t.mu.Unlock()
+
+	runtime.Goexit()
+}
+
+func (t *T) result() pb.TestCaseResult {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case t.failed:
+		return pb.TestCaseResult_FAILURE
+	case t.skipped:
+		return pb.TestCaseResult_SKIPPED
+	}
+
+	return pb.TestCaseResult_SUCCESS
+}
+
+// Server runs registered Go test functions for pw.unit_test.UnitTest Run
+// requests, so that Go code can stand in for a device's tests.
+type Server interface {
+	pb.UnitTestServer
+	// AddSuite registers tests in suite. Suites run in the order they were
+	// first added.
+	AddSuite(suite string, tests ...Test) error
+}
+
+type server struct {
+	pb.UnimplementedUnitTestServer
+	suites []string
+	tests  map[string][]Test
+	mu     sync.Mutex
+}
+
+func NewServer() Server {
+	return &server{
+		tests: map[string][]Test{},
+	}
+}
+
+func (s *server) AddSuite(suite string, tests ...Test) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.tests[suite]
+	if !ok {
+		s.suites = append(s.suites, suite)
+	}
+
+	for _, test := range tests {
+		for _, other := range existing {
+			if other.Name == test.Name {
+				return fmt.Errorf("%w: %s.%s", ErrDuplicateTest, suite, test.Name)
+			}
+		}
+		existing = append(existing, test)
+	}
+
+	s.tests[suite] = existing
+
+	return nil
+}
+
+// runTest runs a test case in its own goroutine, so that FailNow and Skip can
+// end it, and a panic fails it rather than the server.
+func runTest(ctx context.Context, test *Test, report func(*pb.TestCaseExpectation) error) (pb.TestCaseResult, error) {
+	t := &T{ctx: ctx, report: report}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if r := recover(); r != nil {
+				t.expect(0, false, fmt.Sprintf("panic: %v", r), "")
+			}
+		}()
+
+		test.Func(t)
+	}()
+	<-done
+
+	return t.result(), t.err
+}
+
+func (s *server) Run(request *pb.TestRunRequest, stream grpc.ServerStreamingServer[pb.Event]) error {
+	s.mu.Lock()
+	suites := s.suites
+	if len(request.GetTestSuite()) > 0 {
+		suites = request.GetTestSuite()
+	}
+	tests := map[string][]Test{}
+	for _, suite := range suites {
+		tests[suite] = s.tests[suite]
+	}
+	s.mu.Unlock()
+
+	err := stream.Send(&pb.Event{Type: &pb.Event_TestRunStart{TestRunStart: &pb.TestRunStart{}}})
+	if err != nil {
+		return err
+	}
+
+	end := &pb.TestRunEnd{}
+
+	for _, suite := range suites {
+		for _, test := range tests[suite] {
+			if err := stream.Context().Err(); err != nil {
+				return err
+			}
+
+			descriptor := &pb.TestCaseDescriptor{
+				SuiteName: suite,
+				TestName:  test.Name,
+				FileName:  test.file(),
+			}
+
+			if test.disabled() {
+				end.Disabled++
+				err := stream.Send(&pb.Event{Type: &pb.Event_TestCaseDisabled{TestCaseDisabled: descriptor}})
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			err := stream.Send(&pb.Event{Type: &pb.Event_TestCaseStart{TestCaseStart: descriptor}})
+			if err != nil {
+				return err
+			}
+
+			result, err := runTest(stream.Context(), &test, func(e *pb.TestCaseExpectation) error {
+				if e.Success && !request.GetReportPassedExpectations() {
+					return nil
+				}
+				return stream.Send(&pb.Event{Type: &pb.Event_TestCaseExpectation{TestCaseExpectation: e}})
+			})
+			if err != nil {
+				return err
+			}
+
+			switch result {
+			case pb.TestCaseResult_SUCCESS:
+				end.Passed++
+			case pb.TestCaseResult_FAILURE:
+				end.Failed++
+			case pb.TestCaseResult_SKIPPED:
+				end.Skipped++
+			}
+
+			err = stream.Send(&pb.Event{Type: &pb.Event_TestCaseEnd{TestCaseEnd: result}})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return stream.Send(&pb.Event{Type: &pb.Event_TestRunEnd{TestRunEnd: end}})
+}
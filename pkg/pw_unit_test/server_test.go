@@ -0,0 +1,195 @@
+package pw_unit_test_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpctest"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_unit_test"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_unit_test/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+func newTestServer(t *testing.T) pw_unit_test.Server {
+	t.Helper()
+
+	uts := pw_unit_test.NewServer()
+
+	err := uts.AddSuite("Good",
+		pw_unit_test.Test{Name: "Equal", Func: func(t *pw_unit_test.T) {
+			t.ExpectEqual(2, 2)
+			t.Expect(true, "true")
+		}},
+		pw_unit_test.Test{Name: "DISABLED_Prefix", Func: func(t *pw_unit_test.T) {
+			t.Errorf("disabled test ran")
+		}},
+		pw_unit_test.Test{Name: "Flagged", Disabled: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = uts.AddSuite("Bad",
+		pw_unit_test.Test{Name: "Fatal", Func: func(t *pw_unit_test.T) {
+			t.Fatalf("stop")
+			t.Errorf("ran after Fatalf")
+		}},
+		pw_unit_test.Test{Name: "Panics", Func: func(t *pw_unit_test.T) {
+			panic("boom")
+		}},
+		pw_unit_test.Test{Name: "Skips", Func: func(t *pw_unit_test.T) {
+			t.Skip()
+		}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return uts
+}
+
+// events runs a request against uts and returns the events it sent.
+func events(t *testing.T, uts pw_unit_test.Server, request *pb.TestRunRequest) []*pb.Event {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s := pw_rpc.NewServer("")
+	pb.RegisterUnitTestServer(s, uts)
+
+	c, err := pw_rpctest.NewServerClient(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	stream, err := pb.NewUnitTestClient(c).Run(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []*pb.Event
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			return got
+		}
+		got = append(got, event)
+	}
+}
+
+func TestServerEvents(t *testing.T) {
+	got := events(t, newTestServer(t), &pb.TestRunRequest{TestSuite: []string{"Good"}})
+
+	want := []*pb.Event{
+		{Type: &pb.Event_TestRunStart{TestRunStart: &pb.TestRunStart{}}},
+		caseStart("Good", "Equal"),
+		caseEnd(pb.TestCaseResult_SUCCESS),
+		{Type: &pb.Event_TestCaseDisabled{TestCaseDisabled: &pb.TestCaseDescriptor{SuiteName: "Good", TestName: "DISABLED_Prefix"}}},
+		{Type: &pb.Event_TestCaseDisabled{TestCaseDisabled: &pb.TestCaseDescriptor{SuiteName: "Good", TestName: "Flagged"}}},
+		{Type: &pb.Event_TestRunEnd{TestRunEnd: &pb.TestRunEnd{Passed: 1, Disabled: 2}}},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d: %v", len(got), len(want), got)
+	}
+
+	for i := range want {
+		// File names depend on where the tests are built.
+		if start := got[i].GetTestCaseStart(); start != nil {
+			start.FileName = "test.cc"
+		}
+		if disabled := got[i].GetTestCaseDisabled(); disabled != nil {
+			disabled.FileName = ""
+		}
+
+		if !proto.Equal(got[i], want[i]) {
+			t.Errorf("event %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestServerReportsPassedExpectations(t *testing.T) {
+	uts := newTestServer(t)
+
+	for _, report := range []bool{false, true} {
+		request := &pb.TestRunRequest{TestSuite: []string{"Good"}, ReportPassedExpectations: report}
+
+		var expectations []*pb.TestCaseExpectation
+		for _, event := range events(t, uts, request) {
+			if e := event.GetTestCaseExpectation(); e != nil {
+				expectations = append(expectations, e)
+			}
+		}
+
+		if !report {
+			if len(expectations) != 0 {
+				t.Errorf("passed expectations reported: %v", expectations)
+			}
+			continue
+		}
+
+		if len(expectations) != 2 || expectations[0].EvaluatedExpression != "2 == 2" || expectations[1].Expression != "true" || expectations[0].LineNumber == 0 {
+			t.Errorf("expectations = %v", expectations)
+		}
+	}
+}
+
+func TestServerWithRunner(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s := pw_rpc.NewServer("")
+	pb.RegisterUnitTestServer(s, newTestServer(t))
+
+	c, err := pw_rpctest.NewServerClient(ctx, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	results, err := pw_unit_test.Run(ctx, pb.NewUnitTestClient(c), &pb.TestRunRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]pw_unit_test.Outcome{
+		"Good.Equal":           pw_unit_test.Passed,
+		"Good.DISABLED_Prefix": pw_unit_test.Disabled,
+		"Good.Flagged":         pw_unit_test.Disabled,
+		"Bad.Fatal":            pw_unit_test.Failed,
+		"Bad.Panics":           pw_unit_test.Failed,
+		"Bad.Skips":            pw_unit_test.Skipped,
+	}
+	if len(results.Cases) != len(want) {
+		t.Fatalf("got %d test cases, want %d", len(results.Cases), len(want))
+	}
+	for _, tc := range results.Cases {
+		if tc.Outcome != want[tc.FullName()] {
+			t.Errorf("%s = %s, want %s", tc.FullName(), tc.Outcome, want[tc.FullName()])
+		}
+	}
+
+	fatal := results.Cases[3]
+	if len(fatal.Expectations) != 1 || fatal.Expectations[0].Expression != "stop" {
+		t.Errorf("Bad.Fatal expectations = %v", fatal.Expectations)
+	}
+
+	summary := results.Summary
+	if summary.Passed != 1 || summary.Failed != 2 || summary.Skipped != 1 || summary.Disabled != 2 {
+		t.Errorf("TestRunEnd = %v", summary)
+	}
+}
+
+func TestAddSuiteRejectsDuplicates(t *testing.T) {
+	uts := newTestServer(t)
+
+	err := uts.AddSuite("Good", pw_unit_test.Test{Name: "Equal", Func: func(*pw_unit_test.T) {}})
+	if !errors.Is(err, pw_unit_test.ErrDuplicateTest) {
+		t.Errorf("AddSuite = %v, want %v", err, pw_unit_test.ErrDuplicateTest)
+	}
+}
@@ -57,7 +57,8 @@ func Encode(
 		term = byte(0x00) << term_shift
 	}
 
-	for val != 0 {
+	// Zero is encoded as a single byte, so the loop always runs once.
+	for {
 		last_byte := (val >> 7) == 0
 
 		// Grab 7 bits and set the eighth according to the continuation bit.
@@ -72,6 +73,10 @@ func Encode(
 		output[written] = value
 		written++
 		val >>= 7
+
+		if last_byte {
+			break
+		}
 	}
 
 	return output[0:written]
@@ -1,10 +1,94 @@
 package pw_varint
 
-import "testing"
+import (
+	"bytes"
+	"testing"
+)
+
+var formats = []Format{
+	ZeroTerminatedLeastSignificant,
+	ZeroTerminatedMostSignificant,
+	OneTerminatedLeastSignificant,
+	OneTerminatedMostSignificant,
+}
 
 func TestDecode(t *testing.T) {
-	_, value := Decode([]byte{0x01, 0x10}, ZeroTerminatedLeastSignificant)
+	value, _ := Decode([]byte{0x01, 0x10}, ZeroTerminatedLeastSignificant)
 	if value != 1024 {
 		t.Fatalf("%d != %d", value, 1024)
 	}
 }
+
+// kGoldenVarints are encodings from Pigweed's varint tests, in the standard
+// LEB128 format, and of HDLC addresses, which are one-terminated.
+var kGoldenVarints = []struct {
+	format  Format
+	value   uint64
+	encoded []byte
+}{
+	{ZeroTerminatedMostSignificant, 0, []byte{0x00}},
+	{ZeroTerminatedMostSignificant, 1, []byte{0x01}},
+	{ZeroTerminatedMostSignificant, 127, []byte{0x7f}},
+	{ZeroTerminatedMostSignificant, 128, []byte{0x80, 0x01}},
+	{ZeroTerminatedMostSignificant, 255, []byte{0xff, 0x01}},
+	{ZeroTerminatedMostSignificant, 16384, []byte{0x80, 0x80, 0x01}},
+	{ZeroTerminatedMostSignificant, 1<<32 - 1, []byte{0xff, 0xff, 0xff, 0xff, 0x0f}},
+	{ZeroTerminatedMostSignificant, 1<<64 - 1, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+	{ZeroTerminatedLeastSignificant, 0, []byte{0x00}},
+	{ZeroTerminatedLeastSignificant, 1024, []byte{0x01, 0x10}},
+	{OneTerminatedLeastSignificant, 0, []byte{0x01}},
+	{OneTerminatedLeastSignificant, 'R', []byte{0xa5}},
+	{OneTerminatedLeastSignificant, 0x7b, []byte{0xf7}},
+	{OneTerminatedLeastSignificant, 1000, []byte{0xd0, 0x0f}},
+	{OneTerminatedMostSignificant, 0, []byte{0x80}},
+	{OneTerminatedMostSignificant, 128, []byte{0x00, 0x81}},
+}
+
+func TestGoldenVarints(t *testing.T) {
+	for _, golden := range kGoldenVarints {
+		if encoded := Encode(golden.value, golden.format); !bytes.Equal(encoded, golden.encoded) {
+			t.Errorf("format %d: Encode(%d) = %x, want %x", golden.format, golden.value, encoded, golden.encoded)
+		}
+
+		if value, count := Decode(golden.encoded, golden.format); value != golden.value || count != len(golden.encoded) {
+			t.Errorf("format %d: Decode(%x) = %d, %d; want %d", golden.format, golden.encoded, value, count, golden.value)
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{0x00})
+	f.Add([]byte{0x01, 0x10})
+	f.Add([]byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		for _, format := range formats {
+			_, count := Decode(input, format)
+			if count < 0 || count > len(input) || count > MaxVarint64SizeBytes {
+				t.Fatalf("format %d: Decode(%x) read %d bytes", format, input, count)
+			}
+		}
+	})
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	for _, value := range []uint64{0, 1, 63, 64, 127, 128, 1024, 1<<32 - 1, 1<<63 + 1, 1<<64 - 1} {
+		f.Add(value)
+	}
+
+	f.Fuzz(func(t *testing.T, value uint64) {
+		for _, format := range formats {
+			encoded := Encode(value, format)
+			if len(encoded) == 0 || len(encoded) > MaxVarint64SizeBytes {
+				t.Fatalf("format %d: Encode(%d) = %x", format, value, encoded)
+			}
+
+			decoded, count := Decode(encoded, format)
+			if decoded != value || count != len(encoded) {
+				t.Fatalf("format %d: Decode(%x) = %d, %d; want %d, %d", format, encoded, decoded, count, value, len(encoded))
+			}
+		}
+	})
+}
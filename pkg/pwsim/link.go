@@ -0,0 +1,119 @@
+package pwsim
+
+import (
+	"io"
+	"time"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/faultconn"
+)
+
+const (
+	// kBitsPerByte is the bits a UART sends per byte: a start bit, eight
+	// data bits and a stop bit.
+	kBitsPerByte = 10
+	kChunkSize   = 256
+	kQueueSize   = 64
+)
+
+// Impairments describe how a simulated serial line mistreats its bytes. The
+// zero value is a perfect line.
+type Impairments struct {
+	// BaudRate throttles the line to the speed of a UART, 0 for no limit.
+	BaudRate int
+	// Latency delays every byte.
+	Latency time.Duration
+	// Faults are injected into the bytes in both directions.
+	faultconn.Faults
+}
+
+func (imp Impairments) byteTime() time.Duration {
+	if imp.BaudRate <= 0 {
+		return 0
+	}
+
+	return time.Second * kBitsPerByte / time.Duration(imp.BaudRate)
+}
+
+// Link copies bytes between host and device in both directions, impairing
+// them on the way, until either side fails. Both are closed on return.
+func Link(host io.ReadWriteCloser, device io.ReadWriteCloser, imp Impairments) error {
+	errs := make(chan error, 2)
+
+	host = faultconn.New(host, imp.Faults)
+
+	go func() {
+		errs <- pump(device, host, imp)
+	}()
+	go func() {
+		errs <- pump(host, device, imp)
+	}()
+
+	err := <-errs
+	host.Close()
+	device.Close()
+	<-errs
+
+	return err
+}
+
+// chunk is read data waiting to be delivered.
+type chunk struct {
+	data []byte
+	due  time.Time
+}
+
+// pump writes the bytes read from src to dst once they are due. Reading
+// continues while bytes are in flight, as on a real line.
+func pump(dst io.Writer, src io.Reader, imp Impairments) error {
+	queue := make(chan chunk, kQueueSize)
+	done := make(chan struct{})
+
+	var werr error
+	go func() {
+		defer close(done)
+
+		byteTime := imp.byteTime()
+		next := time.Now()
+
+		for c := range queue {
+			// The line is busy until the previous bytes are sent.
+			if c.due.Before(next) {
+				c.due = next
+			}
+			time.Sleep(time.Until(c.due))
+
+			for i := range c.data {
+				if _, err := dst.Write(c.data[i : i+1]); err != nil {
+					werr = err
+					return
+				}
+
+				if byteTime > 0 {
+					time.Sleep(time.Until(c.due.Add(time.Duration(i+1) * byteTime)))
+				}
+			}
+
+			next = c.due.Add(time.Duration(len(c.data)) * byteTime)
+		}
+	}()
+
+	buf := make([]byte, kChunkSize)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			data := append([]byte(nil), buf[:n]...)
+
+			select {
+			case queue <- chunk{data: data, due: time.Now().Add(imp.Latency)}:
+			case <-done:
+				return werr
+			}
+		}
+
+		if err != nil {
+			close(queue)
+			<-done
+			return err
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package pwsim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"golang.org/x/sys/unix"
+)
+
+type ptySimulator struct {
+	server pw_rpc.Server
+	imp    Impairments
+	master *os.File
+	slave  *os.File
+	path   string
+}
+
+// NewPty simulates a device on a new pseudo terminal. Hosts open Path() as
+// they would a serial port.
+func NewPty(server pw_rpc.Server, imp Impairments) (Simulator, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := openPty(master)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	// Holding the terminal open keeps the master readable while no host is
+	// connected, instead of failing with EIO.
+	slave, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	return &ptySimulator{
+		server: server,
+		imp:    imp,
+		master: master,
+		slave:  slave,
+		path:   path,
+	}, nil
+}
+
+// openPty unlocks the terminal of a pty master, puts it in raw mode so that
+// HDLC frames pass unchanged, and returns its path.
+func openPty(master *os.File) (path string, err error) {
+	rc, err := master.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+
+	cerr := rc.Control(func(fd uintptr) {
+		if err = unix.IoctlSetPointerInt(int(fd), unix.TIOCSPTLCK, 0); err != nil {
+			return
+		}
+
+		var n uint32
+		if n, err = unix.IoctlGetUint32(int(fd), unix.TIOCGPTN); err != nil {
+			return
+		}
+		path = fmt.Sprintf("/dev/pts/%d", n)
+
+		var termios *unix.Termios
+		if termios, err = unix.IoctlGetTermios(int(fd), unix.TCGETS); err != nil {
+			return
+		}
+		makeRaw(termios)
+		err = unix.IoctlSetTermios(int(fd), unix.TCSETS, termios)
+	})
+	if cerr != nil {
+		return "", cerr
+	}
+
+	return path, err
+}
+
+// makeRaw is cfmakeraw(3).
+func makeRaw(t *unix.Termios) {
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB
+	t.Cflag |= unix.CS8
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+}
+
+func (p *ptySimulator) Path() string {
+	return p.path
+}
+
+func (p *ptySimulator) Serve(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		p.Close()
+	}()
+
+	// The pty stays open across hosts; the line only ends when it is closed.
+	err := serveLink(ctx, p.server, &ptyMaster{p.master}, p.imp)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if errors.Is(err, os.ErrClosed) {
+		return ErrClosed
+	}
+
+	return err
+}
+
+func (p *ptySimulator) Close() error {
+	p.slave.Close()
+	return p.master.Close()
+}
+
+// ptyMaster retries reads that fail while no host has the terminal open.
+type ptyMaster struct {
+	*os.File
+}
+
+func (m *ptyMaster) Read(b []byte) (int, error) {
+	for {
+		n, err := m.File.Read(b)
+		if !errors.Is(err, syscall.EIO) {
+			return n, err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package pwsim
+
+import "github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+
+// NewPty simulates a device on a new pseudo terminal. It is only supported
+// on Linux.
+func NewPty(server pw_rpc.Server, imp Impairments) (Simulator, error) {
+	return nil, ErrUnsupported
+}
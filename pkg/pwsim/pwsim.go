@@ -0,0 +1,106 @@
+// Package pwsim simulates a device: it serves pw_rpc services over a pseudo
+// terminal or a Unix socket, through a serial line with realistic faults.
+// Host tools connect to it as they would to a microcontroller.
+package pwsim
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+)
+
+var (
+	ErrUnsupported = errors.New("not supported on this platform")
+	ErrClosed      = errors.New("simulator closed")
+)
+
+// Simulator is a simulated device.
+type Simulator interface {
+	// Path is where the host connects: a terminal device or a socket.
+	Path() string
+	// Serve runs the device until ctx is done or the simulator is closed.
+	Serve(ctx context.Context) error
+	Close() error
+}
+
+// serveLink serves a host connection through an impaired line.
+func serveLink(ctx context.Context, server pw_rpc.Server, host io.ReadWriteCloser, imp Impairments) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	line, device := net.Pipe()
+
+	go func() {
+		<-ctx.Done()
+		line.Close()
+	}()
+
+	go server.Serve(ctx, device)
+
+	return Link(host, line, imp)
+}
+
+type socketSimulator struct {
+	server pw_rpc.Server
+	path   string
+	imp    Impairments
+	lis    net.Listener
+	mu     sync.Mutex
+}
+
+// NewUnixSocket simulates a device that accepts hosts on a Unix socket at
+// path. An existing socket file is replaced.
+func NewUnixSocket(server pw_rpc.Server, path string, imp Impairments) (Simulator, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &socketSimulator{
+		server: server,
+		path:   path,
+		imp:    imp,
+		lis:    lis,
+	}, nil
+}
+
+func (s *socketSimulator) Path() string {
+	return s.path
+}
+
+func (s *socketSimulator) Serve(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		s.Close()
+	}()
+
+	// A device talks to one host at a time. A host that drops the link, or
+	// whose link fails, is replaced by the next one to connect.
+	for {
+		conn, err := s.lis.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return ErrClosed
+		}
+
+		serveLink(ctx, s.server, conn, s.imp)
+	}
+}
+
+func (s *socketSimulator) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lis.Close()
+}
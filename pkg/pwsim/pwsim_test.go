@@ -0,0 +1,84 @@
+package pwsim_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpc"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pw_rpctest"
+	"github.com/robertfarnum/go-pw-rpc/pkg/pwsim"
+)
+
+func newServer(t *testing.T) pw_rpc.Server {
+	server, err := pw_rpctest.NewEchoServer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return server
+}
+
+func echo(t *testing.T, ctx context.Context, dialer pw_rpc.Dialer) time.Duration {
+	t.Helper()
+
+	c := pw_rpc.NewClientWithDialer(dialer)
+	defer c.Close()
+
+	start := time.Now()
+	if err := pw_rpctest.Echo(ctx, c, make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+
+	return time.Since(start)
+}
+
+func TestUnixSocket(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// 100 byte payloads take more than 100ms each way at 9600 baud.
+	sim, err := pwsim.NewUnixSocket(newServer(t), filepath.Join(t.TempDir(), "sim.sock"), pwsim.Impairments{
+		BaudRate: 9600,
+		Latency:  10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go sim.Serve(ctx)
+	defer sim.Close()
+
+	elapsed := echo(t, ctx, func(ctx context.Context) (io.ReadWriteCloser, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", sim.Path())
+	})
+
+	if elapsed < 200*time.Millisecond {
+		t.Errorf("echo took %s at 9600 baud", elapsed)
+	}
+}
+
+func TestPty(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sim, err := pwsim.NewPty(newServer(t), pwsim.Impairments{})
+	if err == pwsim.ErrUnsupported {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	go sim.Serve(ctx)
+	defer sim.Close()
+
+	for i := 0; i < 2; i++ {
+		echo(t, ctx, func(ctx context.Context) (io.ReadWriteCloser, error) {
+			return os.OpenFile(sim.Path(), os.O_RDWR|syscall.O_NOCTTY, 0)
+		})
+	}
+}